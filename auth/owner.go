@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Owner IDs end with the fingerprint of the public key the owner signs with,
+// name-<fingerprint>, so that any node tells whether a key is the one of an
+// owner without having to trust the first key it saw for it.
+
+// Length of the fingerprint in an owner ID, the hex of 16 bytes of the
+// SHA-256 of the public key.
+const fingerprintLen = 32
+
+// ErrOwnerMismatch is returned when a public key is not the one of an owner
+// ID.
+var ErrOwnerMismatch = errors.New("public key does not match the owner ID")
+
+// OwnerID returns the ID of the owner named name signing with pub. A name
+// which already is an ID of pub is returned as is.
+func OwnerID(name string, pub ed25519.PublicKey) string {
+	if CheckOwner(name, pub) == nil {
+		return name
+	}
+	return name + "-" + Fingerprint(pub)
+}
+
+// Fingerprint returns the fingerprint of the public key owner IDs end with.
+func Fingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:fingerprintLen/2])
+}
+
+// CheckOwner checks that pub is the key of the owner ID.
+func CheckOwner(id string, pub ed25519.PublicKey) error {
+	i := len(id) - fingerprintLen - 1
+	if i <= 0 || id[i] != '-' || id[i+1:] != Fingerprint(pub) {
+		return fmt.Errorf("%w: %s", ErrOwnerMismatch, id)
+	}
+	return nil
+}
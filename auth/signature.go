@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidSignature is returned for a version whose digest the owner did
+// not sign.
+var ErrInvalidSignature = errors.New("invalid version signature")
+
+// VersionSignature is the signature of the owner over the digest of a
+// version of a file, so that a blob fetched from the peers can be told to be
+// the one the owner wrote. The key of the file is left out, it changes when
+// the file is moved while the version ID stays.
+type VersionSignature struct {
+	Owner     string `json:"owner"`
+	VersionID string `json:"version_id"`
+	// Hex encoded sha256 of the blob.
+	Digest    string            `json:"digest"`
+	PublicKey ed25519.PublicKey `json:"public_key"`
+	Signature []byte            `json:"signature"`
+}
+
+// SignVersion returns the signature of the owner over the digest of the
+// version.
+func SignVersion(key ed25519.PrivateKey, owner string, versionID string, digest string) *VersionSignature {
+	s := &VersionSignature{
+		Owner:     owner,
+		VersionID: versionID,
+		Digest:    digest,
+		PublicKey: key.Public().(ed25519.PublicKey),
+	}
+	s.Signature = ed25519.Sign(key, s.claims())
+	return s
+}
+
+// Verify checks that the owner signed digest as the one of the version.
+func (s *VersionSignature) Verify(owner string, versionID string, digest string) error {
+	if s == nil {
+		return fmt.Errorf("%w: missing", ErrInvalidSignature)
+	}
+	if len(s.PublicKey) != ed25519.PublicKeySize || !ed25519.Verify(s.PublicKey, s.claims(), s.Signature) {
+		return fmt.Errorf("%w: bad signature", ErrInvalidSignature)
+	}
+	if err := CheckOwner(owner, s.PublicKey); err != nil || s.Owner != owner {
+		return fmt.Errorf("%w: not signed by %s", ErrInvalidSignature, owner)
+	}
+	if s.VersionID != versionID || s.Digest != digest {
+		return fmt.Errorf("%w: signed for version %s with digest %s", ErrInvalidSignature, s.VersionID, s.Digest)
+	}
+	return nil
+}
+
+// claims returns the signed bytes, everything but the signature.
+func (s *VersionSignature) claims() []byte {
+	unsigned := *s
+	unsigned.Signature = nil
+	b, _ := json.Marshal(unsigned)
+	return b
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Operations a token can allow.
+const (
+	OpStore  = "store"
+	OpGet    = "get"
+	OpDelete = "delete"
+)
+
+// ErrInvalidToken is returned for a missing token or one which does not allow
+// the operation.
+var ErrInvalidToken = errors.New("invalid capability token")
+
+// Token is a capability issued and signed by the owner of the files, it
+// allows its bearer the operations on a single key until it expires, or on
+// all the keys below the prefix when it ends with a slash.
+type Token struct {
+	Owner string `json:"owner"`
+	// Public key of the user the token was issued to, empty for a token any
+	// bearer can use.
+	Subject string `json:"subject,omitempty"`
+	// ID of the request the token was issued for, empty for a token not tied
+	// to a single request.
+	Audience string    `json:"audience,omitempty"`
+	Ops      []string  `json:"ops"`
+	Prefix   string    `json:"prefix"`
+	Expiry   time.Time `json:"expiry"`
+	// Delete a delete token was issued for, it travels along with the
+	// tombstone and is checked again long after the request.
+	Deletion *Deletion `json:"deletion,omitempty"`
+	// Ed25519 public key of the owner, the one the signature is checked with.
+	PublicKey ed25519.PublicKey `json:"public_key"`
+	Signature []byte            `json:"signature"`
+}
+
+// Deletion is the version of the key a delete removed and when.
+type Deletion struct {
+	VersionID string    `json:"version_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// Issue returns a token signed with the owner's key, subject is empty for a
+// bearer token and audience for a token usable with any request.
+func Issue(key ed25519.PrivateKey, owner string, subject string, audience string, prefix string, ttl time.Duration, ops ...string) *Token {
+	t := &Token{
+		Owner:     owner,
+		Subject:   subject,
+		Audience:  audience,
+		Ops:       ops,
+		Prefix:    prefix,
+		Expiry:    time.Now().Add(ttl).UTC(),
+		PublicKey: key.Public().(ed25519.PublicKey),
+	}
+	t.Signature = ed25519.Sign(key, t.claims())
+	return t
+}
+
+// IssueDelete returns a bearer token of the owner allowing the delete of the
+// version versionID of fileKey at deletedAt only.
+func IssueDelete(key ed25519.PrivateKey, owner string, audience string, fileKey string, versionID string, deletedAt time.Time, ttl time.Duration) *Token {
+	t := Issue(key, owner, "", audience, fileKey, ttl, OpDelete)
+	t.Deletion = &Deletion{VersionID: versionID, DeletedAt: deletedAt.UTC()}
+	t.Signature = ed25519.Sign(key, t.claims())
+	return t
+}
+
+// Verify checks that the token is signed by the key of owner and allows op
+// on its key at the given time.
+func (t *Token) Verify(owner string, op string, key string, at time.Time) error {
+	if t == nil {
+		return fmt.Errorf("%w: missing", ErrInvalidToken)
+	}
+	if len(t.PublicKey) != ed25519.PublicKeySize || !ed25519.Verify(t.PublicKey, t.claims(), t.Signature) {
+		return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+	if t.Owner != owner {
+		return fmt.Errorf("%w: issued by %s for the files of %s", ErrInvalidToken, t.Owner, owner)
+	}
+	if err := CheckOwner(owner, t.PublicKey); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if !t.allows(op) {
+		return fmt.Errorf("%w: %s not allowed", ErrInvalidToken, op)
+	}
+	if !t.covers(key) {
+		return fmt.Errorf("%w: %s outside of %q", ErrInvalidToken, key, t.Prefix)
+	}
+	if at.After(t.Expiry) {
+		return fmt.Errorf("%w: expired at %s", ErrInvalidToken, t.Expiry.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// VerifyDelete checks that the token is one of the owner for the delete of
+// the version versionID of key at deletedAt, and that it was valid then.
+func (t *Token) VerifyDelete(owner string, key string, versionID string, deletedAt time.Time) error {
+	if err := t.Verify(owner, OpDelete, key, deletedAt); err != nil {
+		return err
+	}
+	if t.Deletion == nil || t.Deletion.VersionID != versionID || !t.Deletion.DeletedAt.Equal(deletedAt) {
+		return fmt.Errorf("%w: issued for another delete of %s", ErrInvalidToken, key)
+	}
+	return nil
+}
+
+// covers reports whether the token applies to key, the key itself or one
+// below it for a prefix ending with a slash.
+func (t *Token) covers(key string) bool {
+	if strings.HasSuffix(t.Prefix, "/") {
+		return strings.HasPrefix(key, t.Prefix)
+	}
+	return key == t.Prefix
+}
+
+func (t *Token) allows(op string) bool {
+	for _, o := range t.Ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// claims returns the signed bytes, everything but the signature.
+func (t *Token) claims() []byte {
+	unsigned := *t
+	unsigned.Signature = nil
+	b, _ := json.Marshal(unsigned)
+	return b
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenVerify(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	alice := OwnerID("alice", pub)
+	token := Issue(key, alice, "", "", "docs/", time.Minute, OpGet, OpStore)
+	now := time.Now()
+
+	if err := token.Verify(alice, OpGet, "docs/a.txt", now); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	for name, err := range map[string]error{
+		"other owner":   token.Verify(OwnerID("bob", pub), OpGet, "docs/a.txt", now),
+		"other op":      token.Verify(alice, OpDelete, "docs/a.txt", now),
+		"other prefix":  token.Verify(alice, OpGet, "pics/a.jpg", now),
+		"expired":       token.Verify(alice, OpGet, "docs/a.txt", now.Add(time.Hour)),
+		"missing token": (*Token)(nil).Verify(alice, OpGet, "docs/a.txt", now),
+		"other key":     Issue(other, alice, "", "", "docs/", time.Minute, OpGet).Verify(alice, OpGet, "docs/a.txt", now),
+	} {
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: want ErrInvalidToken have %v", name, err)
+		}
+	}
+
+	token.Prefix = ""
+	if err := token.Verify(alice, OpGet, "pics/a.jpg", now); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("a tampered token must not verify, have %v", err)
+	}
+}
+
+func TestTokenSingleKey(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	alice := OwnerID("alice", pub)
+	token := Issue(key, alice, "", "", "docs", time.Minute, OpGet)
+	now := time.Now()
+
+	if err := token.Verify(alice, OpGet, "docs", now); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	for _, k := range []string{"docsX", "docs/x", "doc", ""} {
+		if err := token.Verify(alice, OpGet, k, now); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%q: want ErrInvalidToken have %v", k, err)
+		}
+	}
+}
+
+func TestTokenVerifyDelete(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	alice := OwnerID("alice", pub)
+	deletedAt := time.Now()
+	token := IssueDelete(key, alice, "", "docs/a.txt", "v1", deletedAt, time.Minute)
+
+	if err := token.VerifyDelete(alice, "docs/a.txt", "v1", deletedAt); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	for name, err := range map[string]error{
+		"other key":     token.VerifyDelete(alice, "docs/b.txt", "v1", deletedAt),
+		"other version": token.VerifyDelete(alice, "docs/a.txt", "v2", deletedAt),
+		"redated":       token.VerifyDelete(alice, "docs/a.txt", "v1", deletedAt.Add(time.Second)),
+		"no deletion":   Issue(key, alice, "", "", "docs/a.txt", time.Minute, OpDelete).VerifyDelete(alice, "docs/a.txt", "v1", deletedAt),
+	} {
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: want ErrInvalidToken have %v", name, err)
+		}
+	}
+}
+
+func TestOwnerID(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	id := OwnerID("alice", pub)
+
+	if err := CheckOwner(id, pub); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if OwnerID(id, pub) != id {
+		t.Errorf("want an owner ID kept as is have %s", OwnerID(id, pub))
+	}
+	for _, id := range []string{"alice", "alice-" + Fingerprint(other), "-" + Fingerprint(pub), Fingerprint(pub)} {
+		if err := CheckOwner(id, pub); !errors.Is(err, ErrOwnerMismatch) {
+			t.Errorf("%s: want ErrOwnerMismatch have %v", id, err)
+		}
+	}
+}
+
+func TestVersionSignature(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	alice := OwnerID("alice", pub)
+	sig := SignVersion(key, alice, "v1", "abcd")
+
+	if err := sig.Verify(alice, "v1", "abcd"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	for name, err := range map[string]error{
+		"other digest":  sig.Verify(alice, "v1", "dcba"),
+		"other version": sig.Verify(alice, "v2", "abcd"),
+		"other owner":   sig.Verify(OwnerID("bob", pub), "v1", "abcd"),
+		"other key":     SignVersion(other, alice, "v1", "abcd").Verify(alice, "v1", "abcd"),
+		"missing":       (*VersionSignature)(nil).Verify(alice, "v1", "abcd"),
+	} {
+		if !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: want ErrInvalidSignature have %v", name, err)
+		}
+	}
+}
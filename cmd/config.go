@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ranjankuldeep/distributed_file_system/config"
+	"github.com/ranjankuldeep/distributed_file_system/encrypt"
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	forceInit  bool
+	showSecret bool
+)
+var (
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Manage the config file",
+	}
+
+	configInitCmd = &cobra.Command{
+		Use:   "init",
+		Short: "Write a config file with the defaults and a new node ID",
+		Long:  "Write a config file with the defaults and a new node ID to --config or ./" + config.DefaultFile + ", the keystore is created by the first start",
+		Args:  cobra.NoArgs,
+		// The file does not exist yet, there is nothing to load.
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := ConfigPath
+			if len(path) == 0 {
+				path = config.DefaultFile
+			}
+			if _, err := os.Stat(path); err == nil && !forceInit {
+				return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+			}
+
+			cfg := config.Default()
+			cfg.Node.ID = encrypt.GenerateID()
+			if err := cfg.Write(path); err != nil {
+				logs.Logger.Errorf("Error writing the config %s: %v", path, err)
+				return err
+			}
+			logs.Logger.Infof("Config written to %s", path)
+			return nil
+		},
+	}
+
+	configShowCmd = &cobra.Command{
+		Use:   "show",
+		Short: "Print the effective config",
+		Long:  "Print the config after applying the file, the DFS_* environment variables and the flags",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := *nodeConfig
+			if !showSecret {
+				cfg = cfg.Redacted()
+			}
+			b, err := cfg.Marshal()
+			if err != nil {
+				return err
+			}
+			fmt.Print(string(b))
+			return nil
+		},
+	}
+)
+
+func init() {
+	configInitCmd.Flags().BoolVar(&forceInit, "force", false, "Overwrite an existing config file")
+	configShowCmd.Flags().BoolVar(&showSecret, "show-secrets", false, "Print the keys instead of redacting them")
+
+	configCmd.AddCommand(configInitCmd)
+	configCmd.AddCommand(configShowCmd)
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ranjankuldeep/distributed_file_system/control"
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	outputPath string
+	ownerID    string
+	versionID  string
+	resumeGet  bool
+	getOffset  int64
+	getLength  int64
+)
+var (
+	getCmd = &cobra.Command{
+		Use:   "get <key>",
+		Short: "Retrieve a file from the distributed file Storage",
+		Long:  "Retrieve a file from the local store or the network, written to the output path or stdout. Files shared by other users are retrieved with --owner, older versions of your own files with --version. A slice of the file is retrieved with --offset and --length, only that slice travels over the network",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key := args[0]
+			client, err := dialNode()
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			// A download which broke off continues after the bytes written.
+			var offset int64
+			if resumeGet {
+				if len(outputPath) == 0 {
+					return fmt.Errorf("--continue needs --output")
+				}
+				if fi, err := os.Stat(outputPath); err == nil {
+					offset = fi.Size()
+				}
+			}
+			if getOffset < 0 || getLength < 0 {
+				return fmt.Errorf("--offset and --length cannot be negative")
+			}
+			open := control.OpenArgs{Key: key, Owner: ownerID, Version: versionID, Offset: offset}
+			if getOffset > 0 || getLength > 0 {
+				open.Offset, open.Length = getOffset, getLength
+			}
+			file, r, err := client.Open(open)
+			if err != nil {
+				logs.Logger.Errorf("Error Retrieving file %s: %+v", key, err)
+				return err
+			}
+			defer r.Close()
+			if len(file.Siblings) > 0 {
+				logs.Logger.Warnf("%s was written concurrently through other nodes, %d more versions are listed by dfs versions", key, len(file.Siblings))
+			}
+
+			var out io.Writer = os.Stdout
+			if len(outputPath) > 0 {
+				flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+				if offset > 0 {
+					flags = os.O_WRONLY | os.O_APPEND
+				}
+				file, err := os.OpenFile(outputPath, flags, 0666)
+				if err != nil {
+					logs.Logger.Errorf("Error creating the output file %s", outputPath)
+					return err
+				}
+				defer file.Close()
+				out = file
+			}
+
+			progress := &progressWriter{out: os.Stderr, total: file.Size, written: offset}
+			if open.Length > 0 {
+				progress.total = open.Length
+			} else if getOffset > 0 && file.Size > 0 {
+				progress.total = max(file.Size-getOffset, 0)
+			}
+			if _, err := io.Copy(io.MultiWriter(out, progress), r); err != nil {
+				logs.Logger.Errorf("Error writing file %s: %+v", key, err)
+				return err
+			}
+			progress.done()
+			logs.Logger.Info("File Retrieved Succesfully")
+			return nil
+		},
+	}
+)
+
+func init() {
+	getCmd.Flags().StringVarP(&outputPath, "output", "o", "", "Write the file to this path instead of stdout")
+	getCmd.Flags().StringVar(&ownerID, "owner", "", "ID of the user who shared the file (default your own files)")
+	getCmd.Flags().StringVar(&versionID, "version", "", "ID of the version to retrieve, see dfs versions (default the latest)")
+	getCmd.Flags().BoolVarP(&resumeGet, "continue", "c", false, "Continue a download which broke off, after the bytes already in the output file")
+	getCmd.Flags().Int64Var(&getOffset, "offset", 0, "Retrieve the file from this byte on")
+	getCmd.Flags().Int64Var(&getLength, "length", 0, "Retrieve this many bytes of the file (default up to its end)")
+	getCmd.MarkFlagsMutuallyExclusive("owner", "version")
+	getCmd.MarkFlagsMutuallyExclusive("continue", "offset")
+	getCmd.MarkFlagsMutuallyExclusive("continue", "length")
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/config"
+	"github.com/ranjankuldeep/distributed_file_system/encrypt"
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var (
+	keysCmd = &cobra.Command{
+		Use:   "keys",
+		Short: "Manage the encryption keys of the node",
+	}
+
+	keysListCmd = &cobra.Command{
+		Use:   "ls",
+		Short: "List the keys of the keystore",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := dialNode()
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			keys, err := client.Keys()
+			if err != nil {
+				logs.Logger.Errorf("Error Listing keys %+v", err)
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tCREATED\tCURRENT")
+			for _, k := range keys {
+				fmt.Fprintf(w, "%s\t%s\t%t\n", k.ID, k.CreatedAt.Local().Format(time.DateTime), k.Current)
+			}
+			return w.Flush()
+		},
+	}
+
+	keysRotateCmd = &cobra.Command{
+		Use:   "rotate",
+		Short: "Switch to a new key and rewrap the keys of the files with it",
+		Long:  "Switch the node to a new key and rewrap the data keys of your files with it, locally and on the peers. The previous keys are kept for the peers which could not be reached",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := dialNode()
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			reply, err := client.RotateKey()
+			if err != nil {
+				logs.Logger.Errorf("Error Rotating the key %+v", err)
+				return err
+			}
+			logs.Logger.Infof("Rotated to key %s, rewrapped %d files", reply.KeyID, reply.Rewrapped)
+			return nil
+		},
+	}
+)
+
+// openKeystore unlocks the keystore of the node, creating it on the first
+// start with node.enc_key when set.
+func openKeystore(cfg *config.Config) (*encrypt.Keystore, error) {
+	path := cfg.KeystorePath()
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		passphrase, err := keystorePassphrase(cfg, true)
+		if err != nil {
+			return nil, err
+		}
+		ks, err := encrypt.CreateKeystore(path, passphrase, cfg.EncryptionKey())
+		if err != nil {
+			return nil, err
+		}
+		logs.Logger.Infof("Created the keystore %s", path)
+		return ks, nil
+	}
+	passphrase, err := keystorePassphrase(cfg, false)
+	if err != nil {
+		return nil, err
+	}
+	return encrypt.OpenKeystore(path, passphrase)
+}
+
+// keystorePassphrase returns keystore.passphrase, or asks for it on the
+// terminal, twice when the keystore is about to be created.
+func keystorePassphrase(cfg *config.Config, confirm bool) ([]byte, error) {
+	if len(cfg.Keystore.Passphrase) > 0 {
+		return []byte(cfg.Keystore.Passphrase), nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("keystore.passphrase: must be set, eg- with DFS_KEYSTORE_PASSPHRASE, when not run from a terminal")
+	}
+	fmt.Fprint(os.Stderr, "Keystore passphrase: ")
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("keystore.passphrase: must not be empty")
+	}
+	if !confirm {
+		return passphrase, nil
+	}
+	fmt.Fprint(os.Stderr, "Repeat the passphrase: ")
+	again, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(passphrase, again) {
+		return nil, fmt.Errorf("the passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+func init() {
+	keysCmd.AddCommand(keysListCmd)
+	keysCmd.AddCommand(keysRotateCmd)
+}
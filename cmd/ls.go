@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	lsRecursive bool
+)
+
+var (
+	lsCmd = &cobra.Command{
+		Use:   "ls [dir]",
+		Short: "List the files stored in the distributed file Storage",
+		Long:  "List your directories and files across the cluster, the ones at the root unless dir is given. The names are relative to dir, the directories end with a slash",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := ""
+			if len(args) == 1 {
+				dir = args[0]
+			}
+			client, err := dialNode()
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			reply, err := client.ListDir(dir, lsRecursive)
+			if err != nil {
+				logs.Logger.Errorf("Error Listing files %+v", err)
+				return err
+			}
+
+			prefix := store.DirPrefix(store.CleanDir(dir))
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tSIZE\tSTORED\tREPLICAS\tSIBLINGS")
+			for _, d := range reply.Dirs {
+				fmt.Fprintf(w, "%s/\t-\t-\t-\t-\n", strings.TrimPrefix(d, prefix))
+			}
+			for _, f := range reply.Files {
+				fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%d\n", strings.TrimPrefix(f.Key, prefix), f.Size, f.ModifiedAt.Local().Format(time.DateTime), len(f.Replicas), len(f.Siblings))
+			}
+			return w.Flush()
+		},
+	}
+)
+
+func init() {
+	lsCmd.Flags().BoolVarP(&lsRecursive, "recursive", "r", false, "List everything below the directory")
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mkdirCmd = &cobra.Command{
+		Use:   "mkdir <dir>",
+		Short: "Make a directory in the distributed file Storage",
+		Long:  "Make a directory along with its parents. The directories holding files exist without it, an empty one only after it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := args[0]
+			client, err := dialNode()
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			if err := client.Mkdir(dir); err != nil {
+				logs.Logger.Errorf("Error Making directory %s: %+v", dir, err)
+				return err
+			}
+			return nil
+		},
+	}
+
+	mvCmd = &cobra.Command{
+		Use:   "mv <src> <dst>",
+		Short: "Move or rename a file or a directory",
+		Long:  "Move the file or the directory src into dst when it is a directory, rename it to dst otherwise. The files keep their versions, they are shared again with the users they were shared with",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			src, dst := args[0], args[1]
+			client, err := dialNode()
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			if err := client.Move(src, dst); err != nil {
+				logs.Logger.Errorf("Error Moving %s to %s: %+v", src, dst, err)
+				return err
+			}
+			logs.Logger.Infof("Moved %s to %s", src, dst)
+			return nil
+		},
+	}
+)
@@ -0,0 +1,69 @@
+//go:build linux || darwin
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/ranjankuldeep/distributed_file_system/fusefs"
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cacheDir string
+)
+var (
+	mountCmd = &cobra.Command{
+		Use:   "mount <dir>",
+		Short: "Mount the files of the node as a directory",
+		Long:  "Mount the files of the running node on dir through FUSE, until interrupted or unmounted",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := dialNode()
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			if len(cacheDir) == 0 {
+				userCache, err := os.UserCacheDir()
+				if err != nil {
+					return err
+				}
+				cacheDir = filepath.Join(userCache, "dfs")
+			}
+			fsys, err := fusefs.New(client, cacheDir)
+			if err != nil {
+				logs.Logger.Errorf("Error creating the cache directory %s: %v", cacheDir, err)
+				return err
+			}
+			server, err := fusefs.Mount(args[0], fsys)
+			if err != nil {
+				logs.Logger.Errorf("Error mounting %s: %v", args[0], err)
+				return err
+			}
+			logs.Logger.Infof("Mounted on %s", args[0])
+
+			sigch := make(chan os.Signal, 1)
+			signal.Notify(sigch, syscall.SIGTERM, os.Interrupt)
+			go func() {
+				<-sigch
+				if err := server.Unmount(); err != nil {
+					logs.Logger.Errorf("Error unmounting %s: %v", args[0], err)
+				}
+			}()
+			server.Wait()
+			logs.Logger.Info("Unmounted")
+			return nil
+		},
+	}
+)
+
+func init() {
+	mountCmd.Flags().StringVar(&cacheDir, "cache", "", "Directory for the local copies of the opened files (default the user cache dir)")
+	rootCmd.AddCommand(mountCmd)
+}
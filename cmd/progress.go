@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+)
+
+// progressWriter reports on out how many bytes went through it, out is
+// stderr so the progress never mixes with a file written to stdout.
+type progressWriter struct {
+	out     io.Writer
+	total   int64
+	written int64
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	if p.total > 0 {
+		fmt.Fprintf(p.out, "\r%d/%d bytes (%d%%)", p.written, p.total, p.written*100/p.total)
+	} else {
+		fmt.Fprintf(p.out, "\r%d bytes", p.written)
+	}
+	return len(b), nil
+}
+
+// done terminates the progress line.
+func (p *progressWriter) done() {
+	fmt.Fprintln(p.out)
+}
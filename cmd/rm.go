@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rmCmd = &cobra.Command{
+		Use:   "rm <key>",
+		Short: "Delete a file from the distributed file Storage",
+		Long:  "Delete a file locally and throughout the network",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key := args[0]
+			client, err := dialNode()
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			if err := client.Delete(key); err != nil {
+				logs.Logger.Errorf("Error Deleting file %s: %+v", key, err)
+				return err
+			}
+			logs.Logger.Info("File Deleted Succesfully")
+			return nil
+		},
+	}
+)
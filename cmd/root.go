@@ -2,18 +2,43 @@ package cmd
 
 import (
 	"os"
-	"os/signal"
-	"syscall"
 
+	"github.com/ranjankuldeep/distributed_file_system/config"
+	"github.com/ranjankuldeep/distributed_file_system/control"
 	"github.com/ranjankuldeep/distributed_file_system/logs"
+	logflag "github.com/ranjankuldeep/distributed_file_system/logs/flag"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var (
+	// ConfigPath is the config file given with --config, config.DefaultFile
+	// is looked for when empty.
+	ConfigPath string
+	// SocketPath is the control socket of the node the CLI commands talk to.
+	SocketPath string
+
+	LogLevel  = logrus.InfoLevel
+	LogFormat string
+	LogFile   string
+
+	// nodeConfig is loaded before any command runs, from the config file,
+	// the DFS_* environment variables and the flags.
+	nodeConfig *config.Config
+
 	rootCmd = &cobra.Command{
 		Use:   "dfs",
 		Short: "A Distributed File Storage System",
 		Long:  `A Distributed File Storage System, It can be deployed over a wide netowrk.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load(ConfigPath)
+			if err != nil {
+				return err
+			}
+			nodeConfig = cfg
+			return configureLogs(cfg)
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 			logs.Logger.Info("Welcome to world of distributed system")
 		},
@@ -27,18 +52,64 @@ func Execute() {
 	}
 }
 
+// dialNode connects to the control API of the running node.
+func dialNode() (*control.Client, error) {
+	client, err := control.Dial(nodeConfig.Control.Socket)
+	if err != nil {
+		logs.Logger.Errorf("Unable to reach the node: %v", err)
+		return nil, err
+	}
+	return client, nil
+}
+
+func configureLogs(cfg *config.Config) error {
+	level, err := logrus.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		return err
+	}
+	return logs.Configure(logs.Options{
+		Format: cfg.Log.Format,
+		Level:  level,
+		File:   cfg.Log.File,
+	})
+}
+
+// bindFlag makes the flag, when given, override the config key.
+func bindFlag(key string, cmd *cobra.Command, name string) {
+	flag := cmd.Flags().Lookup(name)
+	if flag == nil {
+		flag = cmd.PersistentFlags().Lookup(name)
+	}
+	if err := viper.BindPFlag(key, flag); err != nil {
+		panic(err)
+	}
+}
+
 func init() {
+	rootCmd.PersistentFlags().StringVar(&ConfigPath, "config", "", "Config file (default ./"+config.DefaultFile+" when present)")
+	logflag.LogLevelFlagVar(rootCmd.PersistentFlags(), &LogLevel)
+	rootCmd.PersistentFlags().StringVar(&LogFormat, "log-format", logs.FormatText, "Format of the logs, text or json")
+	rootCmd.PersistentFlags().StringVar(&LogFile, "log-file", "", "Append the logs to this file instead of stderr")
+	rootCmd.PersistentFlags().StringVar(&SocketPath, "socket", control.DefaultSocketPath, "Control socket of the running node")
+	bindFlag("log.level", rootCmd, "log-level")
+	bindFlag("log.format", rootCmd, "log-format")
+	bindFlag("log.file", rootCmd, "log-file")
+	bindFlag("control.socket", rootCmd, "socket")
+
 	rootCmd.AddCommand(startCmd)
 	rootCmd.AddCommand(storeCmd)
 	rootCmd.AddCommand(stopCmd)
-
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGTERM)
-	go func() {
-		for sig := range c {
-			if sig == syscall.SIGTERM {
-				close(stopServer)
-			}
-		}
-	}()
+	rootCmd.AddCommand(lsCmd)
+	rootCmd.AddCommand(getCmd)
+	rootCmd.AddCommand(rmCmd)
+	rootCmd.AddCommand(mkdirCmd)
+	rootCmd.AddCommand(mvCmd)
+	rootCmd.AddCommand(syncCmd)
+	rootCmd.AddCommand(versionsCmd)
+	rootCmd.AddCommand(restoreCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(keysCmd)
+	rootCmd.AddCommand(shareCmd)
+	rootCmd.AddCommand(whoamiCmd)
+	rootCmd.AddCommand(usageCmd)
 }
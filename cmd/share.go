@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/fileserver"
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	shareTTL time.Duration
+
+	shareCmd = &cobra.Command{
+		Use:   "share <key> <user-pubkey>",
+		Short: "Share a file with another user",
+		Long:  "Give the user with the public key access to the file until the share expires, they retrieve it with `dfs get --owner <your ID> <key>`",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, grantee := args[0], args[1]
+			client, err := dialNode()
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			if err := client.Share(key, grantee, shareTTL); err != nil {
+				logs.Logger.Errorf("Error Sharing file %s: %+v", key, err)
+				return err
+			}
+			logs.Logger.Info("File Shared Succesfully")
+			return nil
+		},
+	}
+
+	whoamiCmd = &cobra.Command{
+		Use:   "whoami",
+		Short: "Print your ID and the public key others share files with",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := dialNode()
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			me, err := client.Whoami()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("ID:         %s\nPublic key: %s\n", me.ID, me.PublicKey)
+			return nil
+		},
+	}
+)
+
+func init() {
+	shareCmd.Flags().DurationVar(&shareTTL, "expires", fileserver.DefaultShareTTL, "How long the file stays shared")
+}
@@ -1,93 +1,230 @@
 package cmd
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"net/http"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/ranjankuldeep/distributed_file_system/encrypt"
+	"github.com/ranjankuldeep/distributed_file_system/config"
+	"github.com/ranjankuldeep/distributed_file_system/control"
 	"github.com/ranjankuldeep/distributed_file_system/fileserver"
+	"github.com/ranjankuldeep/distributed_file_system/gateway"
 	"github.com/ranjankuldeep/distributed_file_system/logs"
 	"github.com/ranjankuldeep/distributed_file_system/p2p"
+	"github.com/ranjankuldeep/distributed_file_system/s3api"
 	"github.com/ranjankuldeep/distributed_file_system/store"
+	"github.com/ranjankuldeep/distributed_file_system/tracing"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 // start cmd needs two flag
 var randomUserName = uuid.New().String()
 
 var (
-	UserName   string
-	ListenPort string
+	UserName       string
+	ListenPort     string
+	StorageRoot    string
+	TombstoneGrace time.Duration
+	NodeQuota      string
+	OwnerQuota     string
+	MinFree        string
+	Replicas       int
+	ConflictPolicy string
+	HTTPAddr       string
+	HTTPToken      string
+	S3Addr         string
+	S3AccessKey    string
+	S3SecretKey    string
+	MetricsAddr    string
+	TraceExporter  string
+	TraceEndpoint  string
 
 	DefaultUserName = randomUserName
-	server          *fileserver.FileServer
-	serverMu        sync.Mutex
-	serverReady     = make(chan struct{})
-	stopServer      = make(chan struct{})
 )
 var (
 	startCmd = &cobra.Command{
 		Use:   "start",
 		Short: "Start the server",
-		Long:  "Spin Up the Server on the Specified Port",
+		Long:  "Spin Up the Server on the Specified Port, the bootstrap nodes given as arguments replace the ones of the config",
 		Args:  cobra.ArbitraryArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			serverMu.Lock()
-			defer serverMu.Unlock()
+			cfg := *nodeConfig
+			if len(args) > 0 {
+				cfg.Node.Bootstrap = args
+			}
+			if err := cfg.Validate(); err != nil {
+				logs.Logger.Errorf("Invalid configuration:\n%v", err)
+				return err
+			}
+			server, err := makeServer(&cfg)
+			if err != nil {
+				logs.Logger.Errorf("Unable to open the keystore %s: %v", cfg.KeystorePath(), err)
+				return err
+			}
+			if len(cfg.Tracing.Exporter) > 0 {
+				shutdown, err := tracing.Setup(tracing.Options{
+					Exporter: cfg.Tracing.Exporter,
+					Endpoint: cfg.Tracing.Endpoint,
+					NodeID:   server.ID,
+				})
+				if err != nil {
+					logs.Logger.Errorf("Unable to set up tracing %+v", err)
+					return err
+				}
+				defer shutdown(context.Background()) // Flushes the spans left.
+			}
 
-			server = makeServer(UserName, ListenPort, args...)
+			var (
+				stopServer = make(chan struct{})
+				stopOnce   sync.Once
+				stop       = func() { stopOnce.Do(func() { close(stopServer) }) }
+			)
+			controlServer, err := control.Listen(cfg.Control.Socket, control.NewService(server, stop))
+			if err != nil {
+				logs.Logger.Errorf("Unable to start the control API %+v", err)
+				return err
+			}
 
 			go server.StartServer()
-			viper.Set("server", server) // Store the server instance in config file
-			viper.WriteConfigAs("config")
-			close(serverReady) // Signal that the server is ready
+			go controlServer.Serve()
 
-			pid := os.Getpid()
-			pidFile := "/tmp/dfs_server.pid"
-			err := os.WriteFile(pidFile, []byte(fmt.Sprintf("%d", pid)), 0644)
-			if err != nil {
-				logs.Logger.Fatalf("Failed to write PID file: %v", err)
+			var httpGateway *gateway.Gateway
+			if len(cfg.HTTP.Addr) > 0 {
+				httpGateway = gateway.New(gateway.Options{ListenAddr: cfg.HTTP.Addr, Token: cfg.HTTP.Token}, server)
+				go func() {
+					if err := httpGateway.ListenAndServe(); err != nil {
+						logs.Logger.Errorf("HTTP gateway stopped %+v", err)
+					}
+				}()
+			}
+			var metricsServer *http.Server
+			if len(cfg.Metrics.Addr) > 0 {
+				mux := http.NewServeMux()
+				mux.Handle("/metrics", server.MetricsHandler())
+				metricsServer = &http.Server{Addr: cfg.Metrics.Addr, Handler: mux}
+				go func() {
+					logs.Logger.Infof("Metrics listening on %s", cfg.Metrics.Addr)
+					if err := metricsServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+						logs.Logger.Errorf("Metrics endpoint stopped %+v", err)
+					}
+				}()
 			}
 
-			logs.Logger.Infof("Server started successfully, Username:%s, ListenPort:%s", UserName, ListenPort)
+			var s3Server *s3api.Server
+			if len(cfg.S3.Addr) > 0 {
+				s3Server = s3api.New(s3api.Options{
+					ListenAddr:  cfg.S3.Addr,
+					Credentials: []s3api.Credentials{{AccessKey: cfg.S3.AccessKey, SecretKey: cfg.S3.SecretKey}},
+				}, server)
+				go func() {
+					if err := s3Server.ListenAndServe(); err != nil {
+						logs.Logger.Errorf("S3 API stopped %+v", err)
+					}
+				}()
+			}
+
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGTERM, os.Interrupt)
+			go func() {
+				<-c
+				stop()
+			}()
+
+			logs.Logger.Infof("Server started successfully, ID:%s, ListenAddr:%s", server.ID, cfg.Node.ListenAddr)
 
 			<-stopServer
 			logs.Logger.Info("Stopping server...")
+			controlServer.Close()
+			if httpGateway != nil {
+				httpGateway.Close()
+			}
+			if s3Server != nil {
+				s3Server.Close()
+			}
+			if metricsServer != nil {
+				metricsServer.Close()
+			}
 			if err := server.StopServer(); err != nil {
 				logs.Logger.Errorf("Unable to Stop the Server %+v", err)
-				panic(err)
+				return err
 			}
 			return nil
 		},
 	}
 )
 
-func makeServer(userId string, listenAddr string, nodes ...string) *fileserver.FileServer {
+func makeServer(cfg *config.Config) (*fileserver.FileServer, error) {
+	keystore, err := openKeystore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	tcptransportOpts := p2p.TCPTransportOpts{
-		ListenAddr:    listenAddr,
+		ListenAddr:    cfg.Node.ListenAddr,
 		HandshakeFunc: p2p.NOPHandshakeFunc,
 		Decoder:       p2p.DefaultDecoder{},
 	}
 	tcpTransport := p2p.NewTCPTransport(tcptransportOpts)
 
 	fileServerOpts := fileserver.FileServerOpts{
-		ID:                userId,
-		EncKey:            encrypt.NewEncryptionKey(),
-		StorageRoot:       listenAddr + "_network",
+		ID:                cfg.Node.ID,
+		Keystore:          keystore,
+		StorageRoot:       cfg.StorageRoot(),
 		PathTransformFunc: store.CASPathTransformFunc,
 		Transport:         tcpTransport,
-		BootStrapNodes:    nodes,
+		BootStrapNodes:    cfg.Node.Bootstrap,
+
+		TombstoneGracePeriod: cfg.Node.TombstoneGrace,
+		Quotas:               cfg.Quotas(),
+		ReplicationFactor:    cfg.Node.ReplicationFactor,
+		ConflictPolicy:       store.ConflictPolicy(cfg.Node.ConflictPolicy),
 	}
 
 	s := fileserver.NewFileServer(fileServerOpts)
 	tcpTransport.OnPeer = s.OnPeer
-	return s
+	return s, nil
 }
 
 func init() {
 	startCmd.Flags().StringVarP(&UserName, "name", "n", UserName, "Your userName")
 	startCmd.Flags().StringVarP(&ListenPort, "port", "p", ":4000", "Specify Start Server Port (default :4000)")
+	startCmd.Flags().StringVar(&HTTPAddr, "http", "", "Serve the HTTP gateway on this address, eg- :8080 (disabled by default)")
+	startCmd.Flags().StringVar(&HTTPToken, "http-token", "", "Bearer token the HTTP gateway requests must carry, needed to listen beyond the loopback interface")
+	startCmd.Flags().StringVar(&S3Addr, "s3", "", "Serve the S3 compatible API on this address, eg- :9000 (disabled by default)")
+	startCmd.Flags().StringVar(&S3AccessKey, "s3-access-key", "", "Access key the S3 clients sign their requests with")
+	startCmd.Flags().StringVar(&S3SecretKey, "s3-secret-key", "", "Secret key the S3 clients sign their requests with")
+	startCmd.Flags().StringVar(&MetricsAddr, "metrics", "", "Serve the Prometheus metrics on this address under /metrics, eg- :9100 (disabled by default)")
+	startCmd.Flags().StringVar(&TraceExporter, "trace", "", "Export OpenTelemetry spans to stdout or otlp (disabled by default)")
+	startCmd.Flags().StringVar(&TraceEndpoint, "trace-endpoint", "", "Address of the OTLP/HTTP collector, eg- localhost:4318 (default from the OTEL_EXPORTER_OTLP_* variables)")
+	startCmd.Flags().DurationVar(&TombstoneGrace, "tombstone-grace", 7*24*time.Hour, "How long deleted files are remembered to keep peers from bringing them back")
+	startCmd.Flags().StringVar(&StorageRoot, "storage-root", "", "Directory the files are stored in (default the listen address followed by _network)")
+	startCmd.Flags().StringVar(&NodeQuota, "quota", "", "Bytes the node stores at most for all the owners together, eg- 50GiB (no limit by default)")
+	startCmd.Flags().StringVar(&OwnerQuota, "owner-quota", "", "Bytes the node stores at most for every owner, eg- 5GiB (no limit by default)")
+	startCmd.Flags().StringVar(&MinFree, "min-free", "1GiB", "Free space kept on the disk, the writes going below are refused")
+	startCmd.Flags().IntVar(&Replicas, "replicas", 0, "Number of peers a file is replicated to, the ones with the most free space (default all of them)")
+	startCmd.Flags().StringVar(&ConflictPolicy, "conflict-policy", string(store.ConflictLWW), "How the versions of a file written concurrently through different nodes are settled, lww or keep-both")
+
+	bindFlag("node.id", startCmd, "name")
+	bindFlag("node.listen_addr", startCmd, "port")
+	bindFlag("node.storage_root", startCmd, "storage-root")
+	bindFlag("node.tombstone_grace", startCmd, "tombstone-grace")
+	bindFlag("quota.node", startCmd, "quota")
+	bindFlag("quota.owner", startCmd, "owner-quota")
+	bindFlag("quota.min_free", startCmd, "min-free")
+	bindFlag("node.replication_factor", startCmd, "replicas")
+	bindFlag("node.conflict_policy", startCmd, "conflict-policy")
+	bindFlag("http.addr", startCmd, "http")
+	bindFlag("http.token", startCmd, "http-token")
+	bindFlag("s3.addr", startCmd, "s3")
+	bindFlag("s3.access_key", startCmd, "s3-access-key")
+	bindFlag("s3.secret_key", startCmd, "s3-secret-key")
+	bindFlag("metrics.addr", startCmd, "metrics")
+	bindFlag("tracing.exporter", startCmd, "trace")
+	bindFlag("tracing.endpoint", startCmd, "trace-endpoint")
 }
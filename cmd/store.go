@@ -1,45 +1,100 @@
 package cmd
 
 import (
-	"log"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
 
+	"github.com/ranjankuldeep/distributed_file_system/control"
 	"github.com/ranjankuldeep/distributed_file_system/logs"
 	"github.com/ranjankuldeep/distributed_file_system/util"
 	"github.com/spf13/cobra"
 )
 
 var (
-	filePath string
+	filePath       string
+	storeRecursive bool
 )
 var (
 	storeCmd = &cobra.Command{
-		Use:   "store",
+		Use:   "store <path> [remote]",
 		Short: "Store data in distributed file Storage",
-		Long:  "Store ",
-		Args:  cobra.ExactArgs(1),
+		Long:  "Store a file under remote, into remote when it is a directory or ends with a slash. It is stored under its own name at the root when remote is not given. With -r the directory at path is stored along with everything below it, preserving its structure",
+		Args:  cobra.RangeArgs(1, 2),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			<-serverReady
 			filePath = args[0]
 			key, err := util.GetFileName(filePath)
 			if err != nil {
 				logs.Logger.Errorf("Error reading file stat from the specified path %s", filePath)
-				log.Fatal(err)
+				return err
+			}
+			if len(args) == 2 {
+				if remote := args[1]; strings.HasSuffix(remote, "/") {
+					key = path.Join(remote, key)
+				} else {
+					key = remote
+				}
+			}
+			// The node reads the file itself, it may not share our working directory.
+			absPath, err := filepath.Abs(filePath)
+			if err != nil {
+				return err
 			}
 
-			file, err := util.ReadFile(filePath)
+			client, err := dialNode()
 			if err != nil {
-				logs.Logger.Errorf("Error reading file from the specified path %s", filePath)
-				file.Close()
-				log.Fatal(err)
+				return err
+			}
+			defer client.Close()
 
+			if storeRecursive {
+				return storeTree(client, absPath, key)
 			}
-			if err := server.Store(key, file); err != nil {
+			if err := client.Store(key, absPath); err != nil {
 				logs.Logger.Errorf("Error Storing file %+v", err)
 				return err
 			}
 			logs.Logger.Info("File Stored Succesfully")
-			file.Close()
 			return nil
 		},
 	}
 )
+
+// storeTree stores the files below the directory root under the directory
+// dir, the directories are made even when empty.
+func storeTree(client *control.Client, root string, dir string) error {
+	n := 0
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		key := path.Join(dir, filepath.ToSlash(rel))
+		if d.IsDir() {
+			return client.Mkdir(key)
+		}
+		if !d.Type().IsRegular() {
+			logs.Logger.Warnf("Skipping %s, not a regular file", p)
+			return nil
+		}
+		if err := client.Store(key, p); err != nil {
+			logs.Logger.Errorf("Error Storing file %s %+v", p, err)
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logs.Logger.Infof("Stored %d files under %s", n, dir)
+	return nil
+}
+
+func init() {
+	storeCmd.Flags().BoolVarP(&storeRecursive, "recursive", "r", false, "Store the directory at path along with everything below it")
+}
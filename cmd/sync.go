@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/ranjankuldeep/distributed_file_system/control"
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+	"github.com/spf13/cobra"
+)
+
+// How long the local directory has to stay unchanged before a watching sync
+// runs again, so a file being written is uploaded once.
+const syncSettle = 500 * time.Millisecond
+
+var (
+	syncDelete bool
+	syncWatch  bool
+)
+
+var (
+	syncCmd = &cobra.Command{
+		Use:   "sync <localdir> <remote-dir>",
+		Short: "Mirror a local directory into the distributed file Storage",
+		Long:  "Upload the files of localdir which are missing under remote-dir or differ from the stored ones, by size, then by hash when modified since they were stored. With --delete the remote files missing locally are deleted, with --watch the directory is synced again whenever it changes",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root, err := filepath.Abs(args[0])
+			if err != nil {
+				return err
+			}
+			if fi, err := os.Stat(root); err != nil {
+				return err
+			} else if !fi.IsDir() {
+				return &fs.PathError{Op: "sync", Path: root, Err: store.ErrNotDir}
+			}
+			dir := store.CleanDir(args[1])
+
+			client, err := dialNode()
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			if err := syncTree(client, root, dir); err != nil {
+				return err
+			}
+			if !syncWatch {
+				return nil
+			}
+			return watchTree(client, root, dir)
+		},
+	}
+)
+
+// syncTree uploads the files below the directory root which are not stored
+// as they are under dir, and deletes the remote extras with --delete.
+func syncTree(client *control.Client, root string, dir string) error {
+	stored, err := client.List(store.DirPrefix(dir))
+	if err != nil {
+		logs.Logger.Errorf("Error Listing files %+v", err)
+		return err
+	}
+	remote := make(map[string]store.FileMeta, len(stored))
+	for _, meta := range stored {
+		remote[meta.Key] = meta
+	}
+
+	uploaded, unchanged := 0, 0
+	local := map[string]bool{}
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		key := path.Join(dir, filepath.ToSlash(rel))
+		if d.IsDir() {
+			if len(key) == 0 {
+				return nil
+			}
+			return client.Mkdir(key)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		local[key] = true
+		meta, ok := remote[key]
+		if ok {
+			changed, err := fileChanged(p, meta)
+			if err != nil {
+				return err
+			}
+			if !changed {
+				unchanged++
+				return nil
+			}
+		}
+		if err := client.Store(key, p); err != nil {
+			logs.Logger.Errorf("Error Storing file %s %+v", p, err)
+			return err
+		}
+		logs.Logger.Infof("uploaded %s", key)
+		uploaded++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	deleted := 0
+	if syncDelete {
+		for key := range remote {
+			if local[key] {
+				continue
+			}
+			if err := client.Delete(key); err != nil {
+				logs.Logger.Errorf("Error Deleting file %s: %+v", key, err)
+				return err
+			}
+			logs.Logger.Infof("deleted %s", key)
+			deleted++
+		}
+	}
+	logs.Logger.Infof("Synced %s: %d uploaded, %d unchanged, %d deleted", root, uploaded, unchanged, deleted)
+	return nil
+}
+
+// fileChanged compares the local file with the stored one. The sizes tell
+// most changes apart, the plain text is only hashed when the file was
+// modified since it was stored.
+func fileChanged(p string, meta store.FileMeta) (bool, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return false, err
+	}
+	if fi.Size() != meta.Size {
+		return true, nil
+	}
+	if !fi.ModTime().After(meta.ModifiedAt) {
+		return false, nil
+	}
+	if len(meta.PlainDigest) == 0 {
+		return true, nil // Stored before the digests were recorded.
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)) != meta.PlainDigest, nil
+}
+
+// watchTree syncs the directory root again once it settles after every
+// change, until interrupted.
+func watchTree(client *control.Client, root string, dir string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	// fsnotify does not watch the directories below the ones it was given.
+	watchDirs := func() error {
+		return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return err
+			}
+			return watcher.Add(p)
+		})
+	}
+	if err := watchDirs(); err != nil {
+		return err
+	}
+	logs.Logger.Infof("Watching %s", root)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	settle := time.NewTimer(syncSettle)
+	settle.Stop()
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			logs.Logger.Debugf("%s %s", event.Op, event.Name)
+			settle.Reset(syncSettle)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logs.Logger.Errorf("Error watching %s: %v", root, err)
+		case <-settle.C:
+			if err := watchDirs(); err != nil {
+				logs.Logger.Errorf("Error watching %s: %v", root, err)
+			}
+			if err := syncTree(client, root, dir); err != nil {
+				logs.Logger.Errorf("Error syncing %s: %v", root, err)
+			}
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+func init() {
+	syncCmd.Flags().BoolVar(&syncDelete, "delete", false, "Delete the remote files missing from the local directory")
+	syncCmd.Flags().BoolVar(&syncWatch, "watch", false, "Keep syncing the directory whenever it changes, until interrupted")
+}
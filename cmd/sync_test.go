@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/control"
+	"github.com/ranjankuldeep/distributed_file_system/fileserver"
+	"github.com/ranjankuldeep/distributed_file_system/p2p"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+func TestSyncTree(t *testing.T) {
+	client := startTestNode(t)
+	root := t.TempDir()
+	write := func(name, data string) {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stored := func() string {
+		metas, err := client.List(store.DirPrefix("backup"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := []string{}
+		for _, meta := range metas {
+			keys = append(keys, meta.Key)
+		}
+		sort.Strings(keys)
+		return strings.Join(keys, " ")
+	}
+
+	write("a.txt", "a")
+	write("docs/b.txt", "b")
+	write("docs/c.txt", "c")
+	if err := syncTree(client, root, "backup"); err != nil {
+		t.Fatal(err)
+	}
+	if have := stored(); have != "backup/a.txt backup/docs/b.txt backup/docs/c.txt" {
+		t.Errorf("want the tree uploaded have %s", have)
+	}
+
+	// Same size, another content, modified after it was stored.
+	write("a.txt", "A")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(root, "a.txt"), future, future)
+	os.Remove(filepath.Join(root, "docs", "c.txt"))
+	syncDelete = true
+	defer func() { syncDelete = false }()
+	if err := syncTree(client, root, "backup"); err != nil {
+		t.Fatal(err)
+	}
+	if have := stored(); have != "backup/a.txt backup/docs/b.txt" {
+		t.Errorf("want the file missing locally deleted have %s", have)
+	}
+	_, r, err := client.Get("backup/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	buf := make([]byte, 2)
+	if n, _ := r.Read(buf); string(buf[:n]) != "A" {
+		t.Errorf("want the changed file uploaded again have %q", buf[:n])
+	}
+}
+
+func startTestNode(t *testing.T) *control.Client {
+	dir := t.TempDir()
+	fs := fileserver.NewFileServer(fileserver.FileServerOpts{
+		EncKey:            make([]byte, 32),
+		StorageRoot:       filepath.Join(dir, "store"),
+		PathTransformFunc: store.CASPathTransformFunc,
+		Transport:         p2p.NewTCPTransport(p2p.TCPTransportOpts{ListenAddr: ":0"}),
+	})
+	t.Cleanup(func() { fs.FsStore.Close() })
+
+	socketPath := filepath.Join(dir, "dfs.sock")
+	server, err := control.Listen(socketPath, control.NewService(fs, func() {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+
+	client, err := control.Dial(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	usageCmd = &cobra.Command{
+		Use:   "usage",
+		Short: "Show the space taken on the node by every owner",
+		Long:  "Show the space taken on the node by the files of every owner, the replicas held for other nodes included, against the quotas. Followed by the free space of the disks of the node and of its peers",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := dialNode()
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			usage, err := client.Usage()
+			if err != nil {
+				logs.Logger.Errorf("Error reading the usage %+v", err)
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "OWNER\tUSED\tQUOTA")
+			for _, o := range usage.Owners {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", o.ID, formatSize(o.Used), formatQuota(o.Quota))
+			}
+			fmt.Fprintf(w, "(node)\t%s\t%s\n", formatSize(usage.Used), formatQuota(usage.Quota))
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			fmt.Println()
+			w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "DISK\tID\tFREE\tCAPACITY")
+			fmt.Fprintf(w, "(node)\t-\t%s\t%s\n", formatDisk(usage.Free, usage.Capacity), formatDisk(usage.Capacity, usage.Capacity))
+			for _, p := range usage.Peers {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Addr, p.ID, formatDisk(p.Free, p.Capacity), formatDisk(p.Capacity, p.Capacity))
+			}
+			return w.Flush()
+		},
+	}
+)
+
+// formatSize prints a number of bytes with a binary unit, eg- 1.5GiB.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatQuota(n int64) string {
+	if n <= 0 {
+		return "-"
+	}
+	return formatSize(n)
+}
+
+// formatDisk prints a size of a disk, which is unknown when its capacity is zero.
+func formatDisk(n int64, capacity int64) string {
+	if capacity == 0 {
+		return "?"
+	}
+	return formatSize(n)
+}
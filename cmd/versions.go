@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	versionsCmd = &cobra.Command{
+		Use:   "versions <key>",
+		Short: "List the versions of a file",
+		Long:  "List every version a file was stored with, oldest first. Any of them can be retrieved with dfs get --version or made the latest again with dfs restore. The siblings were written concurrently with the latest version through other nodes",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key := args[0]
+			client, err := dialNode()
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			reply, err := client.Versions(key)
+			if err != nil {
+				logs.Logger.Errorf("Error Listing the versions of %s: %+v", key, err)
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tSIZE\tSTORED")
+			for _, v := range reply.Versions {
+				head := ""
+				if v.VersionID == reply.Latest {
+					head = "(latest)"
+				} else if slices.Contains(reply.Siblings, v.VersionID) {
+					head = "(sibling)"
+				}
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", v.VersionID, v.Size, v.ModifiedAt.Local().Format(time.DateTime), head)
+			}
+			return w.Flush()
+		},
+	}
+
+	restoreCmd = &cobra.Command{
+		Use:   "restore <key> <version>",
+		Short: "Make an older version of a file the latest one again",
+		Long:  "Store an older version of a file again as its latest version. The versions stored since are kept",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, version := args[0], args[1]
+			client, err := dialNode()
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			if err := client.Restore(key, version); err != nil {
+				logs.Logger.Errorf("Error Restoring version %s of %s: %+v", version, key, err)
+				return err
+			}
+			logs.Logger.Info("File Restored Succesfully")
+			return nil
+		},
+	}
+)
@@ -0,0 +1,326 @@
+package config
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/control"
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+	"github.com/ranjankuldeep/distributed_file_system/tracing"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFile is the config file looked for in the working directory when
+// no path is given.
+const DefaultFile = "config.yaml"
+
+// Environment variables override the file, eg- DFS_NODE_LISTEN_ADDR for
+// node.listen_addr.
+const envPrefix = "DFS"
+
+// Config holds the settings of a node and of the CLI talking to it.
+type Config struct {
+	Node     NodeConfig     `mapstructure:"node" yaml:"node"`
+	Keystore KeystoreConfig `mapstructure:"keystore" yaml:"keystore"`
+	Quota    QuotaConfig    `mapstructure:"quota" yaml:"quota"`
+	Control  ControlConfig  `mapstructure:"control" yaml:"control"`
+	HTTP     HTTPConfig     `mapstructure:"http" yaml:"http"`
+	S3       S3Config       `mapstructure:"s3" yaml:"s3"`
+	Metrics  MetricsConfig  `mapstructure:"metrics" yaml:"metrics"`
+	Tracing  TracingConfig  `mapstructure:"tracing" yaml:"tracing"`
+	Log      LogConfig      `mapstructure:"log" yaml:"log"`
+}
+
+type NodeConfig struct {
+	// Name of the owner of the files stored through this node, its ID ends
+	// with the fingerprint of the signing key. "user" when empty.
+	ID         string `mapstructure:"id" yaml:"id"`
+	ListenAddr string `mapstructure:"listen_addr" yaml:"listen_addr"`
+	// Defaults to the listen address followed by "_network".
+	StorageRoot string   `mapstructure:"storage_root" yaml:"storage_root"`
+	Bootstrap   []string `mapstructure:"bootstrap" yaml:"bootstrap"`
+	// Hex encoded 32 byte key imported into the keystore when it is
+	// created, the keystore holds the keys of the node from then on.
+	EncKey         string        `mapstructure:"enc_key" yaml:"enc_key"`
+	TombstoneGrace time.Duration `mapstructure:"tombstone_grace" yaml:"tombstone_grace"`
+	// Number of peers a file is replicated to, the ones with the most free
+	// space. All of them when zero.
+	ReplicationFactor int `mapstructure:"replication_factor" yaml:"replication_factor"`
+	// Settles the versions of a file written concurrently through different
+	// nodes: lww keeps the latest one, keep-both keeps them all as siblings.
+	ConflictPolicy string `mapstructure:"conflict_policy" yaml:"conflict_policy"`
+}
+
+type KeystoreConfig struct {
+	// Defaults to keystore.json in the storage root.
+	Path string `mapstructure:"path" yaml:"path"`
+	// Unlocks the keystore, asked on the terminal when empty. Better given
+	// through DFS_KEYSTORE_PASSPHRASE than written in the file.
+	Passphrase string `mapstructure:"passphrase" yaml:"passphrase"`
+}
+
+// QuotaConfig limits the bytes a node stores, sizes are written like 500MB or
+// 10GiB and empty means no limit.
+type QuotaConfig struct {
+	// All the owners together.
+	Node string `mapstructure:"node" yaml:"node"`
+	// Every owner not listed in Owners.
+	Owner  string       `mapstructure:"owner" yaml:"owner"`
+	Owners []OwnerQuota `mapstructure:"owners" yaml:"owners"`
+	// Free space kept on the disk, the writes going below are refused.
+	MinFree string `mapstructure:"min_free" yaml:"min_free"`
+}
+
+type OwnerQuota struct {
+	ID   string `mapstructure:"id" yaml:"id"`
+	Size string `mapstructure:"size" yaml:"size"`
+}
+
+type ControlConfig struct {
+	Socket string `mapstructure:"socket" yaml:"socket"`
+}
+
+type HTTPConfig struct {
+	Addr  string `mapstructure:"addr" yaml:"addr"`
+	Token string `mapstructure:"token" yaml:"token"`
+}
+
+type S3Config struct {
+	Addr      string `mapstructure:"addr" yaml:"addr"`
+	AccessKey string `mapstructure:"access_key" yaml:"access_key"`
+	SecretKey string `mapstructure:"secret_key" yaml:"secret_key"`
+}
+
+type MetricsConfig struct {
+	Addr string `mapstructure:"addr" yaml:"addr"`
+}
+
+type TracingConfig struct {
+	Exporter string `mapstructure:"exporter" yaml:"exporter"`
+	Endpoint string `mapstructure:"endpoint" yaml:"endpoint"`
+}
+
+type LogConfig struct {
+	Level  string `mapstructure:"level" yaml:"level"`
+	Format string `mapstructure:"format" yaml:"format"`
+	File   string `mapstructure:"file" yaml:"file"`
+}
+
+// Default returns the settings used for everything the file, the
+// environment and the flags leave out.
+func Default() Config {
+	return Config{
+		Node: NodeConfig{
+			ListenAddr:     ":4000",
+			Bootstrap:      []string{},
+			TombstoneGrace: 7 * 24 * time.Hour,
+			ConflictPolicy: string(store.ConflictLWW),
+		},
+		Quota:   QuotaConfig{MinFree: "1GiB"},
+		Control: ControlConfig{Socket: control.DefaultSocketPath},
+		Log:     LogConfig{Level: logrus.InfoLevel.String(), Format: logs.FormatText},
+	}
+}
+
+// Load reads the config file into the global viper instance and returns the
+// settings, with the environment and the flags bound to viper taking
+// precedence. An empty path looks for DefaultFile and is fine to be missing,
+// an explicit path has to exist.
+func Load(path string) (*Config, error) {
+	setDefaults(viper.GetViper(), Default())
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+
+	if len(path) > 0 {
+		viper.SetConfigFile(path)
+	} else {
+		viper.SetConfigFile(DefaultFile)
+	}
+	if err := viper.ReadInConfig(); err != nil {
+		if len(path) > 0 || !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("reading config %s: %w", viper.ConfigFileUsed(), err)
+		}
+	}
+
+	cfg := &Config{}
+	if err := viper.Unmarshal(cfg); err != nil {
+		return nil, fmt.Errorf("decoding config: %w", err)
+	}
+	return cfg, nil
+}
+
+// setDefaults registers every key of the config, viper only looks up the
+// environment for the keys it knows about.
+func setDefaults(v *viper.Viper, defaults Config) {
+	m := map[string]any{}
+	b, _ := yaml.Marshal(defaults)
+	yaml.Unmarshal(b, &m)
+	for section, values := range m {
+		for key, value := range values.(map[string]any) {
+			v.SetDefault(section+"."+key, value)
+		}
+	}
+}
+
+// Validate checks the settings needed to start a node.
+func (c *Config) Validate() error {
+	errs := []error{}
+	if _, _, err := net.SplitHostPort(c.Node.ListenAddr); err != nil {
+		errs = append(errs, fmt.Errorf("node.listen_addr: %w", err))
+	}
+	for _, addr := range c.Node.Bootstrap {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("node.bootstrap: %w", err))
+		}
+	}
+	if len(c.Node.EncKey) > 0 {
+		if key, err := hex.DecodeString(c.Node.EncKey); err != nil || len(key) != 32 {
+			errs = append(errs, fmt.Errorf("node.enc_key: want 64 hex characters"))
+		}
+	}
+	if c.Node.TombstoneGrace <= 0 {
+		errs = append(errs, fmt.Errorf("node.tombstone_grace: must be positive"))
+	}
+	if c.Node.ReplicationFactor < 0 {
+		errs = append(errs, fmt.Errorf("node.replication_factor: must not be negative"))
+	}
+	switch store.ConflictPolicy(c.Node.ConflictPolicy) {
+	case store.ConflictLWW, store.ConflictKeepBoth:
+	default:
+		errs = append(errs, fmt.Errorf("node.conflict_policy: want %s or %s, have %q", store.ConflictLWW, store.ConflictKeepBoth, c.Node.ConflictPolicy))
+	}
+	for name, size := range map[string]string{"quota.node": c.Quota.Node, "quota.owner": c.Quota.Owner, "quota.min_free": c.Quota.MinFree} {
+		if _, err := parseSize(size); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	for _, q := range c.Quota.Owners {
+		if len(q.ID) == 0 {
+			errs = append(errs, fmt.Errorf("quota.owners: missing id"))
+		}
+		if _, err := parseSize(q.Size); err != nil {
+			errs = append(errs, fmt.Errorf("quota.owners: %s: %w", q.ID, err))
+		}
+	}
+	if len(c.Control.Socket) == 0 {
+		errs = append(errs, fmt.Errorf("control.socket: must be set"))
+	}
+	for name, addr := range map[string]string{"http.addr": c.HTTP.Addr, "s3.addr": c.S3.Addr, "metrics.addr": c.Metrics.Addr} {
+		if len(addr) == 0 {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	if host, _, err := net.SplitHostPort(c.HTTP.Addr); err == nil && len(host) > 0 && !isLoopback(host) && len(c.HTTP.Token) == 0 {
+		errs = append(errs, fmt.Errorf("http: the HTTP gateway needs a token to listen on %s", host))
+	}
+	if len(c.S3.Addr) > 0 && (len(c.S3.AccessKey) == 0 || len(c.S3.SecretKey) == 0) {
+		errs = append(errs, fmt.Errorf("s3: the S3 API needs an access_key and a secret_key"))
+	}
+	switch c.Tracing.Exporter {
+	case "", tracing.ExporterStdout, tracing.ExporterOTLP:
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter: want %s or %s, have %q", tracing.ExporterStdout, tracing.ExporterOTLP, c.Tracing.Exporter))
+	}
+	if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %w", err))
+	}
+	switch c.Log.Format {
+	case logs.FormatText, logs.FormatJSON:
+	default:
+		errs = append(errs, fmt.Errorf("log.format: want %s or %s, have %q", logs.FormatText, logs.FormatJSON, c.Log.Format))
+	}
+	return errors.Join(errs...)
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// EncryptionKey returns the decoded node.enc_key, nil when unset.
+func (c *Config) EncryptionKey() []byte {
+	key, _ := hex.DecodeString(c.Node.EncKey)
+	if len(key) == 0 {
+		return nil
+	}
+	return key
+}
+
+// Quotas returns the decoded quota section, the sizes which do not parse
+// are left out: Validate reports them.
+func (c *Config) Quotas() store.Quotas {
+	q := store.Quotas{Owners: map[string]int64{}}
+	q.Node, _ = parseSize(c.Quota.Node)
+	q.Owner, _ = parseSize(c.Quota.Owner)
+	q.MinFree, _ = parseSize(c.Quota.MinFree)
+	for _, o := range c.Quota.Owners {
+		if size, err := parseSize(o.Size); err == nil {
+			q.Owners[o.ID] = size
+		}
+	}
+	return q
+}
+
+// StorageRoot returns node.storage_root or its default.
+func (c *Config) StorageRoot() string {
+	if len(c.Node.StorageRoot) > 0 {
+		return c.Node.StorageRoot
+	}
+	return c.Node.ListenAddr + "_network"
+}
+
+// KeystorePath returns keystore.path or its default.
+func (c *Config) KeystorePath() string {
+	if len(c.Keystore.Path) > 0 {
+		return c.Keystore.Path
+	}
+	return filepath.Join(c.StorageRoot(), "keystore.json")
+}
+
+// Redacted returns a copy without the secrets, for display.
+func (c Config) Redacted() Config {
+	if len(c.Node.EncKey) > 0 {
+		c.Node.EncKey = "<redacted>"
+	}
+	if len(c.HTTP.Token) > 0 {
+		c.HTTP.Token = "<redacted>"
+	}
+	if len(c.S3.SecretKey) > 0 {
+		c.S3.SecretKey = "<redacted>"
+	}
+	if len(c.Keystore.Passphrase) > 0 {
+		c.Keystore.Passphrase = "<redacted>"
+	}
+	return c
+}
+
+// Write saves the config as YAML, readable by the owner only as it holds
+// the keys.
+func (c *Config) Write(path string) error {
+	b, err := c.Marshal()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0600)
+}
+
+// Marshal returns the config as YAML.
+func (c *Config) Marshal() ([]byte, error) {
+	return yaml.Marshal(c)
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dfs.yaml")
+	cfg := Default()
+	cfg.Node.ListenAddr = ":3000"
+	cfg.Node.TombstoneGrace = time.Hour
+	if err := cfg.Write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("DFS_NODE_BOOTSTRAP", ":4000,:5000")
+	t.Setenv("DFS_QUOTA_OWNER", "1GiB")
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Node.ListenAddr != ":3000" || loaded.Node.TombstoneGrace != time.Hour {
+		t.Errorf("settings of the file not applied: %+v", loaded.Node)
+	}
+	if len(loaded.Node.Bootstrap) != 2 || loaded.Node.Bootstrap[1] != ":5000" {
+		t.Errorf("want the bootstrap nodes of the environment have %v", loaded.Node.Bootstrap)
+	}
+	if q := loaded.Quotas(); q.Owner != 1<<30 || q.Node != 0 {
+		t.Errorf("want the owner quota of the environment have %+v", q)
+	}
+	if loaded.StorageRoot() != ":3000_network" {
+		t.Errorf("unexpected default storage root %s", loaded.StorageRoot())
+	}
+	if loaded.KeystorePath() != filepath.Join(":3000_network", "keystore.json") {
+		t.Errorf("unexpected default keystore path %s", loaded.KeystorePath())
+	}
+	if err := loaded.Validate(); err != nil {
+		t.Errorf("unexpected validation error %v", err)
+	}
+
+	loaded.Node.EncKey = "abcd"
+	loaded.S3.Addr = ":9000"
+	loaded.HTTP.Addr = "0.0.0.0:8080"
+	err = loaded.Validate()
+	if err == nil || !strings.Contains(err.Error(), "node.enc_key") || !strings.Contains(err.Error(), "s3") || !strings.Contains(err.Error(), "http") {
+		t.Errorf("want errors for node.enc_key, s3 and http have %v", err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{"": 0, "512": 512, "10B": 10, "2KiB": 2048, "500MB": 500e6, "1.5 GiB": 3 << 29} {
+		if have, err := parseSize(s); err != nil || have != want {
+			t.Errorf("%q: want %d have %d %v", s, want, have, err)
+		}
+	}
+	for _, s := range []string{"ten", "-1GB", "5XB"} {
+		if _, err := parseSize(s); err == nil {
+			t.Errorf("%q: want an error", s)
+		}
+	}
+}
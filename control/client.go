@@ -0,0 +1,192 @@
+package control
+
+import (
+	"fmt"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+// Client talks to the node listening on the control socket.
+type Client struct {
+	rpc *rpc.Client
+}
+
+func Dial(socketPath string) (*Client, error) {
+	c, err := jsonrpc.Dial("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("no running node on %s, start one with `dfs start`: %w", socketPath, err)
+	}
+	return &Client{rpc: c}, nil
+}
+
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
+
+func (c *Client) call(method string, args any, reply any) error {
+	return c.rpc.Call(serviceName+"."+method, args, reply)
+}
+
+// Store asks the node to store the file found at path, which must be absolute.
+func (c *Client) Store(key string, path string) error {
+	return c.call("Store", StoreArgs{Key: key, Path: path}, &Empty{})
+}
+
+// Get returns the size of the file and a reader streaming it from the node.
+// The reader must be closed.
+func (c *Client) Get(key string) (int64, io.ReadCloser, error) {
+	return c.GetShared("", key)
+}
+
+// GetShared is Get for a file another user shared, owner is the ID of that
+// user.
+func (c *Client) GetShared(owner string, key string) (int64, io.ReadCloser, error) {
+	reply, r, err := c.Open(OpenArgs{Key: key, Owner: owner})
+	return reply.Size, r, err
+}
+
+// GetVersion is Get for a version of the file, the latest one when version
+// is empty.
+func (c *Client) GetVersion(key string, version string) (int64, io.ReadCloser, error) {
+	reply, r, err := c.Open(OpenArgs{Key: key, Version: version})
+	return reply.Size, r, err
+}
+
+// GetRange is Get for length bytes of the file from offset on, up to its end
+// when length is zero. Only these bytes are fetched from the peers.
+func (c *Client) GetRange(key string, offset int64, length int64) (int64, io.ReadCloser, error) {
+	reply, r, err := c.Open(OpenArgs{Key: key, Offset: offset, Length: length})
+	return reply.Size, r, err
+}
+
+// Open is Get along with what the node knows of the file, the reader must be
+// closed.
+func (c *Client) Open(args OpenArgs) (OpenReply, io.ReadCloser, error) {
+	reply := OpenReply{}
+	if err := c.call("Open", args, &reply); err != nil {
+		return reply, nil, err
+	}
+	return reply, &fileReader{c: c, handle: reply.Handle}, nil
+}
+
+// Versions returns the versions of the file, oldest first, along with the
+// latest one and its siblings.
+func (c *Client) Versions(key string) (*VersionsReply, error) {
+	reply := &VersionsReply{}
+	if err := c.call("Versions", VersionsArgs{Key: key}, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// Restore makes the version of the file the latest one again.
+func (c *Client) Restore(key string, version string) error {
+	return c.call("Restore", RestoreArgs{Key: key, Version: version}, &Empty{})
+}
+
+func (c *Client) List(prefix string) ([]store.FileMeta, error) {
+	reply := ListReply{}
+	if err := c.call("List", ListArgs{Prefix: prefix}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Files, nil
+}
+
+func (c *Client) Delete(key string) error {
+	return c.call("Delete", DeleteArgs{Key: key}, &Empty{})
+}
+
+// Mkdir makes the directory along with its parents.
+func (c *Client) Mkdir(dir string) error {
+	return c.call("Mkdir", MkdirArgs{Dir: dir}, &Empty{})
+}
+
+// Move renames the file or the directory src to dst, into dst when it is a
+// directory.
+func (c *Client) Move(src string, dst string) error {
+	return c.call("Move", MoveArgs{Src: src, Dst: dst}, &Empty{})
+}
+
+// ListDir returns the directories and the files in dir, the root when empty,
+// with everything below it when recursive.
+func (c *Client) ListDir(dir string, recursive bool) (*ListDirReply, error) {
+	reply := &ListDirReply{}
+	if err := c.call("ListDir", ListDirArgs{Dir: dir, Recursive: recursive}, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// Share gives the user with the public key grantee access to the file for
+// ttl, the default of the node when zero.
+func (c *Client) Share(key string, grantee string, ttl time.Duration) error {
+	return c.call("Share", ShareArgs{Key: key, Grantee: grantee, TTL: ttl}, &Empty{})
+}
+
+func (c *Client) Whoami() (WhoamiReply, error) {
+	reply := WhoamiReply{}
+	err := c.call("Whoami", Empty{}, &reply)
+	return reply, err
+}
+
+// Usage returns the space taken on the node by every owner, replicas held
+// for other nodes included.
+func (c *Client) Usage() (*UsageReply, error) {
+	reply := &UsageReply{}
+	if err := c.call("Usage", Empty{}, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (c *Client) Keys() ([]KeyInfo, error) {
+	reply := KeysReply{}
+	if err := c.call("Keys", Empty{}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Keys, nil
+}
+
+// RotateKey switches the node to a new key, it returns once the data keys
+// of the files have been rewrapped.
+func (c *Client) RotateKey() (RotateKeyReply, error) {
+	reply := RotateKeyReply{}
+	err := c.call("RotateKey", Empty{}, &reply)
+	return reply, err
+}
+
+func (c *Client) Stop() error {
+	return c.call("Stop", Empty{}, &Empty{})
+}
+
+// fileReader reads a file opened on the node chunk by chunk.
+type fileReader struct {
+	c      *Client
+	handle string
+	buf    []byte
+	eof    bool
+}
+
+func (r *fileReader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		reply := ReadReply{}
+		if err := r.c.call("Read", ReadArgs{Handle: r.handle}, &reply); err != nil {
+			return 0, err
+		}
+		r.buf, r.eof = reply.Data, reply.EOF
+	}
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *fileReader) Close() error {
+	return r.c.call("Close", CloseArgs{Handle: r.handle}, &Empty{})
+}
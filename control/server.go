@@ -0,0 +1,133 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"sync"
+
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+)
+
+// DefaultSocketPath is where a node listens for the CLI by default.
+const DefaultSocketPath = "/tmp/dfs.sock"
+
+// serviceName is the prefix of the JSON-RPC methods, eg- "DFS.Store".
+const serviceName = "DFS"
+
+// Server exposes a Service on a unix socket using JSON-RPC.
+type Server struct {
+	socketPath string
+	listener   net.Listener
+	rpc        *rpc.Server
+	svc        *Service
+}
+
+// Listen registers the service and starts listening on the socket. A socket
+// left over by a node which did not shut down cleanly is replaced, one which
+// still answers means another node is running.
+func Listen(socketPath string, svc *Service) (*Server, error) {
+	if _, err := os.Stat(socketPath); err == nil {
+		if conn, err := net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("a node is already listening on %s", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, err
+		}
+	}
+
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName(serviceName, svc); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	// Only the user running the node may control it.
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &Server{
+		socketPath: socketPath,
+		listener:   listener,
+		rpc:        rpcServer,
+		svc:        svc,
+	}, nil
+}
+
+// Serve accepts the CLI connections until the server is closed. Blocking.
+func (s *Server) Serve() {
+	logs.Logger.Infof("Control API listening on %s", s.socketPath)
+	for {
+		conn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			logs.Logger.Errorf("Control API accept error: %v", err)
+			continue
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn serves the calls of a client until it disconnects, then closes
+// the handles it left open: a CLI killed in the middle of a read does not
+// keep the file open for the life of the node.
+func (s *Server) serveConn(conn net.Conn) {
+	codec := &handleCodec{ServerCodec: jsonrpc.NewServerCodec(conn), handles: make(map[string]bool)}
+	s.rpc.ServeCodec(codec) // Returns once the replies in flight are sent.
+	for _, handle := range codec.open() {
+		s.svc.Close(CloseArgs{Handle: handle}, &Empty{})
+	}
+}
+
+// handleCodec keeps track of the handles opened on a connection and not
+// closed yet.
+type handleCodec struct {
+	rpc.ServerCodec
+
+	lock    sync.Mutex
+	handles map[string]bool
+}
+
+func (c *handleCodec) ReadRequestBody(body any) error {
+	err := c.ServerCodec.ReadRequestBody(body)
+	if args, ok := body.(*CloseArgs); ok && err == nil {
+		c.lock.Lock()
+		delete(c.handles, args.Handle)
+		c.lock.Unlock()
+	}
+	return err
+}
+
+func (c *handleCodec) WriteResponse(r *rpc.Response, body any) error {
+	if reply, ok := body.(*OpenReply); ok && len(r.Error) == 0 && len(reply.Handle) > 0 {
+		c.lock.Lock()
+		c.handles[reply.Handle] = true
+		c.lock.Unlock()
+	}
+	return c.ServerCodec.WriteResponse(r, body)
+}
+
+func (c *handleCodec) open() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	handles := make([]string, 0, len(c.handles))
+	for handle := range c.handles {
+		handles = append(handles, handle)
+	}
+	return handles
+}
+
+// Close stops accepting connections, the socket file is removed.
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
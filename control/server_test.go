@@ -0,0 +1,102 @@
+package control
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/fileserver"
+	"github.com/ranjankuldeep/distributed_file_system/p2p"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+func TestReadHandle(t *testing.T) {
+	svc, client := newTestServer(t)
+	data := bytes.Repeat([]byte("chunk by chunk "), readChunkSize/4)
+	if err := svc.fs.Store("a.txt", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	size, r, err := client.Get("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) || size != int64(len(data)) {
+		t.Errorf("want %d bytes have %d and %v", len(data), len(got), err)
+	}
+	if open := svc.open(); open != 1 {
+		t.Errorf("want the handle open until closed have %d", open)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if open := svc.open(); open != 0 {
+		t.Errorf("want no handle left have %d", open)
+	}
+	if _, err := r.Read(make([]byte, 1)); err == nil {
+		t.Error("want an error reading a closed handle")
+	}
+}
+
+func TestAbandonedHandle(t *testing.T) {
+	svc, client := newTestServer(t)
+	if err := svc.fs.Store("a.txt", bytes.NewReader(bytes.Repeat([]byte("a"), 3*readChunkSize))); err != nil {
+		t.Fatal(err)
+	}
+	_, r, err := client.GetRange("a.txt", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if open := svc.open(); open != 1 {
+		t.Fatalf("want 1 handle open have %d", open)
+	}
+
+	// The client goes away in the middle of the read.
+	client.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for svc.open() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if open := svc.open(); open != 0 {
+		t.Errorf("want the handle closed along with the connection have %d open", open)
+	}
+}
+
+// open returns the number of handles open.
+func (s *Service) open() int {
+	s.readersLock.Lock()
+	defer s.readersLock.Unlock()
+	return len(s.readers)
+}
+
+func newTestServer(t *testing.T) (*Service, *Client) {
+	root := t.TempDir()
+	fs := fileserver.NewFileServer(fileserver.FileServerOpts{
+		EncKey:            make([]byte, 32),
+		StorageRoot:       root,
+		PathTransformFunc: store.CASPathTransformFunc,
+		Transport:         p2p.NewTCPTransport(p2p.TCPTransportOpts{ListenAddr: ":0"}),
+	})
+	t.Cleanup(func() { fs.FsStore.Close() })
+
+	svc := NewService(fs, func() {})
+	socketPath := filepath.Join(root, "dfs.sock")
+	server, err := Listen(socketPath, svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+
+	client, err := Dial(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return svc, client
+}
@@ -0,0 +1,391 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ranjankuldeep/distributed_file_system/fileserver"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+// Maximum number of bytes returned by a single Read call.
+const readChunkSize = 256 * 1024
+
+type Empty struct{}
+
+type StoreArgs struct {
+	Key string
+	// Path of the file on the host of the node, the node reads it itself.
+	Path string
+}
+
+type OpenArgs struct {
+	Key string
+	// Owner of the file when it was shared by another user.
+	Owner string
+	// Version of the file, the latest one when empty.
+	Version string
+	// Where Read starts in the file, after the bytes the client received
+	// already when resuming.
+	Offset int64
+	// Bytes to read from Offset on, up to the end when zero. Unless the node
+	// holds the file, only these bytes are fetched from the peers.
+	Length int64
+}
+
+type OpenReply struct {
+	Handle string
+	// Size of the whole file, whatever the offset.
+	Size int64
+	// Versions concurrent with the latest one, read instead of them.
+	Siblings []string
+}
+
+type ReadArgs struct {
+	Handle string
+}
+
+type ReadReply struct {
+	Data []byte
+	EOF  bool
+}
+
+type CloseArgs struct {
+	Handle string
+}
+
+type ListArgs struct {
+	Prefix string
+}
+
+type ListReply struct {
+	Files []store.FileMeta
+}
+
+type DeleteArgs struct {
+	Key string
+}
+
+type MkdirArgs struct {
+	Dir string
+}
+
+type MoveArgs struct {
+	Src string
+	// Directory to move Src into, or its new path.
+	Dst string
+}
+
+type ListDirArgs struct {
+	Dir       string
+	Recursive bool
+}
+
+type ListDirReply struct {
+	Dirs  []string
+	Files []store.FileMeta
+}
+
+type VersionsArgs struct {
+	Key string
+}
+
+type VersionsReply struct {
+	Versions []store.FileMeta
+	// Version IDs of the latest version and of its siblings.
+	Latest   string
+	Siblings []string
+}
+
+type RestoreArgs struct {
+	Key     string
+	Version string
+}
+
+type ShareArgs struct {
+	Key string
+	// Hex encoded public key of the user to share the file with.
+	Grantee string
+	// How long the file stays shared, fileserver.DefaultShareTTL when zero.
+	TTL time.Duration
+}
+
+type WhoamiReply struct {
+	ID        string
+	PublicKey string
+}
+
+// KeyInfo describes a key of the node, the key itself never leaves it.
+type KeyInfo struct {
+	ID        string
+	CreatedAt time.Time
+	Current   bool
+}
+
+type KeysReply struct {
+	Keys []KeyInfo
+}
+
+// OwnerUsage is the space taken on the node by the files of an owner.
+type OwnerUsage struct {
+	ID   string
+	Used int64
+	// Zero when there is no limit.
+	Quota int64
+}
+
+type UsageReply struct {
+	Owners []OwnerUsage
+	// All the owners together.
+	Used  int64
+	Quota int64
+	// Disk of the node, both zero when unknown.
+	Capacity int64
+	Free     int64
+	MinFree  int64
+	// Disks the peers advertised.
+	Peers []fileserver.PeerDisk
+}
+
+type RotateKeyReply struct {
+	KeyID string
+	// Number of files whose data keys were rewrapped with the new key.
+	Rewrapped int
+}
+
+// Service is the API a running node exposes to the CLI. Its methods follow
+// the net/rpc conventions.
+type Service struct {
+	fs   *fileserver.FileServer
+	stop func()
+
+	readersLock sync.Mutex
+	readers     map[string]io.Reader
+}
+
+// NewService wraps the file server, stop is called when a client asks the
+// node to shut down.
+func NewService(fs *fileserver.FileServer, stop func()) *Service {
+	return &Service{
+		fs:      fs,
+		stop:    stop,
+		readers: make(map[string]io.Reader),
+	}
+}
+
+// Store stores the file into Key when it is a directory, under the name it
+// has on the host.
+func (s *Service) Store(args StoreArgs, reply *Empty) error {
+	key, err := store.CleanKey(args.Key)
+	if err != nil {
+		return err
+	}
+	if s.fs.IsDir(key) {
+		key = path.Join(key, filepath.Base(args.Path))
+	}
+	file, err := os.Open(args.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return s.fs.Store(key, file)
+}
+
+// Open fetches the file, from the network if needed, and returns a handle to
+// read it chunk by chunk with Read.
+func (s *Service) Open(args OpenArgs, reply *OpenReply) (err error) {
+	if args.Key, err = store.CleanKey(args.Key); err != nil {
+		return err
+	}
+	owner := args.Owner
+	if len(owner) == 0 {
+		owner = s.fs.ID
+	}
+	if len(args.Version) > 0 && owner != s.fs.ID {
+		return fmt.Errorf("%w: only the owner reads older versions", fileserver.ErrAccessDenied)
+	}
+	var r io.Reader
+	switch {
+	case args.Offset > 0 || args.Length > 0:
+		r, err = s.fs.GetRange(owner, args.Key, args.Version, args.Offset, args.Length)
+	case len(args.Version) > 0:
+		r, err = s.fs.GetVersion(args.Key, args.Version)
+	default:
+		r, err = s.fs.GetShared(owner, args.Key)
+	}
+	if err != nil {
+		return err
+	}
+	if meta, err := s.fs.StatVersion(owner, args.Key, args.Version); err == nil {
+		reply.Size = meta.Size
+		reply.Siblings = meta.Siblings
+	}
+
+	s.readersLock.Lock()
+	defer s.readersLock.Unlock()
+
+	reply.Handle = uuid.NewString()
+	s.readers[reply.Handle] = r
+	return nil
+}
+
+func (s *Service) Read(args ReadArgs, reply *ReadReply) error {
+	s.readersLock.Lock()
+	r, ok := s.readers[args.Handle]
+	s.readersLock.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown handle %s", args.Handle)
+	}
+
+	buf := make([]byte, readChunkSize)
+	n, err := io.ReadFull(r, buf)
+	reply.Data = buf[:n]
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		reply.EOF = true
+		return nil
+	}
+	return err
+}
+
+func (s *Service) Close(args CloseArgs, reply *Empty) error {
+	s.readersLock.Lock()
+	defer s.readersLock.Unlock()
+
+	r, ok := s.readers[args.Handle]
+	if !ok {
+		return nil
+	}
+	delete(s.readers, args.Handle)
+	if rc, ok := r.(io.Closer); ok {
+		return rc.Close()
+	}
+	return nil
+}
+
+func (s *Service) List(args ListArgs, reply *ListReply) error {
+	files, err := s.fs.List(args.Prefix)
+	if err != nil {
+		return err
+	}
+	reply.Files = files
+	return nil
+}
+
+// Delete removes the file, or the directory when it is empty.
+func (s *Service) Delete(args DeleteArgs, reply *Empty) error {
+	key, err := store.CleanKey(args.Key)
+	if err != nil {
+		return err
+	}
+	if _, err := s.fs.Stat(key); err != nil && s.fs.IsDir(key) {
+		return s.fs.RemoveDir(key)
+	}
+	return s.fs.Delete(key)
+}
+
+func (s *Service) Mkdir(args MkdirArgs, reply *Empty) error {
+	return s.fs.Mkdir(args.Dir)
+}
+
+func (s *Service) Move(args MoveArgs, reply *Empty) error {
+	return s.fs.Move(args.Src, args.Dst)
+}
+
+func (s *Service) ListDir(args ListDirArgs, reply *ListDirReply) error {
+	dirs, files, err := s.fs.ListDir(args.Dir, args.Recursive)
+	if err != nil {
+		return err
+	}
+	reply.Dirs, reply.Files = dirs, files
+	return nil
+}
+
+func (s *Service) Versions(args VersionsArgs, reply *VersionsReply) (err error) {
+	if args.Key, err = store.CleanKey(args.Key); err != nil {
+		return err
+	}
+	versions, err := s.fs.Versions(args.Key)
+	if err != nil {
+		return err
+	}
+	reply.Versions = versions
+	if meta, err := s.fs.Stat(args.Key); err == nil {
+		reply.Latest, reply.Siblings = meta.VersionID, meta.Siblings
+	}
+	return nil
+}
+
+func (s *Service) Restore(args RestoreArgs, reply *Empty) (err error) {
+	if args.Key, err = store.CleanKey(args.Key); err != nil {
+		return err
+	}
+	return s.fs.Restore(args.Key, args.Version)
+}
+
+func (s *Service) Share(args ShareArgs, reply *Empty) (err error) {
+	if args.Key, err = store.CleanKey(args.Key); err != nil {
+		return err
+	}
+	ttl := args.TTL
+	if ttl <= 0 {
+		ttl = fileserver.DefaultShareTTL
+	}
+	return s.fs.Share(args.Key, args.Grantee, ttl)
+}
+
+func (s *Service) Whoami(args Empty, reply *WhoamiReply) error {
+	reply.ID = s.fs.ID
+	reply.PublicKey = s.fs.PublicKey()
+	return nil
+}
+
+func (s *Service) Usage(args Empty, reply *UsageReply) error {
+	usage, err := s.fs.FsStore.Usage()
+	if err != nil {
+		return err
+	}
+	for id, used := range usage {
+		reply.Owners = append(reply.Owners, OwnerUsage{ID: id, Used: used, Quota: s.fs.Quotas.OwnerLimit(id)})
+		reply.Used += used
+	}
+	sort.Slice(reply.Owners, func(i, j int) bool { return reply.Owners[i].ID < reply.Owners[j].ID })
+	reply.Quota = s.fs.Quotas.Node
+	if space, err := s.fs.FsStore.DiskSpace(); err == nil {
+		reply.Capacity, reply.Free = space.Capacity, space.Free
+	}
+	reply.MinFree = s.fs.Quotas.MinFree
+	reply.Peers = s.fs.PeerDisks()
+	return nil
+}
+
+func (s *Service) Keys(args Empty, reply *KeysReply) error {
+	current, _ := s.fs.Keystore.Current()
+	for _, k := range s.fs.Keystore.Keys() {
+		reply.Keys = append(reply.Keys, KeyInfo{ID: k.ID, CreatedAt: k.CreatedAt, Current: k.ID == current})
+	}
+	return nil
+}
+
+func (s *Service) RotateKey(args Empty, reply *RotateKeyReply) error {
+	keyID, n, err := s.fs.RotateKey()
+	if err != nil {
+		return err
+	}
+	reply.KeyID = keyID
+	reply.Rewrapped = n
+	return nil
+}
+
+// Stop shuts the node down once the reply has been sent.
+func (s *Service) Stop(args Empty, reply *Empty) error {
+	go s.stop()
+	return nil
+}
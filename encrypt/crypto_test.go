@@ -3,6 +3,7 @@ package encrypt
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"testing"
 )
 
@@ -35,3 +36,66 @@ func TestCopyEncryptDecrypt(t *testing.T) {
 		t.Errorf("decryption failed!!!")
 	}
 }
+
+func TestDecryptReaderSeek(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef-"), 100)
+	key := NewEncryptionKey()
+	dst := new(bytes.Buffer)
+	if _, err := CopyEncrypt(key, bytes.NewReader(payload), dst); err != nil {
+		t.Fatal(err)
+	}
+
+	dr, err := NewDecryptReader(key, bytes.NewReader(dst.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, off := range []int64{0, 5, 16, 33, 1000, int64(len(payload)) - 3} {
+		if _, err := dr.Seek(off, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(dr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload[off:]) {
+			t.Errorf("wrong plain text read from offset %d", off)
+		}
+	}
+	if end, _ := dr.Seek(0, io.SeekEnd); end != int64(len(payload)) {
+		t.Errorf("want the end at %d have %d", len(payload), end)
+	}
+
+	// A slice of the cipher text after the IV decrypts on its own.
+	sealed := dst.Bytes()
+	for _, off := range []int64{0, 7, 32, 999} {
+		slice := append(append([]byte(nil), sealed[:Overhead]...), sealed[Overhead+off:Overhead+off+40]...)
+		sr, err := NewDecryptReaderAt(key, off, bytes.NewReader(slice))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(sr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload[off:off+40]) {
+			t.Errorf("wrong plain text read from the slice at %d", off)
+		}
+		if _, err := sr.Seek(off-1, io.SeekStart); err == nil && off > 0 {
+			t.Errorf("want an error seeking before the slice at %d", off)
+		}
+	}
+}
+
+func TestWrapKey(t *testing.T) {
+	key := NewEncryptionKey()
+	wrapped, err := WrapKey(key, []byte("data key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := UnwrapKey(key, wrapped); err != nil || string(got) != "data key" {
+		t.Errorf("want the data key back have %q %v", got, err)
+	}
+	if _, err := UnwrapKey(NewEncryptionKey(), wrapped); err == nil {
+		t.Error("want an error unwrapping with another key")
+	}
+}
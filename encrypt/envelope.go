@@ -0,0 +1,218 @@
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Overhead is the number of bytes CopyEncrypt writes in front of the data,
+// the IV.
+const Overhead = aes.BlockSize
+
+// WrapKey encrypts the data key of a file with the key of its owner.
+func WrapKey(kek []byte, key []byte) ([]byte, error) {
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, key, nil), nil
+}
+
+// UnwrapKey returns the data key WrapKey encrypted, it fails when kek is not
+// the key it was wrapped with.
+func UnwrapKey(kek []byte, wrapped []byte) ([]byte, error) {
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+	key, err := gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping key: %w", err)
+	}
+	return key, nil
+}
+
+// WrapKeyFor wraps key for the holder of the private key of pub, with the
+// secret shared between pub and a new ephemeral key pair. The public key of
+// the pair is returned along with the wrapped key.
+func WrapKeyFor(pub *ecdh.PublicKey, key []byte) (ephemeral []byte, wrapped []byte, err error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	kek, err := sharedKey(priv, pub, priv.PublicKey(), pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err = WrapKey(kek, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv.PublicKey().Bytes(), wrapped, nil
+}
+
+// UnwrapKeyFrom returns the key WrapKeyFor wrapped for priv.
+func UnwrapKeyFrom(priv *ecdh.PrivateKey, ephemeral []byte, wrapped []byte) ([]byte, error) {
+	pub, err := ecdh.X25519().NewPublicKey(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := sharedKey(priv, pub, pub, priv.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	return UnwrapKey(kek, wrapped)
+}
+
+// sharedKey derives the key wrapping the data key from the X25519 secret,
+// bound to both public keys.
+func sharedKey(priv *ecdh.PrivateKey, peer *ecdh.PublicKey, ephemeral *ecdh.PublicKey, recipient *ecdh.PublicKey) ([]byte, error) {
+	secret, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+	kek := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte("dfs grant")), kek); err != nil {
+		return nil, err
+	}
+	return kek, nil
+}
+
+// PublicKeyString encodes the public key users are told to share with.
+func PublicKeyString(pub *ecdh.PublicKey) string {
+	return hex.EncodeToString(pub.Bytes())
+}
+
+func ParsePublicKey(s string) (*ecdh.PublicKey, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("public key: %w", err)
+	}
+	return ecdh.X25519().NewPublicKey(b)
+}
+
+// DecryptReader decrypts what CopyEncrypt wrote while it is read. It can
+// seek when the source can, CTR mode lets the decryption start at any
+// offset.
+type DecryptReader struct {
+	src    io.Reader
+	block  cipher.Block
+	iv     []byte
+	stream cipher.Stream
+	off    int64 // Offset in the plain text.
+	// Offset in the plain text of the start of src, less the IV when src
+	// holds the whole cipher text.
+	base int64
+}
+
+func NewDecryptReader(key []byte, src io.Reader) (*DecryptReader, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, block.BlockSize())
+	if _, err := io.ReadFull(src, iv); err != nil {
+		return nil, err
+	}
+	return &DecryptReader{
+		src:    src,
+		block:  block,
+		iv:     iv,
+		stream: cipher.NewCTR(block, iv),
+		base:   -Overhead,
+	}, nil
+}
+
+// NewDecryptReaderAt is NewDecryptReader for a slice of what CopyEncrypt
+// wrote: src holds the IV, then the cipher text from offset on. The counter
+// is moved forward to offset rather than decrypting what comes before.
+func NewDecryptReaderAt(key []byte, offset int64, src io.Reader) (*DecryptReader, error) {
+	if offset < 0 {
+		return nil, errors.New("decrypt: negative offset")
+	}
+	d, err := NewDecryptReader(key, src)
+	if err != nil {
+		return nil, err
+	}
+	d.stream = ctrAt(d.block, d.iv, offset)
+	d.off, d.base = offset, offset-Overhead
+	return d, nil
+}
+
+func (d *DecryptReader) Read(b []byte) (int, error) {
+	n, err := d.src.Read(b)
+	d.stream.XORKeyStream(b[:n], b[:n])
+	d.off += int64(n)
+	return n, err
+}
+
+func (d *DecryptReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := d.src.(io.Seeker)
+	if !ok {
+		return 0, errors.New("seek: source is not seekable")
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.off
+	case io.SeekEnd:
+		end, err := seeker.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, err
+		}
+		offset += end + d.base
+	default:
+		return 0, errors.New("seek: invalid whence")
+	}
+	if offset < 0 || offset < d.base+Overhead {
+		return 0, errors.New("seek: position before the cipher text held")
+	}
+	if _, err := seeker.Seek(offset-d.base, io.SeekStart); err != nil {
+		return 0, err
+	}
+	d.stream = ctrAt(d.block, d.iv, offset)
+	d.off = offset
+	return offset, nil
+}
+
+// Close closes the source when it is an io.Closer.
+func (d *DecryptReader) Close() error {
+	if c, ok := d.src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// ctrAt returns the CTR stream positioned at offset, the counter is the IV
+// incremented once per block as a big endian number.
+func ctrAt(block cipher.Block, iv []byte, offset int64) cipher.Stream {
+	size := int64(block.BlockSize())
+	counter := append([]byte(nil), iv...)
+	n := uint64(offset / size)
+	for i := len(counter) - 1; i >= 0 && n > 0; i-- {
+		sum := uint64(counter[i]) + n&0xff
+		counter[i] = byte(sum)
+		n = n>>8 + sum>>8
+	}
+	stream := cipher.NewCTR(block, counter)
+	skip := make([]byte, offset%size)
+	stream.XORKeyStream(skip, skip)
+	return stream
+}
@@ -0,0 +1,303 @@
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var (
+	// ErrWrongPassphrase is returned when the keystore cannot be unsealed.
+	ErrWrongPassphrase = errors.New("wrong keystore passphrase")
+	// ErrUnknownKey is returned for a key ID the keystore does not hold.
+	ErrUnknownKey = errors.New("unknown key")
+)
+
+const keystoreVersion = 1
+
+// Argon2id parameters of the keys sealing the keystore, the ones recommended
+// by RFC 9106 for memory constrained hosts.
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
+)
+
+// StoredKey is a key of the node, identified by the ID recorded next to the
+// blobs it encrypted.
+type StoredKey struct {
+	ID        string    `json:"id"`
+	Key       []byte    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Keystore holds the keys of the node in a file sealed with a passphrase.
+// The newest key encrypts, the older ones are kept so that the blobs which
+// were not re-encrypted yet can still be read. It also holds the X25519
+// identity the files shared with the user are wrapped for, and the Ed25519
+// key the user signs capability tokens with.
+type Keystore struct {
+	path       string // Empty for a keystore kept in memory only.
+	passphrase []byte
+
+	mu       sync.RWMutex
+	current  string
+	keys     map[string]StoredKey
+	identity *ecdh.PrivateKey
+	signer   ed25519.PrivateKey
+}
+
+// On disk layout, the keys are sealed with AES-GCM under a key derived from
+// the passphrase.
+type keystoreFile struct {
+	Version int       `json:"version"`
+	KDF     kdfParams `json:"kdf"`
+	Nonce   []byte    `json:"nonce"`
+	Sealed  []byte    `json:"sealed"`
+}
+
+type kdfParams struct {
+	Name    string `json:"name"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+type keystoreContent struct {
+	Current  string      `json:"current"`
+	Keys     []StoredKey `json:"keys"`
+	Identity []byte      `json:"identity"`
+	Signer   []byte      `json:"signer"` // Ed25519 seed.
+}
+
+// CreateKeystore writes a new keystore at path holding key, a new key is
+// generated when it is nil. It fails if the file already exists.
+func CreateKeystore(path string, passphrase []byte, key []byte) (*Keystore, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("keystore %s already exists", path)
+	}
+	if key == nil {
+		key = NewEncryptionKey()
+	}
+	ks := &Keystore{path: path, passphrase: passphrase, keys: map[string]StoredKey{}}
+	if err := ks.generateIdentity(); err != nil {
+		return nil, err
+	}
+	ks.add(key)
+	if err := ks.save(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// OpenKeystore unseals the keystore at path.
+func OpenKeystore(path string, passphrase []byte) (*Keystore, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keystoreFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("decoding keystore %s: %w", path, err)
+	}
+	if file.Version != keystoreVersion || file.KDF.Name != "argon2id" {
+		return nil, fmt.Errorf("keystore %s: unsupported version %d (%s)", path, file.Version, file.KDF.Name)
+	}
+	gcm, err := newGCM(argon2.IDKey(passphrase, file.KDF.Salt, file.KDF.Time, file.KDF.Memory, file.KDF.Threads, 32))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, file.Nonce, file.Sealed, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	var content keystoreContent
+	if err := json.Unmarshal(plain, &content); err != nil {
+		return nil, fmt.Errorf("decoding keystore %s: %w", path, err)
+	}
+
+	ks := &Keystore{path: path, passphrase: passphrase, current: content.Current, keys: map[string]StoredKey{}}
+	for _, k := range content.Keys {
+		ks.keys[k.ID] = k
+	}
+	if _, ok := ks.keys[ks.current]; !ok {
+		return nil, fmt.Errorf("keystore %s: %w %s", path, ErrUnknownKey, ks.current)
+	}
+	if len(content.Identity) > 0 {
+		if ks.identity, err = ecdh.X25519().NewPrivateKey(content.Identity); err != nil {
+			return nil, fmt.Errorf("keystore %s: %w", path, err)
+		}
+	}
+	if len(content.Signer) == ed25519.SeedSize {
+		ks.signer = ed25519.NewKeyFromSeed(content.Signer)
+	}
+	if ks.identity == nil || ks.signer == nil {
+		// Created before the files could be shared or the tokens signed.
+		if err := ks.generateIdentity(); err != nil {
+			return nil, err
+		}
+		return ks, ks.save()
+	}
+	return ks, nil
+}
+
+// NewMemoryKeystore returns a keystore holding key which is never written to
+// disk, the keys it rotates to are lost on exit.
+func NewMemoryKeystore(key []byte) *Keystore {
+	ks := &Keystore{keys: map[string]StoredKey{}}
+	ks.generateIdentity()
+	ks.add(key)
+	return ks
+}
+
+// Identity returns the private key the data keys shared with the user are
+// wrapped for.
+func (ks *Keystore) Identity() *ecdh.PrivateKey {
+	return ks.identity
+}
+
+// Signer returns the private key the capability tokens of the user are
+// signed with.
+func (ks *Keystore) Signer() ed25519.PrivateKey {
+	return ks.signer
+}
+
+// Current returns the key new blobs are encrypted with.
+func (ks *Keystore) Current() (string, []byte) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.current, ks.keys[ks.current].Key
+}
+
+// Key returns the key with the given ID.
+func (ks *Keystore) Key(id string) ([]byte, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	k, ok := ks.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownKey, id)
+	}
+	return k.Key, nil
+}
+
+// Keys returns all the keys, oldest first.
+func (ks *Keystore) Keys() []StoredKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	keys := make([]StoredKey, 0, len(ks.keys))
+	for _, k := range ks.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+// Rotate generates a new key, makes it the current one and saves the
+// keystore. The previous keys are kept.
+func (ks *Keystore) Rotate() (string, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	previous := ks.current
+	id := ks.add(NewEncryptionKey())
+	if err := ks.save(); err != nil {
+		delete(ks.keys, id)
+		ks.current = previous
+		return "", err
+	}
+	return id, nil
+}
+
+// generateIdentity generates the key pairs of the user which are missing.
+func (ks *Keystore) generateIdentity() error {
+	var err error
+	if ks.identity == nil {
+		if ks.identity, err = ecdh.X25519().GenerateKey(rand.Reader); err != nil {
+			return err
+		}
+	}
+	if ks.signer == nil {
+		_, ks.signer, err = ed25519.GenerateKey(rand.Reader)
+	}
+	return err
+}
+
+// add stores the key under a new random ID and makes it the current one.
+func (ks *Keystore) add(key []byte) string {
+	buf := make([]byte, 8)
+	io.ReadFull(rand.Reader, buf)
+	id := hex.EncodeToString(buf)
+	ks.keys[id] = StoredKey{ID: id, Key: key, CreatedAt: time.Now().UTC()}
+	ks.current = id
+	return id
+}
+
+// save seals the keys with a fresh salt and nonce and replaces the file, it
+// is a no-op for a keystore kept in memory.
+func (ks *Keystore) save() error {
+	if len(ks.path) == 0 {
+		return nil
+	}
+	content := keystoreContent{Current: ks.current, Identity: ks.identity.Bytes(), Signer: ks.signer.Seed()}
+	for _, k := range ks.keys {
+		content.Keys = append(content.Keys, k)
+	}
+	plain, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+
+	params := kdfParams{Name: "argon2id", Salt: make([]byte, 16), Time: argonTime, Memory: argonMemory, Threads: argonThreads}
+	if _, err := io.ReadFull(rand.Reader, params.Salt); err != nil {
+		return err
+	}
+	gcm, err := newGCM(argon2.IDKey(ks.passphrase, params.Salt, params.Time, params.Memory, params.Threads, 32))
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(keystoreFile{
+		Version: keystoreVersion,
+		KDF:     params,
+		Nonce:   nonce,
+		Sealed:  gcm.Seal(nil, nonce, plain, nil),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Written aside and renamed, a crash never leaves a truncated keystore.
+	if err := os.MkdirAll(filepath.Dir(ks.path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := ks.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ks.path)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
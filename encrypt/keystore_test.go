@@ -0,0 +1,67 @@
+package encrypt
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeystore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	key := NewEncryptionKey()
+	ks, err := CreateKeystore(path, []byte("secret"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstID, _ := ks.Current()
+	secondID, err := ks.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenKeystore(path, []byte("wrong")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("want ErrWrongPassphrase have %v", err)
+	}
+	reopened, err := OpenKeystore(path, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := reopened.Current(); id != secondID {
+		t.Errorf("want the rotated key %s to be current have %s", secondID, id)
+	}
+	old, err := reopened.Key(firstID)
+	if err != nil || !bytes.Equal(old, key) {
+		t.Errorf("the key before the rotation was not kept: %v", err)
+	}
+	if !reopened.Signer().Equal(ks.Signer()) || !reopened.Identity().Equal(ks.Identity()) {
+		t.Error("the identity of the user changed across a reopen")
+	}
+	if len(reopened.Keys()) != 2 {
+		t.Errorf("want 2 keys have %d", len(reopened.Keys()))
+	}
+	if _, err := CreateKeystore(path, []byte("secret"), nil); err == nil {
+		t.Error("want an error creating over an existing keystore")
+	}
+}
+
+func TestWrapKeyFor(t *testing.T) {
+	alice := NewMemoryKeystore(NewEncryptionKey())
+	bob := NewMemoryKeystore(NewEncryptionKey())
+	pub, err := ParsePublicKey(PublicKeyString(bob.Identity().PublicKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := NewEncryptionKey()
+	ephemeral, wrapped, err := WrapKeyFor(pub, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnwrapKeyFrom(bob.Identity(), ephemeral, wrapped)
+	if err != nil || !bytes.Equal(got, key) {
+		t.Errorf("bob cannot unwrap the key shared with him: %v", err)
+	}
+	if _, err := UnwrapKeyFrom(alice.Identity(), ephemeral, wrapped); err == nil {
+		t.Error("want an error unwrapping the key of bob with the identity of alice")
+	}
+}
@@ -0,0 +1,125 @@
+package fileserver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ranjankuldeep/distributed_file_system/auth"
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/ranjankuldeep/distributed_file_system/p2p"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+// Heads of the files of the owner ID, the latest versions along with their
+// siblings, sent when peers connect so that they catch up on the versions
+// written while they were apart.
+type MessageHeads struct {
+	ID    string
+	Heads []store.FileMeta
+}
+
+// Asks the owner ID to replicate the versions of its files the node misses,
+// the ones received in part resume.
+type MessageWantVersions struct {
+	ID       string
+	Versions []store.FileMeta
+}
+
+// sendHeads hands the heads of the files of the owner to a newly connected
+// peer.
+func (fs *FileServer) sendHeads(peer p2p.Peer) {
+	heads, err := fs.FsStore.Heads(fs.ID)
+	if err != nil {
+		fs.log.Errorf("Error reading the heads: %v", err)
+		return
+	}
+	if len(heads) == 0 {
+		return
+	}
+	msg := Message{
+		Payload: MessageHeads{ID: fs.ID, Heads: heads},
+	}
+	if err := fs.send(context.Background(), peer, &msg); err != nil {
+		fs.peerLog(peer.RemoteAddr().String(), "").Errorf("Error sending heads: %v", err)
+	}
+}
+
+// handleMessageHeads looks for the versions the node misses. Another node of
+// the same owner fetches them itself, a node holding replicas asks the owner
+// for the ones of the files it holds.
+func (fs *FileServer) handleMessageHeads(ctx context.Context, from string, msg MessageHeads) error {
+	peer, ok := fs.peer(from)
+	if !ok {
+		return fmt.Errorf("peer (%s) could not be found in the peer list", from)
+	}
+	wants := []store.FileMeta{}
+	for _, h := range msg.Heads {
+		if h.ID != msg.ID || fs.FsStore.Tombstoned(h.ID, h.Key, h.ModifiedAt) || fs.FsStore.Knows(h) {
+			continue
+		}
+		if msg.ID != fs.ID && !fs.FsStore.Has(h.ID, h.Key) && fs.FsStore.PartialSize(h.ID, h.Key, h.VersionID) == 0 {
+			continue // Placed on other peers.
+		}
+		wants = append(wants, h)
+	}
+	if len(wants) == 0 {
+		return nil
+	}
+	fs.peerLog(from, "").Infof("missing %d versions of %s", len(wants), msg.ID)
+	if msg.ID == fs.ID {
+		go fs.pullVersions(peer, wants)
+		return nil
+	}
+	reply := Message{
+		Payload: MessageWantVersions{ID: msg.ID, Versions: wants},
+	}
+	return fs.send(ctx, peer, &reply)
+}
+
+// pullVersions fetches the versions of the files of the owner from another of
+// its nodes, they become the latest ones unless they conflict.
+func (fs *FileServer) pullVersions(peer p2p.Peer, versions []store.FileMeta) {
+	for _, v := range versions {
+		requestID := newRequestID()
+		getFile := MessageGetFile{
+			RequestID: requestID,
+			ID:        fs.ID,
+			Key:       v.Key,
+			Requester: fs.ID,
+			Grantee:   fs.PublicKey(),
+			VersionID: v.VersionID,
+			Token:     fs.issue(requestID, v.Key, auth.OpGet),
+		}
+		log := fs.peerLog(peer.RemoteAddr().String(), requestID)
+		if _, err := fs.fetchFrom(context.Background(), peer, getFile, true); err != nil {
+			log.Errorf("Unable to fetch version %s of %s: %v", v.VersionID, v.Key, err)
+			continue
+		}
+		log.Infof("caught up on version %s of %s", v.VersionID, v.Key)
+	}
+}
+
+// handleMessageWantVersions replicates the versions a peer misses to it.
+func (fs *FileServer) handleMessageWantVersions(ctx context.Context, from string, msg MessageWantVersions) error {
+	peer, ok := fs.peer(from)
+	if !ok {
+		return fmt.Errorf("peer (%s) could not be found in the peer list", from)
+	}
+	if msg.ID != fs.ID {
+		return fmt.Errorf("asked for the versions of %s", msg.ID)
+	}
+	go func() {
+		for _, v := range msg.Versions {
+			requestID := newRequestID()
+			log := fs.log.WithField(logs.FieldRequestID, requestID)
+			meta, err := fs.FsStore.StatVersion(fs.ID, v.Key, v.VersionID)
+			if err != nil {
+				continue // Only in the history of another node.
+			}
+			if err := fs.replicateTo(context.Background(), log, requestID, meta, map[string]p2p.Peer{from: peer}); err != nil {
+				log.Errorf("Unable to replicate version %s of %s: %v", v.VersionID, v.Key, err)
+			}
+		}
+	}()
+	return nil
+}
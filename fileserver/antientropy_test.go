@@ -0,0 +1,60 @@
+package fileserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ranjankuldeep/distributed_file_system/encrypt"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+func TestConcurrentWrites(t *testing.T) {
+	for _, policy := range []store.ConflictPolicy{store.ConflictLWW, store.ConflictKeepBoth} {
+		t.Run(string(policy), func(t *testing.T) {
+			// Two nodes of the same user, with a copy of the keystore each
+			// and no name, write the same key while apart.
+			ks := encrypt.NewMemoryKeystore(encrypt.NewEncryptionKey())
+			home := startTestNode(t, FileServerOpts{Keystore: ks, ConflictPolicy: policy})
+			laptop := startTestNode(t, FileServerOpts{Keystore: ks, ConflictPolicy: policy})
+			if home.ID != laptop.ID {
+				t.Fatalf("want one owner have %s and %s", home.ID, laptop.ID)
+			}
+			if err := home.Store("a.txt", strings.NewReader("home")); err != nil {
+				t.Fatal(err)
+			}
+			if err := laptop.Store("a.txt", strings.NewReader("laptop")); err != nil {
+				t.Fatal(err)
+			}
+			first, _ := home.FsStore.Stat(home.ID, "a.txt")
+			last, _ := laptop.FsStore.Stat(laptop.ID, "a.txt")
+
+			connect(t, home, laptop)
+			for _, fs := range []*FileServer{home, laptop} {
+				waitFor(t, "the nodes to catch up", func() bool {
+					return fs.FsStore.Knows(*first) && fs.FsStore.Knows(*last)
+				})
+				meta, err := fs.FsStore.Stat(fs.ID, "a.txt")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if meta.VersionID != last.VersionID {
+					t.Errorf("want the latest write on both nodes have %s", meta.VersionID)
+				}
+				r, err := fs.Get("a.txt")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if data := readAll(t, r); data != "laptop" {
+					t.Errorf("want laptop have %s", data)
+				}
+				siblings := strings.Join(meta.Siblings, " ")
+				if policy == store.ConflictKeepBoth && siblings != first.VersionID {
+					t.Errorf("want the other write kept as a sibling have %q", siblings)
+				}
+				if policy == store.ConflictLWW && len(siblings) > 0 {
+					t.Errorf("want no siblings have %q", siblings)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package fileserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/auth"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+const (
+	// How long the tokens sent along with the operations of the owner are
+	// valid, enough to cover the transfer of the file.
+	tokenTTL = 5 * time.Minute
+	// How far in the future a delete may be dated, the clocks of the nodes
+	// are not perfectly in sync.
+	maxClockSkew = time.Minute
+	// DefaultShareTTL is how long a file stays shared unless told otherwise.
+	DefaultShareTTL = 30 * 24 * time.Hour
+)
+
+// issue returns a bearer token of the owner for the operations on key, good
+// for the request requestID only.
+func (fs *FileServer) issue(requestID string, key string, ops ...string) *auth.Token {
+	return auth.Issue(fs.Keystore.Signer(), fs.ID, "", requestID, key, tokenTTL, ops...)
+}
+
+// verify checks that the owner signed the token, its ID is bound to its key,
+// and that the token allows op on the key of owner at the given time. A
+// requestID which is not empty must be the one the token was issued for, so
+// that a peer cannot use the tokens it was sent with requests of its own.
+func (fs *FileServer) verify(token *auth.Token, requestID string, owner string, op string, key string, at time.Time) error {
+	if err := token.Verify(owner, op, key, at); err != nil {
+		return err
+	}
+	if len(requestID) > 0 && token.Audience != requestID {
+		return fmt.Errorf("%w: issued for another request", auth.ErrInvalidToken)
+	}
+	return nil
+}
+
+// verifyDelete checks a delete, which may be replayed long after as a
+// tombstone, requestID is empty then: the token must have been issued for
+// this very delete of the version of the key at its time, and have been
+// valid then.
+func (fs *FileServer) verifyDelete(t store.Tombstone, requestID string) error {
+	if t.DeletedAt.After(time.Now().Add(maxClockSkew)) {
+		return fmt.Errorf("%w: delete dated in the future", auth.ErrInvalidToken)
+	}
+	if err := t.Token.VerifyDelete(t.ID, t.Key, t.VersionID, t.DeletedAt); err != nil {
+		return err
+	}
+	if len(requestID) > 0 && t.Token.Audience != requestID {
+		return fmt.Errorf("%w: issued for another request", auth.ErrInvalidToken)
+	}
+	return nil
+}
+
+// signedDigest returns the digest the owner signed for its version, the one
+// the blob received from a peer has to match.
+func signedDigest(owner string, versionID string, sig *auth.VersionSignature) (string, error) {
+	if sig == nil {
+		return "", fmt.Errorf("%w: missing", auth.ErrInvalidSignature)
+	}
+	if err := sig.Verify(owner, versionID, sig.Digest); err != nil {
+		return "", err
+	}
+	return sig.Digest, nil
+}
+
+// verifyGrant checks that the owner of the file issued the grant to its
+// grantee.
+func (fs *FileServer) verifyGrant(g *store.Grant) error {
+	if err := fs.verify(g.Token, "", g.ID, auth.OpGet, g.Key, time.Now()); err != nil {
+		return err
+	}
+	if g.Token.Subject != g.Grantee {
+		return fmt.Errorf("%w: issued to another user", auth.ErrInvalidToken)
+	}
+	return nil
+}
+
+// authorize checks that the requester of the file may get it. The owner
+// sends a token, the users the file was shared with are served on the
+// strength of their grant, which is returned: only they can unwrap the key
+// it holds. The grant holds the key of the latest version, so the other
+// versions are for the owner only. The users may still name the latest one,
+// as they do when fetching its chunks.
+func (fs *FileServer) authorize(msg MessageGetFile) (*store.Grant, error) {
+	if msg.Token != nil {
+		return nil, fs.verify(msg.Token, msg.RequestID, msg.ID, auth.OpGet, msg.Key, time.Now())
+	}
+	if len(msg.VersionID) > 0 {
+		if latest, err := fs.FsStore.Stat(msg.ID, msg.Key); err != nil || latest.VersionID != msg.VersionID {
+			return nil, ErrAccessDenied
+		}
+	}
+	grant, err := fs.FsStore.Grant(msg.ID, msg.Key, msg.Grantee)
+	if err != nil {
+		return nil, err
+	}
+	if grant == nil {
+		return nil, ErrAccessDenied
+	}
+	if err := fs.verifyGrant(grant); err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
@@ -0,0 +1,134 @@
+package fileserver
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/p2p"
+)
+
+const (
+	heartbeatInterval = 10 * time.Second
+	// The disk of a peer not heard from for longer is unknown again.
+	heartbeatTimeout = 3 * heartbeatInterval
+)
+
+// Advertises the disk of a node to its peers, sent when they connect and
+// then every heartbeatInterval.
+type MessageHeartbeat struct {
+	ID string
+	// Both zero when the node cannot read them.
+	Capacity int64
+	Free     int64
+	// Free space the node keeps, it refuses the writes going below.
+	MinFree int64
+}
+
+// peerDisk is the last heartbeat of a peer.
+type peerDisk struct {
+	MessageHeartbeat
+	SeenAt time.Time
+}
+
+// room returns how many bytes the peer accepts, -1 when unknown.
+func (d peerDisk) room(now time.Time) int64 {
+	if d.Capacity == 0 || now.Sub(d.SeenAt) > heartbeatTimeout {
+		return -1
+	}
+	return max(d.Free-d.MinFree, 0)
+}
+
+func (fs *FileServer) heartbeat() MessageHeartbeat {
+	hb := MessageHeartbeat{ID: fs.ID, MinFree: fs.Quotas.MinFree}
+	if space, err := fs.FsStore.DiskSpace(); err == nil {
+		hb.Capacity, hb.Free = space.Capacity, space.Free
+	}
+	return hb
+}
+
+func (fs *FileServer) sendHeartbeat(peer p2p.Peer) {
+	msg := Message{Payload: fs.heartbeat()}
+	if err := fs.send(context.Background(), peer, &msg); err != nil {
+		fs.peerLog(peer.RemoteAddr().String(), "").Errorf("Error sending heartbeat: %v", err)
+	}
+}
+
+// heartbeatLoop advertises the disk to all the peers until the server quits.
+func (fs *FileServer) heartbeatLoop() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, peer := range fs.peers() {
+				fs.sendHeartbeat(peer)
+			}
+		case <-fs.Quitch:
+			return
+		}
+	}
+}
+
+func (fs *FileServer) handleMessageHeartbeat(from string, msg MessageHeartbeat) error {
+	fs.disksLock.Lock()
+	defer fs.disksLock.Unlock()
+
+	fs.disks[from] = peerDisk{MessageHeartbeat: msg, SeenAt: time.Now()}
+	return nil
+}
+
+// PeerDisk is the disk a peer advertised in its last heartbeat.
+type PeerDisk struct {
+	Addr     string
+	ID       string
+	Capacity int64
+	Free     int64
+	SeenAt   time.Time
+}
+
+// PeerDisks returns the disks of the peers heard from, by address.
+func (fs *FileServer) PeerDisks() []PeerDisk {
+	fs.disksLock.Lock()
+	defer fs.disksLock.Unlock()
+
+	disks := make([]PeerDisk, 0, len(fs.disks))
+	for addr, d := range fs.disks {
+		disks = append(disks, PeerDisk{Addr: addr, ID: d.ID, Capacity: d.Capacity, Free: d.Free, SeenAt: d.SeenAt})
+	}
+	sort.Slice(disks, func(i, j int) bool { return disks[i].Addr < disks[j].Addr })
+	return disks
+}
+
+// placement picks the peers the replicas of a file of size bytes go to, the
+// ones with the most room first. The peers which told they have no room for
+// it are left out, the ones not heard from come last.
+func (fs *FileServer) placement(size int64) map[string]p2p.Peer {
+	peers := fs.peers()
+	now := time.Now()
+	rooms := make(map[string]int64, len(peers))
+	addrs := make([]string, 0, len(peers))
+	fs.disksLock.Lock()
+	for addr := range peers {
+		room := int64(-1)
+		if disk, ok := fs.disks[addr]; ok {
+			room = disk.room(now)
+		}
+		if room >= 0 && room < size {
+			continue
+		}
+		rooms[addr] = room
+		addrs = append(addrs, addr)
+	}
+	fs.disksLock.Unlock()
+
+	sort.Slice(addrs, func(i, j int) bool { return rooms[addrs[i]] > rooms[addrs[j]] })
+	if fs.ReplicationFactor > 0 && len(addrs) > fs.ReplicationFactor {
+		addrs = addrs[:fs.ReplicationFactor]
+	}
+	placed := make(map[string]p2p.Peer, len(addrs))
+	for _, addr := range addrs {
+		placed[addr] = peers[addr]
+	}
+	return placed
+}
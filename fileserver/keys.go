@@ -0,0 +1,325 @@
+package fileserver
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/auth"
+	"github.com/ranjankuldeep/distributed_file_system/encrypt"
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+// Stat returns the metadata of the local copy of the file, with the size of
+// the plain text.
+func (fs *FileServer) Stat(key string) (*store.FileMeta, error) {
+	return fs.StatShared(fs.ID, key)
+}
+
+// StatShared is Stat for a file of the owner ID.
+func (fs *FileServer) StatShared(owner string, key string) (*store.FileMeta, error) {
+	return fs.StatVersion(owner, key, "")
+}
+
+// StatVersion is StatShared for a version of the file, the latest one when
+// versionID is empty.
+func (fs *FileServer) StatVersion(owner string, key string, versionID string) (*store.FileMeta, error) {
+	meta, err := fs.statLocal(owner, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	plain := plainMeta(*meta)
+	return &plain, nil
+}
+
+// StatNetwork is Stat for a file this node may not hold, the metadata of the
+// newest version the peers hold is returned then.
+func (fs *FileServer) StatNetwork(key string) (*store.FileMeta, error) {
+	if meta, err := fs.Stat(key); err == nil {
+		return meta, nil
+	}
+	meta, _ := fs.locate(context.Background(), MessageGetFile{ID: fs.ID, Key: key})
+	if meta == nil {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+	plain := plainMeta(*meta)
+	return &plain, nil
+}
+
+// plainMeta returns meta with the size of the plain text rather than the
+// one of the encrypted blob.
+func plainMeta(meta store.FileMeta) store.FileMeta {
+	if len(meta.KeyID) > 0 {
+		meta.Size -= encrypt.Overhead
+	}
+	return meta
+}
+
+// RotateKey switches the node to a new key and rewraps the data keys of the
+// files of the owner with it, locally and on the peers. The data itself is
+// not re-encrypted. Files stored before they had data keys of their own are
+// stored again. The previous keys stay in the keystore for the peers which
+// could not be reached. It returns the new key ID and the number of files
+// rewrapped. Only the latest versions are rewrapped, the older ones keep the
+// key they were stored with.
+func (fs *FileServer) RotateKey() (keyID string, n int, err error) {
+	defer fs.metrics.observe("rotate_key", time.Now(), &err)
+	ctx, span := startSpan(context.Background(), "FileServer.RotateKey", "")
+	defer func() { endSpan(span, err) }()
+
+	files, err := fs.List("")
+	if err != nil {
+		return "", 0, err
+	}
+	keyID, err = fs.Keystore.Rotate()
+	if err != nil {
+		return "", 0, err
+	}
+	kek, err := fs.Keystore.Key(keyID)
+	if err != nil {
+		return "", 0, err
+	}
+	fs.log.Infof("rotated to key %s, rewrapping %d files", keyID, len(files))
+
+	for _, file := range files {
+		requestID := newRequestID()
+		log := fs.log.WithField(logs.FieldRequestID, requestID)
+		if len(file.WrappedKey) == 0 {
+			if err := fs.reseal(file.Key); err != nil {
+				log.Errorf("Unable to encrypt %s again: %v", file.Key, err)
+				continue
+			}
+			n++
+			continue
+		}
+
+		dataKey, err := fs.unwrap(&file)
+		if err != nil {
+			log.Errorf("Unable to rewrap %s: %v", file.Key, err)
+			continue
+		}
+		wrappedKey, err := encrypt.WrapKey(kek, dataKey)
+		if err != nil {
+			return keyID, n, err
+		}
+		if fs.FsStore.HasVersion(fs.ID, file.Key, file.VersionID) {
+			if err := fs.FsStore.SetWrappedKey(fs.ID, file.Key, file.VersionID, keyID, wrappedKey); err != nil {
+				log.Errorf("Unable to rewrap %s: %v", file.Key, err)
+				continue
+			}
+		}
+		msg := Message{
+			Payload: MessageRewrapFile{
+				RequestID:  requestID,
+				ID:         fs.ID,
+				Key:        file.Key,
+				VersionID:  file.VersionID,
+				KeyID:      keyID,
+				WrappedKey: wrappedKey,
+				Token:      fs.issue(requestID, file.Key, auth.OpStore),
+			},
+		}
+		if err := fs.BroadCast(ctx, &msg); err != nil {
+			log.Errorf("Unable to rewrap %s on the peers: %v", file.Key, err)
+			continue
+		}
+		n++
+	}
+	return keyID, n, nil
+}
+
+// reseal stores the file again, which gives it a data key of its own.
+func (fs *FileServer) reseal(key string) error {
+	r, err := fs.Get(key)
+	if err != nil {
+		return err
+	}
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+	return fs.Store(key, r)
+}
+
+func (fs *FileServer) handleMessageRewrapFile(ctx context.Context, from string, msg MessageRewrapFile) error {
+	if !fs.FsStore.HasVersion(msg.ID, msg.Key, msg.VersionID) {
+		return nil
+	}
+	if err := fs.verify(msg.Token, msg.RequestID, msg.ID, auth.OpStore, msg.Key, time.Now()); err != nil {
+		return fmt.Errorf("refusing to rewrap %s: %w", msg.Key, err)
+	}
+	_, span := startSpan(ctx, "store.SetWrappedKey", msg.Key)
+	err := fs.FsStore.SetWrappedKey(msg.ID, msg.Key, msg.VersionID, msg.KeyID, msg.WrappedKey)
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+	fs.peerLog(from, msg.RequestID).Infof("rewrapped the key of %s", msg.Key)
+	return nil
+}
+
+// openSealed returns a reader of the plain text of the sealed blob r.
+func (fs *FileServer) openSealed(meta *store.FileMeta, r io.Reader) (*encrypt.DecryptReader, error) {
+	dataKey, err := fs.unwrap(meta)
+	if err != nil {
+		return nil, err
+	}
+	return encrypt.NewDecryptReader(dataKey, r)
+}
+
+// unwrap returns the data key of the file, from the grant for the files of
+// other owners.
+func (fs *FileServer) unwrap(meta *store.FileMeta) ([]byte, error) {
+	if meta.ID != fs.ID {
+		grant, err := fs.FsStore.Grant(meta.ID, meta.Key, fs.PublicKey())
+		if err != nil {
+			return nil, err
+		}
+		if grant == nil {
+			return nil, fmt.Errorf("%w: %s was not shared with this user", ErrAccessDenied, meta.Key)
+		}
+		return encrypt.UnwrapKeyFrom(fs.Keystore.Identity(), grant.EphemeralKey, grant.WrappedKey)
+	}
+	kek, err := fs.keyOf(meta.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	return encrypt.UnwrapKey(kek, meta.WrappedKey)
+}
+
+// keyOf returns the key of the node with the given ID, the replicas written
+// before the key IDs were recorded use the current key.
+func (fs *FileServer) keyOf(keyID string) ([]byte, error) {
+	if len(keyID) == 0 {
+		_, key := fs.Keystore.Current()
+		return key, nil
+	}
+	return fs.Keystore.Key(keyID)
+}
+
+// envelope follows the size of a file streamed to a peer: the key ID and
+// the wrapped data key, then the grant when the file is served to a user it
+// was shared with, then the version ID, its clock, the signature of the
+// owner over its digest and the offset the stream starts at. Each field is prefixed with its length. A slice of the blob is
+// streamed as its IV followed by length bytes from offset.
+type envelope struct {
+	keyID      string
+	wrappedKey []byte
+	grant      *store.Grant
+	versionID  string
+	clock      store.VersionVector
+	timestamp  store.Timestamp
+	signature  *auth.VersionSignature
+	// Where the stream starts in the blob, when resuming a transfer.
+	offset int64
+	// Bytes of the blob streamed when a slice of it was asked for.
+	length int64
+}
+
+// envelopeMeta is the JSON of the last field of the envelope.
+type envelopeMeta struct {
+	Clock     store.VersionVector    `json:"clock"`
+	Timestamp store.Timestamp        `json:"timestamp"`
+	Signature *auth.VersionSignature `json:"signature,omitempty"`
+	Offset    int64                  `json:"offset,omitempty"`
+	Length    int64                  `json:"length,omitempty"`
+}
+
+func writeEnvelope(w io.Writer, env envelope) error {
+	var ephemeralKey, grantKey []byte
+	if env.grant != nil {
+		ephemeralKey, grantKey = env.grant.EphemeralKey, env.grant.WrappedKey
+	}
+	for _, field := range [][]byte{[]byte(env.keyID), env.wrappedKey, ephemeralKey, grantKey, []byte(env.versionID)} {
+		if err := writeShortBytes(w, field); err != nil {
+			return err
+		}
+	}
+	meta, err := json.Marshal(envelopeMeta{Clock: env.clock, Timestamp: env.timestamp, Signature: env.signature, Offset: env.offset, Length: env.length})
+	if err != nil {
+		return err
+	}
+	return writeLongBytes(w, meta)
+}
+
+func readEnvelope(r io.Reader) (envelope, error) {
+	fields := make([][]byte, 5)
+	for i := range fields {
+		field, err := readShortBytes(r)
+		if err != nil {
+			return envelope{}, err
+		}
+		fields[i] = field
+	}
+	env := envelope{keyID: string(fields[0]), wrappedKey: fields[1], versionID: string(fields[4])}
+	if len(fields[3]) > 0 {
+		env.grant = &store.Grant{EphemeralKey: fields[2], WrappedKey: fields[3], CreatedAt: time.Now().UTC()}
+	}
+	b, err := readLongBytes(r)
+	if err != nil {
+		return envelope{}, err
+	}
+	meta := envelopeMeta{}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return envelope{}, err
+	}
+	env.clock, env.timestamp, env.signature = meta.Clock, meta.Timestamp, meta.Signature
+	env.offset, env.length = meta.Offset, meta.Length
+	return env, nil
+}
+
+func writeShortBytes(w io.Writer, b []byte) error {
+	if len(b) > 255 {
+		return fmt.Errorf("%d bytes do not fit a short field", len(b))
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint8(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// The vector clocks grow with the number of nodes, they do not fit a short
+// field.
+const maxLongBytes = 1 << 20
+
+func writeLongBytes(w io.Writer, b []byte) error {
+	if len(b) > maxLongBytes {
+		return fmt.Errorf("%d bytes do not fit a long field", len(b))
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readLongBytes(r io.Reader) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	if size > maxLongBytes {
+		return nil, fmt.Errorf("long field of %d bytes", size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func readShortBytes(r io.Reader) ([]byte, error) {
+	var size uint8
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,112 @@
+package fileserver
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Label values of the origin of the bytes stored and the destination of the
+// bytes served.
+const (
+	originClient = "client"
+	originPeer   = "peer"
+)
+
+// metrics are registered per server rather than globally so that several
+// servers can live in one process, as they do in the tests.
+type metrics struct {
+	registry *prometheus.Registry
+
+	bytesStored    *prometheus.CounterVec
+	bytesServed    *prometheus.CounterVec
+	opDuration     *prometheus.HistogramVec
+	opErrors       *prometheus.CounterVec
+	replicationLag prometheus.Histogram
+
+	peers      *prometheus.Desc
+	queueDepth *prometheus.Desc
+	diskUsage  *prometheus.Desc
+}
+
+func newMetrics(fs *FileServer) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		bytesStored: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dfs_stored_bytes_total",
+			Help: "Bytes written to the local store, by client stores or replicas received from peers.",
+		}, []string{"origin"}),
+		bytesServed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dfs_served_bytes_total",
+			Help: "Bytes of files handed out, to local clients or streamed to peers.",
+		}, []string{"origin"}),
+		opDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "dfs_operation_duration_seconds",
+			Help:    "Latency of the Store, Get and Delete operations.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, []string{"op"}),
+		opErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dfs_operation_errors_total",
+			Help: "Failed Store, Get and Delete operations.",
+		}, []string{"op"}),
+		replicationLag: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "dfs_replication_lag_seconds",
+			Help:    "Time between a write on its owner and the replica being written here.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+		}),
+		peers:      prometheus.NewDesc("dfs_peers", "Connected peers.", nil, nil),
+		queueDepth: prometheus.NewDesc("dfs_rpc_queue_depth", "Messages received and waiting to be handled.", nil, nil),
+		diskUsage:  prometheus.NewDesc("dfs_disk_usage_bytes", "Bytes stored on disk per owner ID.", []string{"owner"}, nil),
+	}
+	m.registry.MustRegister(
+		m.bytesStored, m.bytesServed, m.opDuration, m.opErrors, m.replicationLag,
+		&serverCollector{m: m, fs: fs},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// observe records the latency of an operation and whether it failed, meant
+// to be deferred with a pointer to the named error result.
+func (m *metrics) observe(op string, start time.Time, err *error) {
+	m.opDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		m.opErrors.WithLabelValues(op).Inc()
+	}
+}
+
+// serverCollector reads the gauges from the server state at scrape time.
+type serverCollector struct {
+	m  *metrics
+	fs *FileServer
+}
+
+func (c *serverCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.m.peers
+	ch <- c.m.queueDepth
+	ch <- c.m.diskUsage
+}
+
+func (c *serverCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.m.peers, prometheus.GaugeValue, float64(len(c.fs.peers())))
+	ch <- prometheus.MustNewConstMetric(c.m.queueDepth, prometheus.GaugeValue, float64(len(c.fs.Transport.Consume())))
+
+	usage, err := c.fs.FsStore.Usage()
+	if err != nil {
+		c.fs.log.Errorf("Error reading the disk usage: %v", err)
+		return
+	}
+	for owner, size := range usage {
+		ch <- prometheus.MustNewConstMetric(c.m.diskUsage, prometheus.GaugeValue, float64(size), owner)
+	}
+}
+
+// MetricsHandler serves the metrics of the server in the Prometheus text
+// format.
+func (fs *FileServer) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(fs.metrics.registry, promhttp.HandlerOpts{})
+}
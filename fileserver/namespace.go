@@ -0,0 +1,199 @@
+package fileserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+// The directories of the owner are kept in the index of its own node: the
+// ones made with Mkdir, and the ones leading to the keys of its files.
+
+// Mkdir makes the directory along with its parents.
+func (fs *FileServer) Mkdir(dir string) error {
+	dir = store.CleanDir(dir)
+	if len(dir) == 0 {
+		return nil
+	}
+	return fs.FsStore.Mkdir(fs.ID, dir)
+}
+
+// IsDir reports whether dir is a directory of the owner.
+func (fs *FileServer) IsDir(dir string) bool {
+	return fs.FsStore.IsDir(fs.ID, store.CleanDir(dir))
+}
+
+// CheckFile reports whether a file can be stored at key.
+func (fs *FileServer) CheckFile(key string) error {
+	return fs.FsStore.CheckFile(fs.ID, key)
+}
+
+// ListDir returns the directories and the files in dir, with everything
+// below it when recursive. The directories are full paths, sorted.
+func (fs *FileServer) ListDir(dir string, recursive bool) ([]string, []store.FileMeta, error) {
+	dir = store.CleanDir(dir)
+	if !fs.IsDir(dir) {
+		if _, err := fs.Stat(dir); err == nil {
+			return nil, nil, fmt.Errorf("%w: %s", store.ErrNotDir, dir)
+		}
+		return nil, nil, fmt.Errorf("%w: %s", ErrFileNotFound, dir)
+	}
+	prefix := store.DirPrefix(dir)
+	all, err := fs.List(prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	made, err := fs.FsStore.Dirs(fs.ID, prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dirs := map[string]bool{}
+	// addDirs adds the directories leading from dir to p, p included.
+	addDirs := func(p string) {
+		rel := strings.Split(strings.TrimPrefix(p, prefix), "/")
+		if !recursive {
+			rel = rel[:1]
+		}
+		for i := range rel {
+			dirs[prefix+strings.Join(rel[:i+1], "/")] = true
+		}
+	}
+	for _, d := range made {
+		addDirs(d)
+	}
+	files := []store.FileMeta{}
+	for _, f := range all {
+		rel := strings.TrimPrefix(f.Key, prefix)
+		if i := strings.LastIndex(rel, "/"); i >= 0 {
+			addDirs(prefix + rel[:i])
+			if !recursive {
+				continue
+			}
+		}
+		files = append(files, f)
+	}
+
+	sorted := make([]string, 0, len(dirs))
+	for d := range dirs {
+		sorted = append(sorted, d)
+	}
+	sort.Strings(sorted)
+	return sorted, files, nil
+}
+
+// RemoveDir removes the empty directory.
+func (fs *FileServer) RemoveDir(dir string) error {
+	dirs, files, err := fs.ListDir(dir, false)
+	if err != nil {
+		return err
+	}
+	if len(dirs) > 0 || len(files) > 0 {
+		return fmt.Errorf("directory %s is not empty", dir)
+	}
+	return fs.FsStore.RemoveDirs(fs.ID, store.CleanDir(dir))
+}
+
+// Move renames the file or the directory src to dst, into dst when it is a
+// directory. Every version of the files moves along, the peers get the
+// latest versions under the new keys and drop the old ones as if they were
+// deleted.
+func (fs *FileServer) Move(src string, dst string) (err error) {
+	defer fs.metrics.observe("move", time.Now(), &err)
+	if src, err = store.CleanKey(src); err != nil {
+		return err
+	}
+	dst = store.CleanDir(dst)
+	if len(dst) == 0 || fs.IsDir(dst) {
+		dst = path.Join(dst, path.Base(src))
+	}
+	if src == dst {
+		return nil
+	}
+	// The directory src was in stays, even when it only existed through src.
+	defer func() {
+		if parent := path.Dir(src); err == nil && parent != "." {
+			err = fs.FsStore.Mkdir(fs.ID, parent)
+		}
+	}()
+	if _, err := fs.Stat(src); err == nil || !fs.IsDir(src) {
+		return fs.moveFile(src, dst)
+	}
+
+	if strings.HasPrefix(dst, src+"/") {
+		return fmt.Errorf("cannot move %s into itself", src)
+	}
+	if err := fs.CheckFile(dst); err != nil {
+		return err
+	}
+	files, err := fs.List(store.DirPrefix(src))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := fs.moveFile(f.Key, dst+strings.TrimPrefix(f.Key, src)); err != nil {
+			return err
+		}
+	}
+	return fs.FsStore.RenameDirs(fs.ID, src, dst)
+}
+
+// moveFile renames the file src to dst, fetching it first when this node
+// does not hold it.
+func (fs *FileServer) moveFile(src string, dst string) (err error) {
+	ctx, span := startSpan(context.Background(), "FileServer.Move", src)
+	defer func() { endSpan(span, err) }()
+	requestID := newRequestID()
+	log := fs.log.WithField(logs.FieldRequestID, requestID)
+
+	if err := fs.CheckFile(dst); err != nil {
+		return err
+	}
+	if !fs.FsStore.Has(fs.ID, src) {
+		r, err := fs.Get(src)
+		if err != nil {
+			return err
+		}
+		if rc, ok := r.(io.Closer); ok {
+			rc.Close()
+		}
+	}
+	// The grants are issued for the key, they are issued again for dst.
+	grants, err := fs.FsStore.Grants(fs.ID, src)
+	if err != nil {
+		return err
+	}
+	if err := fs.FsStore.Rename(fs.ID, src, dst); err != nil {
+		return err
+	}
+	log.Infof("moved %s to %s", src, dst)
+	meta, err := fs.FsStore.Stat(fs.ID, dst)
+	if err != nil {
+		return err
+	}
+	if len(meta.WrappedKey) > 0 {
+		dataKey, err := fs.unwrap(meta)
+		if err != nil {
+			return err
+		}
+		for _, g := range grants {
+			if g.Token == nil || time.Now().After(g.Token.Expiry) {
+				continue
+			}
+			if err := fs.grant(ctx, dst, g.Grantee, dataKey, time.Until(g.Token.Expiry)); err != nil {
+				log.Errorf("Failed to share %s again: %v", dst, err)
+			}
+		}
+	}
+	if err := fs.replicate(ctx, log, requestID, meta); err != nil {
+		return err
+	}
+	return fs.Delete(src)
+}
@@ -0,0 +1,241 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/auth"
+	"github.com/ranjankuldeep/distributed_file_system/encrypt"
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/ranjankuldeep/distributed_file_system/p2p"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// GetRange returns length bytes of the plain text of a version of the file
+// from offset on, up to its end when length is zero, the latest version when
+// versionID is empty. Unless this node holds the version, only the range is
+// fetched from the peers and it is not stored.
+func (fs *FileServer) GetRange(owner string, key string, versionID string, offset int64, length int64) (_ io.Reader, err error) {
+	defer fs.metrics.observe("get_range", time.Now(), &err)
+	ctx, span := startSpan(context.Background(), "FileServer.GetRange", key)
+	defer func() { endSpan(span, err) }()
+	if offset < 0 || length < 0 {
+		return nil, fmt.Errorf("invalid range %d+%d of %s", offset, length, key)
+	}
+	requestID := newRequestID()
+	log := fs.log.WithField(logs.FieldRequestID, requestID)
+	if fs.FsStore.Tombstoned(owner, key, time.Time{}) {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+	if fs.hasLocal(owner, key, versionID) {
+		log.Infof("serving %d+%d bytes of file (%s) from local disk", offset, length, key)
+		span.SetAttributes(attrServedBy.String("local"))
+		r, err := fs.readLocal(ctx, owner, key, versionID)
+		if err != nil {
+			return nil, err
+		}
+		// The plain text is seeked into, CTR decrypts from any offset.
+		if err := skip(r, offset); err != nil {
+			if rc, ok := r.(io.Closer); ok {
+				rc.Close()
+			}
+			return nil, err
+		}
+		if length == 0 {
+			return r, nil
+		}
+		closer, _ := r.(io.Closer)
+		return rangeReader{io.LimitReader(r, length), closer}, nil
+	}
+
+	log.Infof("dont have file (%s) locally, fetching %d+%d bytes from network...", key, offset, length)
+	getFile := MessageGetFile{
+		RequestID:   requestID,
+		ID:          owner,
+		Key:         key,
+		Requester:   fs.ID,
+		Grantee:     fs.PublicKey(),
+		VersionID:   versionID,
+		RangeOffset: offset,
+		RangeLength: length,
+	}
+	if owner == fs.ID {
+		getFile.Token = fs.issue(requestID, key, auth.OpGet)
+	}
+	if getFile.RangeOffset == 0 && getFile.RangeLength == 0 {
+		getFile.RangeLength = -1 // The whole file, still without storing it.
+	}
+	// The range is kept aside until read, the peer connection is not held
+	// across the reads of the client.
+	tmp, err := os.CreateTemp("", "dfs-range-*")
+	if err != nil {
+		return nil, err
+	}
+	for addr, peer := range fs.peers() {
+		n, err := fs.fetchRange(ctx, peer, getFile, tmp)
+		if err == nil && n >= 0 {
+			log.WithField(logs.FieldPeer, addr).Infof("received (%d) bytes over the network", n)
+			span.SetAttributes(attrServedBy.String(addr))
+			if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+				break
+			}
+			return tempReader{tmp}, nil
+		}
+		if err != nil {
+			log.WithField(logs.FieldPeer, addr).Errorf("Unable to fetch (%s): %v", key, err)
+		}
+		if err := tmp.Truncate(0); err != nil {
+			break
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			break
+		}
+	}
+	tempReader{tmp}.Close()
+	return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+}
+
+// fetchRange asks a single peer for the range of the file in getFile and
+// writes its plain text to w. It returns -1 when the peer does not hold the
+// file or does not serve it to this user.
+func (fs *FileServer) fetchRange(ctx context.Context, peer p2p.Peer, getFile MessageGetFile, w io.Writer) (int64, error) {
+	owner, key := getFile.ID, getFile.Key
+	return fs.streamRange(ctx, peer, getFile, func(env envelope, r io.Reader) (int64, error) {
+		var dataKey []byte
+		var err error
+		if len(env.wrappedKey) > 0 {
+			dataKey, err = fs.unwrap(&store.FileMeta{ID: owner, Key: key, KeyID: env.keyID, WrappedKey: env.wrappedKey})
+		} else {
+			// A replica encrypted with the owner's key itself.
+			dataKey, err = fs.keyOf(env.keyID)
+		}
+		if err != nil {
+			return 0, err
+		}
+		dr, err := encrypt.NewDecryptReaderAt(dataKey, env.offset-encrypt.Overhead, r)
+		if err != nil {
+			return 0, err
+		}
+		return io.Copy(w, dr)
+	})
+}
+
+// streamRange asks a single peer for the slice of the blob in getFile and
+// hands read the envelope and the stream: the IV of the blob, then the slice.
+// It returns -1 when the peer does not hold the file or does not serve it to
+// this user.
+func (fs *FileServer) streamRange(ctx context.Context, peer p2p.Peer, getFile MessageGetFile, read func(env envelope, r io.Reader) (int64, error)) (_ int64, err error) {
+	owner, key := getFile.ID, getFile.Key
+	ctx, span := startSpan(ctx, "FileServer.fetchRange", key, trace.WithAttributes(attrPeer.String(peer.RemoteAddr().String())))
+	defer func() { endSpan(span, err) }()
+
+	getFile.StreamID = newRequestID()
+	if err := fs.send(ctx, peer, &Message{Payload: getFile}); err != nil {
+		return 0, err
+	}
+	stream, release, err := fs.awaitStream(peer, getFile.StreamID)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	var fileSize int64
+	if err := binary.Read(stream, binary.LittleEndian, &fileSize); err != nil {
+		return 0, err
+	}
+	if fileSize < 0 {
+		return -1, nil
+	}
+	env, err := readEnvelope(stream)
+	if err != nil {
+		return 0, err
+	}
+	if env.offset < encrypt.Overhead || env.length < 0 || env.offset+env.length > fileSize {
+		return 0, fmt.Errorf("slice of %s at %d+%d of %d bytes", key, env.offset, env.length, fileSize)
+	}
+	lr := io.LimitReader(stream, encrypt.Overhead+env.length)
+	if env.grant != nil {
+		env.grant.ID, env.grant.Key, env.grant.Grantee = owner, key, fs.PublicKey()
+		err = fs.FsStore.PutGrant(*env.grant)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return read(env, lr)
+}
+
+// serveRange streams the slice of the version asked for in msg: the IV of
+// the blob, which the requester needs to decrypt it, then the cipher text of
+// the range.
+func (fs *FileServer) serveRange(ctx context.Context, log *logrus.Entry, peer p2p.Peer, msg MessageGetFile, meta *store.FileMeta, grant *store.Grant) error {
+	_, span := startSpan(ctx, "store.ReadRange", msg.Key)
+	fileSize, iv, err := fs.FsStore.ReadRange(msg.ID, msg.Key, meta.VersionID, 0, encrypt.Overhead)
+	var body io.ReadCloser
+	var offset, length int64
+	if err == nil {
+		defer iv.Close()
+		offset = min(encrypt.Overhead+max(msg.RangeOffset, 0), fileSize)
+		length = fileSize - offset
+		if msg.RangeLength > 0 {
+			length = min(length, msg.RangeLength)
+		}
+		_, body, err = fs.FsStore.ReadRange(msg.ID, msg.Key, meta.VersionID, offset, length)
+	}
+	endSpan(span, err)
+	if err != nil {
+		refuseStream(peer, msg.StreamID)
+		return err
+	}
+	defer body.Close()
+
+	_, span = startSpan(ctx, "p2p.Stream", msg.Key, trace.WithAttributes(attrPeer.String(peer.RemoteAddr().String()), attrBytes.Int64(length)))
+	head := bytes.NewBuffer(sizeHead(fileSize))
+	writeEnvelope(head, envelope{
+		keyID:      meta.KeyID,
+		wrappedKey: meta.WrappedKey,
+		grant:      grant,
+		versionID:  meta.VersionID,
+		clock:      meta.Clock,
+		timestamp:  meta.Timestamp,
+		offset:     offset,
+		length:     length,
+	})
+	n, err := sendStream(peer, msg.StreamID, head.Bytes(), io.MultiReader(iv, body), encrypt.Overhead+length)
+	endSpan(span, err)
+	fs.metrics.bytesServed.WithLabelValues(originPeer).Add(float64(n))
+	if err != nil {
+		return err
+	}
+	log.Infof("written (%d) bytes of %s from %d over the network", n, msg.Key, offset)
+	return nil
+}
+
+// rangeReader reads a range of the plain text and closes the whole file.
+type rangeReader struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r rangeReader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// tempReader reads a range fetched from a peer, removed once closed.
+type tempReader struct {
+	*os.File
+}
+
+func (t tempReader) Close() error {
+	t.File.Close()
+	return os.Remove(t.Name())
+}
@@ -0,0 +1,66 @@
+package fileserver
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetRangeFromPeer(t *testing.T) {
+	alice := startTestNode(t, FileServerOpts{ID: "alice"})
+	bob := startTestNode(t, FileServerOpts{ID: "bob"})
+	dave := startTestNode(t, FileServerOpts{ID: "dave"})
+	carol := startTestNode(t, FileServerOpts{ID: "carol"})
+	connect(t, alice, bob)
+	connect(t, dave, bob)
+	connect(t, carol, bob)
+
+	var b strings.Builder
+	for i := 0; b.Len() < 100000; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	data := b.String()
+	if err := alice.Store("a.txt", strings.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.FsStore.Delete(alice.ID, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []struct{ offset, length int64 }{{0, 10}, {12345, 1000}, {int64(len(data)) - 50, 0}} {
+		got, err := alice.GetRange(alice.ID, "a.txt", "", r.offset, r.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := data[r.offset:]
+		if r.length > 0 {
+			want = want[:r.length]
+		}
+		if have := readAll(t, got); have != want {
+			t.Errorf("%d+%d: want %q have %q", r.offset, r.length, want, have)
+		}
+	}
+	if alice.FsStore.Has(alice.ID, "a.txt") {
+		t.Error("want only the ranges fetched, not the file")
+	}
+
+	// The users the file was shared with get ranges of it as well.
+	if err := alice.Share("a.txt", dave.PublicKey(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the grant to reach bob", func() bool {
+		g, err := bob.FsStore.Grant(alice.ID, "a.txt", dave.PublicKey())
+		return err == nil && g != nil
+	})
+	got, err := dave.GetRange(alice.ID, "a.txt", "", 500, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := readAll(t, got); have != data[500:600] {
+		t.Errorf("want %q have %q", data[500:600], have)
+	}
+	if _, err := carol.GetRange(alice.ID, "a.txt", "", 500, 100); err == nil {
+		t.Error("want the range refused to the user the file was not shared with")
+	}
+}
@@ -0,0 +1,201 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/ranjankuldeep/distributed_file_system/p2p"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// How long a request waits for the replies of the peers.
+const requestTimeout = 2 * time.Second
+
+// pendingRequest collects the replies addressed to a single request ID.
+type pendingRequest struct {
+	replies chan reply
+}
+
+type reply struct {
+	From    string
+	Payload any
+}
+
+func newRequestID() string {
+	return uuid.NewString()
+}
+
+// peerLocks serialize the writes to the connection of a peer. A stream has
+// to be written in one go without any message in between. The streams read
+// from the peer are handed over by streamLoop, see awaitStream.
+type peerLocks struct {
+	write sync.Mutex
+}
+
+func (fs *FileServer) locksOf(peer p2p.Peer) *peerLocks {
+	fs.PeerLock.Lock()
+	defer fs.PeerLock.Unlock()
+
+	addr := peer.RemoteAddr().String()
+	locks, ok := fs.locks[addr]
+	if !ok {
+		locks = &peerLocks{}
+		fs.locks[addr] = locks
+	}
+	return locks
+}
+
+// lockPeers takes the write lock of all the peers, in the order of their
+// address so that two goroutines locking overlapping peers cannot deadlock.
+func (fs *FileServer) lockPeers(peers map[string]p2p.Peer) (unlock func()) {
+	addrs := make([]string, 0, len(peers))
+	for addr := range peers {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	locked := make([]*peerLocks, 0, len(addrs))
+	for _, addr := range addrs {
+		locks := fs.locksOf(peers[addr])
+		locks.write.Lock()
+		locked = append(locked, locks)
+	}
+	return func() {
+		for _, locks := range locked {
+			locks.write.Unlock()
+		}
+	}
+}
+
+// send encodes the message and writes it to a single peer.
+func (fs *FileServer) send(ctx context.Context, peer p2p.Peer, msg *Message) error {
+	locks := fs.locksOf(peer)
+	locks.write.Lock()
+	defer locks.write.Unlock()
+
+	return fs.writeMessage(ctx, peer, msg)
+}
+
+// writeMessage is send for callers already holding the write lock of the peer.
+func (fs *FileServer) writeMessage(ctx context.Context, peer p2p.Peer, msg *Message) (err error) {
+	ctx, span := tracer.Start(ctx, "p2p.Send "+messageName(msg.Payload),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attrPeer.String(peer.RemoteAddr().String())),
+	)
+	defer func() { endSpan(span, err) }()
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(withTraceContext(ctx, msg)); err != nil {
+		return err
+	}
+	return peer.Send(p2p.FrameMessage(buf.Bytes()))
+}
+
+// peers returns a snapshot of the connected peers keyed by their address.
+func (fs *FileServer) peers() map[string]p2p.Peer {
+	fs.PeerLock.Lock()
+	defer fs.PeerLock.Unlock()
+
+	peers := make(map[string]p2p.Peer, len(fs.Peers))
+	for addr, peer := range fs.Peers {
+		peers[addr] = peer
+	}
+	return peers
+}
+
+func (fs *FileServer) peer(addr string) (p2p.Peer, bool) {
+	fs.PeerLock.Lock()
+	defer fs.PeerLock.Unlock()
+
+	peer, ok := fs.Peers[addr]
+	return peer, ok
+}
+
+// openRequest registers a request ID whose replies are handed over by
+// deliverReply, it must be released with closeRequest.
+func (fs *FileServer) openRequest(requestID string, expected int) *pendingRequest {
+	fs.pendingLock.Lock()
+	defer fs.pendingLock.Unlock()
+
+	req := &pendingRequest{replies: make(chan reply, expected)}
+	fs.pending[requestID] = req
+	return req
+}
+
+func (fs *FileServer) closeRequest(requestID string) {
+	fs.pendingLock.Lock()
+	defer fs.pendingLock.Unlock()
+
+	delete(fs.pending, requestID)
+}
+
+// deliverReply hands a reply over to the request waiting for it. Late replies,
+// arriving once the request timed out, are dropped.
+func (fs *FileServer) deliverReply(requestID string, from string, payload any) {
+	fs.pendingLock.Lock()
+	defer fs.pendingLock.Unlock()
+
+	req, ok := fs.pending[requestID]
+	if !ok {
+		return
+	}
+	select {
+	case req.replies <- reply{From: from, Payload: payload}:
+	default:
+	}
+}
+
+// collect waits until n replies arrived or the request timed out.
+func (req *pendingRequest) collect(n int) []reply {
+	return req.collectWithin(n, requestTimeout)
+}
+
+// collectWithin is collect giving up after timeout.
+func (req *pendingRequest) collectWithin(n int, timeout time.Duration) []reply {
+	replies := []reply{}
+	expired := time.After(timeout)
+	for len(replies) < n {
+		select {
+		case r := <-req.replies:
+			replies = append(replies, r)
+		case <-expired:
+			return replies
+		}
+	}
+	return replies
+}
+
+// appendUnique appends to have the strings of add it does not hold yet, in
+// order.
+func appendUnique(have []string, add ...string) []string {
+	for _, addr := range add {
+		found := false
+		for _, h := range have {
+			if h == addr {
+				found = true
+				break
+			}
+		}
+		if !found {
+			have = append(have, addr)
+		}
+	}
+	return have
+}
+
+// peerLog returns the logger for the handling of a message from the peer,
+// requestID is the one the sender attached to it, if any.
+func (fs *FileServer) peerLog(from string, requestID string) *logrus.Entry {
+	log := fs.log.WithField(logs.FieldPeer, from)
+	if len(requestID) > 0 {
+		log = log.WithField(logs.FieldRequestID, requestID)
+	}
+	return log
+}
@@ -0,0 +1,97 @@
+package fileserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/p2p"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+// How long a partly received version is kept without the transfer resuming.
+const partialMaxAge = 24 * time.Hour
+
+// Asks the peers how many bytes of a version they received already, from a
+// replica whose transfer broke off.
+type MessagePartialSize struct {
+	RequestID string
+	ID        string
+	Key       string
+	VersionID string
+}
+
+type MessagePartialSizeResult struct {
+	RequestID string
+	Size      int64
+}
+
+// partialSizes returns the bytes of the version meta the peers received
+// already, by address. The peers which do not answer in time start over.
+func (fs *FileServer) partialSizes(ctx context.Context, meta *store.FileMeta, peers map[string]p2p.Peer) map[string]int64 {
+	requestID := newRequestID()
+	req := fs.openRequest(requestID, len(peers))
+	defer fs.closeRequest(requestID)
+
+	msg := Message{
+		Payload: MessagePartialSize{RequestID: requestID, ID: meta.ID, Key: meta.Key, VersionID: meta.VersionID},
+	}
+	for _, peer := range peers {
+		if err := fs.send(ctx, peer, &msg); err != nil {
+			fs.peerLog(peer.RemoteAddr().String(), requestID).Errorf("Error asking for the partial size of %s: %v", meta.Key, err)
+		}
+	}
+	sizes := map[string]int64{}
+	for _, r := range req.collect(len(peers)) {
+		if size := r.Payload.(MessagePartialSizeResult).Size; size > 0 && size <= meta.Size {
+			sizes[r.From] = size
+		}
+	}
+	return sizes
+}
+
+func (fs *FileServer) handleMessagePartialSize(ctx context.Context, from string, msg MessagePartialSize) error {
+	peer, ok := fs.peer(from)
+	if !ok {
+		return fmt.Errorf("peer (%s) could not be found in the peer list", from)
+	}
+	result := MessagePartialSizeResult{RequestID: msg.RequestID, Size: fs.FsStore.PartialSize(msg.ID, msg.Key, msg.VersionID)}
+	return fs.send(ctx, peer, &Message{Payload: result})
+}
+
+// resumePoint returns the version of the file partly received from a
+// transfer which broke off and how many of its bytes, the one asked for
+// when versionID is not empty, the latest one received otherwise.
+func (fs *FileServer) resumePoint(owner string, key string, versionID string) (string, int64) {
+	if len(versionID) > 0 {
+		return versionID, fs.FsStore.PartialSize(owner, key, versionID)
+	}
+	partials, err := fs.FsStore.Partials(owner)
+	if err != nil {
+		return "", 0
+	}
+	var latest *store.Partial
+	for i, p := range partials {
+		if p.Key == key && (latest == nil || latest.UpdatedAt.Before(p.UpdatedAt)) {
+			latest = &partials[i]
+		}
+	}
+	if latest == nil {
+		return "", 0
+	}
+	return latest.VersionID, fs.FsStore.PartialSize(owner, key, latest.VersionID)
+}
+
+// skip moves r n bytes forward, seeking when it can.
+func skip(r io.Reader, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
+}
@@ -0,0 +1,67 @@
+package fileserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+func TestResumeReplica(t *testing.T) {
+	alice := startTestNode(t, FileServerOpts{ID: "alice"})
+	bob := startTestNode(t, FileServerOpts{ID: "bob"})
+
+	// Bob received the first bytes of a.txt and b.txt before the transfers
+	// broke off, those of b.txt were tampered with since.
+	const received = 20000
+	metas := map[string]*store.FileMeta{}
+	for _, key := range []string{"a.txt", "b.txt"} {
+		if err := alice.Store(key, strings.NewReader(strings.Repeat(key, 20000))); err != nil {
+			t.Fatal(err)
+		}
+		meta, err := alice.FsStore.Stat(alice.ID, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metas[key] = meta
+		_, r, err := alice.FsStore.ReadVersion(alice.ID, key, meta.VersionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blob := make([]byte, received)
+		if _, err := io.ReadFull(r, blob); err != nil {
+			t.Fatal(err)
+		}
+		if rc, ok := r.(io.Closer); ok {
+			rc.Close()
+		}
+		if key == "b.txt" {
+			blob[100] ^= 1
+		}
+		tmpl := store.FileMeta{ID: alice.ID, Key: key, KeyID: meta.KeyID, WrappedKey: meta.WrappedKey, VersionID: meta.VersionID, Clock: meta.Clock, Timestamp: meta.Timestamp, Signature: meta.Signature, Digest: meta.Digest}
+		broken := io.MultiReader(bytes.NewReader(blob), iotest.ErrReader(errors.New("connection reset")))
+		if _, err := bob.FsStore.WriteSealedAt(tmpl, 0, broken); err == nil {
+			t.Fatal("want the broken transfer to fail")
+		}
+		if size := bob.FsStore.PartialSize(alice.ID, key, meta.VersionID); size != received {
+			t.Fatalf("want %d bytes received have %d", received, size)
+		}
+	}
+
+	// Once connected, bob asks for the versions he holds in part and alice
+	// sends him the rest of them.
+	connect(t, alice, bob)
+	waitFor(t, "the replica of a.txt", func() bool { return bob.FsStore.HasVersion(alice.ID, "a.txt", metas["a.txt"].VersionID) })
+	if meta, err := bob.FsStore.Stat(alice.ID, "a.txt"); err != nil || meta.Digest != metas["a.txt"].Digest {
+		t.Errorf("want the whole blob kept have %+v and %v", meta, err)
+	}
+	// Not matching the digest signed by alice, the tampered one is dropped.
+	waitFor(t, "the tampered blob to be dropped", func() bool { return bob.FsStore.PartialSize(alice.ID, "b.txt", metas["b.txt"].VersionID) == 0 })
+	if bob.FsStore.HasVersion(alice.ID, "b.txt", metas["b.txt"].VersionID) {
+		t.Error("want the tampered blob refused")
+	}
+}
@@ -2,28 +2,68 @@ package fileserver
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/gob"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/ranjankuldeep/distributed_file_system/auth"
 	"github.com/ranjankuldeep/distributed_file_system/encrypt"
 	"github.com/ranjankuldeep/distributed_file_system/logs"
 	"github.com/ranjankuldeep/distributed_file_system/p2p"
 	"github.com/ranjankuldeep/distributed_file_system/store"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var (
+	// ErrFileNotFound is returned by Get when neither the local store nor any
+	// peer holds the file.
+	ErrFileNotFound = errors.New("file not found")
+	// ErrAccessDenied is returned for a file of another owner which was not
+	// shared with this user.
+	ErrAccessDenied = errors.New("access denied")
+	// ErrReplicaRefused is returned by Store when a peer did not keep its
+	// replica of the file, over its quota for instance.
+	ErrReplicaRefused = errors.New("replica refused")
+)
+
+// Name of the owner when none is given, followed by the fingerprint of the
+// signing key in its ID.
+const defaultOwnerName = "user"
+
 type FileServerOpts struct {
-	EncKey []byte
+	// Keys the replicas are encrypted with, a keystore kept in memory and
+	// holding only EncKey is used when nil.
+	Keystore *encrypt.Keystore
+	EncKey   []byte
 	// ID of the owner of the storage, which will be used to store all the files and folders at the location
-	// so we can sync all the files if needed.
+	// so we can sync all the files if needed. It is completed with the
+	// fingerprint of the signing key, defaultOwnerName when empty.
 	ID                string
 	StorageRoot       string
 	PathTransformFunc store.PathTransformFunc
 	Transport         p2p.Transport
 	BootStrapNodes    []string
+	// How long tombstones of deleted files are kept before being garbage
+	// collected, peers offline for longer may bring deleted files back.
+	TombstoneGracePeriod time.Duration
+	// Limits the bytes stored for every owner and for the node.
+	Quotas store.Quotas
+	// Number of peers a file is replicated to, all of them when zero.
+	ReplicationFactor int
+	// Settles the versions of a file written concurrently through different
+	// nodes, store.ConflictLWW when empty.
+	ConflictPolicy store.ConflictPolicy
 }
 type FileServer struct {
 	FileServerOpts
@@ -32,190 +72,727 @@ type FileServer struct {
 
 	PeerLock sync.Mutex
 	Peers    map[string]p2p.Peer
+
+	pendingLock sync.Mutex
+	pending     map[string]*pendingRequest
+	locks       map[string]*peerLocks // Guarded by PeerLock.
+
+	streamsLock sync.Mutex
+	streams     map[string]*streamSlot // By peer address and stream ID.
+
+	disksLock sync.Mutex
+	disks     map[string]peerDisk // Last heartbeat of the peers by address.
+
+	metrics *metrics
+	log     *logrus.Entry // Carries the node ID on every line.
 }
 
 // Message that is wired over.
 type Message struct {
 	Payload any
+	// W3C trace context of the sender, so the handling of the message joins
+	// the trace of the operation which sent it.
+	TraceContext map[string]string
 }
 
 // Idenifier that payload will be of to store files.
 type MessageStoreFile struct {
-	ID   string
-	Key  string
-	Size int64
+	RequestID  string
+	ID         string
+	Key        string
+	Size       int64
+	ModifiedAt time.Time
+	// Data key of the file wrapped with the owner's key KeyID, the stream
+	// is the cipher text as stored by the owner.
+	KeyID       string
+	WrappedKey  []byte
+	PlainDigest string
+	// Version of the file written by the owner, kept by the replica too,
+	// along with the writes it has seen and when it was written.
+	VersionID string
+	Clock     store.VersionVector
+	Timestamp store.Timestamp
+	// Signature of the owner over the digest of the version.
+	Signature *auth.VersionSignature
+	// Bytes of the version the peer received already, the stream carries
+	// the rest of the Size bytes.
+	Offset int64
+	// ID of the stream of the file following the message.
+	StreamID string
+	// Capability of the owner allowing the store.
+	Token *auth.Token
+}
+
+// Tells the owner whether the replica of a MessageStoreFile was kept.
+type MessageStoreFileResult struct {
+	RequestID string
+	Key       string
+	// Why the replica was refused, empty when it was kept.
+	Error string
 }
 
 type MessageGetFile struct {
-	ID  string
-	Key string
+	RequestID string
+	ID        string
+	Key       string
+	// ID and public key of the user asking, the file is only served to its
+	// owner and to the users it was shared with.
+	Requester string
+	Grantee   string
+	// Version asked for, the latest one when empty.
+	VersionID string
+	// Bytes of the version ResumeVersionID the requester received already,
+	// the stream starts there when it is the version served.
+	ResumeVersionID string
+	Offset          int64
+	// Slice of the plain text asked for instead of the whole version, from
+	// RangeOffset on and RangeLength bytes long, up to the end when negative
+	// or zero but for both zero. It is streamed back to be read, the
+	// requester does not store it.
+	RangeOffset int64
+	RangeLength int64
+	// ID to give the stream answering the request.
+	StreamID string
+	// Capability of the owner, the users the file was shared with go without.
+	Token *auth.Token
 }
 
 type MessageDeleteFile struct {
-	ID  string
-	Key string
+	RequestID string
+	ID        string
+	Key       string
+	VersionID string
+	DeletedAt time.Time
+	// Bound to the version and the time of the delete, it is handed on with
+	// the tombstone.
+	Token *auth.Token
+}
+
+// Replaces the wrapped data key of a file after the owner rotated its key.
+type MessageRewrapFile struct {
+	RequestID  string
+	ID         string
+	Key        string
+	VersionID  string
+	KeyID      string
+	WrappedKey []byte
+	Token      *auth.Token
+}
+
+// Publishes that a file was shared with another user.
+type MessageGrant struct {
+	RequestID string
+	Grant     store.Grant
+}
+
+// Tombstones known by a node, exchanged when peers connect so that the
+// deletes missed while offline are applied.
+type MessageTombstones struct {
+	Tombstones []store.Tombstone
+}
+
+// Asks the peers for the files they hold for the owner ID under the prefix.
+type MessageListFiles struct {
+	RequestID string
+	ID        string
+	Prefix    string
+}
+
+type MessageListFilesResult struct {
+	RequestID string
+	Files     []store.FileMeta
+}
+
+// Asks the peers for the metadata of a version of the file, the latest one
+// when VersionID is empty, to fetch it from all of its holders at once.
+type MessageStatFile struct {
+	RequestID string
+	ID        string
+	Key       string
+	VersionID string
+}
+
+type MessageStatFileResult struct {
+	RequestID string
+	// Nil when the peer does not hold the version.
+	Meta *store.FileMeta
 }
 
 func NewFileServer(opts FileServerOpts) *FileServer {
 	storeOpts := store.StoreOpts{
 		Root:              opts.StorageRoot,
 		PathTransformFunc: opts.PathTransformFunc,
+		Quotas:            opts.Quotas,
+		ConflictPolicy:    opts.ConflictPolicy,
 	}
 	if len(opts.ID) == 0 {
-		opts.ID = encrypt.GenerateID()
+		// The ID then only depends on the signing key, the node owns the
+		// same files for as long as it keeps its keystore.
+		opts.ID = defaultOwnerName
+	}
+	if opts.TombstoneGracePeriod == 0 {
+		opts.TombstoneGracePeriod = defaultTombstoneGracePeriod
 	}
-	return &FileServer{
+	if opts.Keystore == nil {
+		opts.Keystore = encrypt.NewMemoryKeystore(opts.EncKey)
+	}
+	// The owner ID carries the fingerprint of the signing key for the peers
+	// to check the tokens of the owner against.
+	opts.ID = auth.OwnerID(opts.ID, opts.Keystore.Signer().Public().(ed25519.PublicKey))
+	fs := &FileServer{
 		FileServerOpts: opts,
 		FsStore:        store.NewStore(storeOpts),
 		Quitch:         make(chan struct{}),
 		Peers:          make(map[string]p2p.Peer),
 		PeerLock:       sync.Mutex{},
+		pending:        make(map[string]*pendingRequest),
+		streams:        make(map[string]*streamSlot),
+		locks:          make(map[string]*peerLocks),
+		disks:          make(map[string]peerDisk),
+		log:            logs.Logger.WithField(logs.FieldNode, opts.ID),
 	}
+	fs.metrics = newMetrics(fs)
+	return fs
 }
 
 func (fs *FileServer) StartServer() error {
 	if err := fs.Transport.ListenAndAccept(); err != nil {
-		logs.Logger.Errorf("Failed to Listen")
+		fs.log.Errorf("Failed to Listen")
 		return err
 	}
 	fs.bootStrapNetwork() // Non Blocking
-	fs.ReadLoop()         // Blocking
+	go fs.tombstoneGCLoop()
+	go fs.heartbeatLoop()
+	fs.ReadLoop() // Blocking
 	return nil
 }
 
 func (fs *FileServer) StopServer() error {
 	close(fs.Quitch)
 	if err := fs.Transport.Close(); err != nil {
-		logs.Logger.Error("Failed to stop the Server")
+		fs.log.Error("Failed to stop the Server")
+		return err
+	}
+	if err := fs.FsStore.Close(); err != nil {
+		fs.log.Error("Failed to close the Store")
 		return err
 	}
-	logs.Logger.Info("Quiting the File Server")
+	fs.log.Info("Quiting the File Server")
 	return nil
 }
 
 func (fs *FileServer) Get(key string) (io.Reader, error) {
-	if fs.FsStore.Has(fs.ID, key) {
-		logs.Logger.Infof("[%s] serving file (%s) from local disk\n", fs.Transport.Addr(), key)
-		_, r, err := fs.FsStore.Read(fs.ID, key)
-		return r, err
-	}
+	return fs.GetShared(fs.ID, key)
+}
 
-	logs.Logger.Infof("[%s] dont have file (%s) locally, fetching from network...\n", fs.Transport.Addr(), key)
-	msg := Message{
-		Payload: MessageGetFile{
-			ID:  fs.ID,
-			Key: key,
-		},
+// GetShared returns a file of the owner ID, which is either this node's
+// owner or a user who shared the file with it.
+func (fs *FileServer) GetShared(owner string, key string) (io.Reader, error) {
+	return fs.get(owner, key, "")
+}
+
+// get returns a version of a file of the owner ID, the latest one when
+// versionID is empty.
+func (fs *FileServer) get(owner string, key string, versionID string) (_ io.Reader, err error) {
+	defer fs.metrics.observe("get", time.Now(), &err)
+	ctx, span := startSpan(context.Background(), "FileServer.Get", key)
+	defer func() { endSpan(span, err) }()
+	requestID := newRequestID()
+	log := fs.log.WithField(logs.FieldRequestID, requestID)
+	if fs.FsStore.Tombstoned(owner, key, time.Time{}) {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+	if fs.hasLocal(owner, key, versionID) {
+		log.Infof("serving file (%s) from local disk", key)
+		span.SetAttributes(attrServedBy.String("local"))
+		return fs.readLocal(ctx, owner, key, versionID)
 	}
 
-	if err := fs.BroadCast(&msg); err != nil {
-		return nil, err
+	log.Infof("dont have file (%s) locally, fetching from network...", key)
+	getFile := MessageGetFile{
+		RequestID: requestID,
+		ID:        owner,
+		Key:       key,
+		Requester: fs.ID,
+		Grantee:   fs.PublicKey(),
+		VersionID: versionID,
+	}
+	if owner == fs.ID {
+		getFile.Token = fs.issue(requestID, key, auth.OpGet)
 	}
-	time.Sleep(time.Millisecond * 500)
 
-	// Any peer over the network will start streaming the data.
-	for _, peer := range fs.Peers {
-		// First read the file size so we can limit the amount of bytes that we read
-		// from the connection, so it will not keep hanging.
-		var fileSize int64
-		binary.Read(peer, binary.LittleEndian, &fileSize)
-		_, err := fs.FsStore.WriteDecrypt(fs.EncKey, fs.ID, key, io.LimitReader(peer, fileSize))
+	// The peers holding the version serve a chunk of it each at a time.
+	if meta, peers := fs.locate(ctx, getFile); len(peers) > 1 && len(meta.ChunkDigests) > 1 {
+		if err := fs.fetchChunks(ctx, log, getFile, meta, peers, len(versionID) == 0); err != nil {
+			log.Errorf("Unable to fetch (%s) from %d peers at once: %v", key, len(peers), err)
+		} else {
+			span.SetAttributes(attrServedBy.String(fmt.Sprintf("%d peers", len(peers))))
+		}
+	}
+	// Otherwise ask the peers in turn until one of them streams the file
+	// back, each one resumes where the previous one broke off.
+	for addr, peer := range fs.peers() {
+		if fs.hasLocal(owner, key, versionID) {
+			break
+		}
+		fileSize, err := fs.fetchFrom(ctx, peer, getFile, len(versionID) == 0)
 		if err != nil {
-			logs.Logger.Errorf("Unable to Write the Data Fetched by over the Network.")
+			log.WithField(logs.FieldPeer, addr).Errorf("Unable to fetch (%s): %v", key, err)
+			continue
 		}
-		logs.Logger.Infof("[%s] received (%d) bytes over the network from (%s)", fs.Transport.Addr(), fileSize, peer.RemoteAddr())
-		peer.CloseStream()
+		if fileSize < 0 {
+			continue
+		}
+		log.WithField(logs.FieldPeer, addr).Infof("received (%d) bytes over the network", fileSize)
+		span.SetAttributes(attrServedBy.String(addr))
+		break
+	}
+	if !fs.hasLocal(owner, key, versionID) {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
 	}
 
-	_, r, err := fs.FsStore.Read(fs.ID, key)
+	r, err := fs.readLocal(ctx, owner, key, versionID)
 	if err != nil {
-		logs.Logger.Errorf("Cannot read from the store %s", key)
+		log.Errorf("Cannot read from the store %s", key)
 	}
 	return r, err
 }
 
-func (fs *FileServer) Store(key string, r io.Reader) error {
-	var (
-		fileBuffer = new(bytes.Buffer)
-		tee        = io.TeeReader(r, fileBuffer)
-	)
-	// 1. SAVE THE FILE TO THIS DISK and get the size of the file written locally (important for EOF on the network)
-	size, err := fs.FsStore.Write(fs.ID, key, tee)
+// hasLocal reports whether this node holds a version of the file, the latest
+// one when versionID is empty.
+func (fs *FileServer) hasLocal(owner string, key string, versionID string) bool {
+	if len(versionID) > 0 {
+		return fs.FsStore.HasVersion(owner, key, versionID)
+	}
+	return fs.FsStore.Has(owner, key)
+}
+
+// readLocal returns the plain text of the local copy of a version of the
+// file, the latest one when versionID is empty.
+func (fs *FileServer) readLocal(ctx context.Context, owner string, key string, versionID string) (io.Reader, error) {
+	meta, err := fs.statLocal(owner, key, versionID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	_, span := startSpan(ctx, "store.Read", key)
+	_, r, err := fs.FsStore.ReadVersion(owner, key, meta.VersionID)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	fs.metrics.bytesServed.WithLabelValues(originClient).Add(float64(plainMeta(*meta).Size))
+	if len(meta.WrappedKey) == 0 {
+		return r, nil // Stored before the files had keys of their own.
+	}
+	dr, err := fs.openSealed(meta, r)
+	if err != nil {
+		if rc, ok := r.(io.Closer); ok {
+			rc.Close()
+		}
+		return nil, err
 	}
-	logs.Logger.Infof("[%s] Stored (%d) bytes to disk\n", fs.Transport.Addr(), size)
-	logs.Logger.Info("Broadcasting to other Peers")
+	return dr, nil
+}
 
-	msg := Message{
-		// Payload if of message store file hinting remote server to store the data.
-		Payload: MessageStoreFile{
-			ID:  fs.ID,
-			Key: key,
-			// Hashed key will be stored on network as we don't want the other server to guess about the file by its name.
-			// Specify the data size. (important)
-
-			// Since we are first encrypting the data, it cost additional 16 byte of blockSize.
-			Size: size + 16,
-		},
+// statLocal returns the metadata of a version of the file, the latest one
+// when versionID is empty.
+func (fs *FileServer) statLocal(owner string, key string, versionID string) (*store.FileMeta, error) {
+	if len(versionID) > 0 {
+		return fs.FsStore.StatVersion(owner, key, versionID)
 	}
-	// 2. BROADCAST THE FILE TO ALL KNOWN PEERS IN THE NETWORK.
-	// 3. Broadcast the Key over the network
-	if err := fs.BroadCast(&msg); err != nil {
-		return err
+	return fs.FsStore.Stat(owner, key)
+}
+
+// fetchFrom asks a single peer for a version of the file and writes what it
+// streams back to the local store, as a candidate for the latest version with
+// latest. A transfer which broke off before resumes where it stopped. It
+// returns a size of -1 when the peer does not hold it or does not serve it to
+// this user.
+func (fs *FileServer) fetchFrom(ctx context.Context, peer p2p.Peer, getFile MessageGetFile, latest bool) (_ int64, err error) {
+	owner, key := getFile.ID, getFile.Key
+	ctx, span := startSpan(ctx, "FileServer.fetch", key, trace.WithAttributes(attrPeer.String(peer.RemoteAddr().String())))
+	defer func() { endSpan(span, err) }()
+
+	getFile.ResumeVersionID, getFile.Offset = fs.resumePoint(owner, key, getFile.VersionID)
+	getFile.StreamID = newRequestID()
+	if err := fs.send(ctx, peer, &Message{Payload: getFile}); err != nil {
+		return 0, err
 	}
+	stream, release, err := fs.awaitStream(peer, getFile.StreamID)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
 
-	time.Sleep(time.Millisecond * 1000)
+	// First read the file size so we can limit the amount of bytes that we read
+	// from the connection, so it will not keep hanging.
+	var fileSize int64
+	if err := binary.Read(stream, binary.LittleEndian, &fileSize); err != nil {
+		return 0, err
+	}
+	if fileSize < 0 {
+		return -1, nil
+	}
+	env, err := readEnvelope(stream)
+	if err != nil {
+		return 0, err
+	}
+	if env.offset < 0 || env.offset > fileSize {
+		return 0, fmt.Errorf("stream of %s starts at %d of %d bytes", key, env.offset, fileSize)
+	}
+	lr := io.LimitReader(stream, fileSize-env.offset)
+	if env.offset > 0 {
+		fs.peerLog(peer.RemoteAddr().String(), getFile.RequestID).Infof("resuming %s at %d of %d bytes", key, env.offset, fileSize)
+	}
+	if env.grant != nil {
+		env.grant.ID, env.grant.Key, env.grant.Grantee = owner, key, fs.PublicKey()
+		if err := fs.FsStore.PutGrant(*env.grant); err != nil {
+			return 0, err
+		}
+	}
+	var n int64
+	if len(env.wrappedKey) > 0 {
+		// The cipher text is kept as is, it is decrypted when read, once it
+		// matches the digest the owner signed.
+		digest, err := signedDigest(owner, env.versionID, env.signature)
+		if err != nil {
+			return 0, err
+		}
+		tmpl := store.FileMeta{
+			ID:         owner,
+			Key:        key,
+			KeyID:      env.keyID,
+			WrappedKey: env.wrappedKey,
+			VersionID:  env.versionID,
+			Clock:      env.clock,
+			Timestamp:  env.timestamp,
+			Signature:  env.signature,
+			Digest:     digest,
+		}
+		var written *store.FileMeta
+		_, writeSpan := startSpan(ctx, "store.Write", key)
+		if latest {
+			written, err = fs.FsStore.WriteSealedAt(tmpl, env.offset, lr)
+		} else {
+			written, err = fs.FsStore.WriteVersionAt(tmpl, env.offset, lr)
+		}
+		endSpan(writeSpan, err)
+		if err == nil {
+			n = written.Size
+		}
+	} else {
+		// A replica encrypted with the owner's key itself.
+		var encKey []byte
+		if env.offset > 0 {
+			err = fmt.Errorf("%w: %s has no version to resume", store.ErrNoPartial, key)
+		} else if encKey, err = fs.keyOf(env.keyID); err == nil {
+			_, writeSpan := startSpan(ctx, "store.WriteDecrypt", key)
+			n, err = fs.FsStore.WriteDecrypt(encKey, owner, key, lr)
+			endSpan(writeSpan, err)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	fs.metrics.bytesStored.WithLabelValues(originPeer).Add(float64(n))
+	return fileSize, nil
+}
 
-	peers := []io.Writer{}
-	for _, peer := range fs.Peers {
-		peers = append(peers, peer)
+// Store encrypts the file, saves it under key and replicates it. key is a
+// path, cleaned first; it must not be a directory nor be below a file.
+func (fs *FileServer) Store(key string, r io.Reader) (err error) {
+	defer fs.metrics.observe("store", time.Now(), &err)
+	if key, err = store.CleanKey(key); err != nil {
+		return err
+	}
+	if err := fs.CheckFile(key); err != nil {
+		return err
+	}
+	ctx, span := startSpan(context.Background(), "FileServer.Store", key)
+	defer func() { endSpan(span, err) }()
+	requestID := newRequestID()
+	log := fs.log.WithField(logs.FieldRequestID, requestID)
+	// 1. ENCRYPT THE FILE with a key of its own, of which only the wrapped copy is kept.
+	dataKey := encrypt.NewEncryptionKey()
+	keyID, kek := fs.Keystore.Current()
+	wrappedKey, err := encrypt.WrapKey(kek, dataKey)
+	if err != nil {
+		return err
 	}
-	mw := io.MultiWriter(peers...)
-	mw.Write([]byte{p2p.IncomingStream})
-	// Send the encrypted message data over the network.
-	if _, err := encrypt.CopyEncrypt(fs.EncKey, fileBuffer, mw); err != nil {
-		logs.Logger.Errorf("Failed to stream data %v", err)
+	// The plain text is hashed on the way, to tell later whether a file
+	// changed without decrypting it.
+	plainHash := sha256.New()
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := encrypt.CopyEncrypt(dataKey, io.TeeReader(r, plainHash), pw)
+		pw.CloseWithError(err)
+	}()
+	// 2. SAVE THE CIPHER TEXT TO THIS DISK and get its size (important for EOF on the network)
+	_, writeSpan := startSpan(ctx, "store.Write", key)
+	meta, err := fs.FsStore.WriteSealed(store.FileMeta{ID: fs.ID, Key: key, KeyID: keyID, WrappedKey: wrappedKey}, pr)
+	pr.CloseWithError(err) // Stops the encryption when the write failed.
+	endSpan(writeSpan, err)
+	if err != nil {
 		return err
 	}
+	fs.metrics.bytesStored.WithLabelValues(originClient).Add(float64(meta.Size))
+	log.Infof("Stored (%d) bytes of %s to disk", meta.Size, key)
+	meta.PlainDigest = hex.EncodeToString(plainHash.Sum(nil))
+	if err := fs.FsStore.SetPlainDigest(fs.ID, key, meta.VersionID, meta.PlainDigest); err != nil {
+		log.Errorf("Failed to record the digest of %s: %v", key, err)
+	}
+	// The peers fetching the version check the blob against the signature.
+	meta.Signature = auth.SignVersion(fs.Keystore.Signer(), fs.ID, meta.VersionID, meta.Digest)
+	if err := fs.FsStore.SetSignature(fs.ID, key, meta.VersionID, meta.Signature); err != nil {
+		log.Errorf("Failed to record the signature of %s: %v", key, err)
+	}
+	// The users the file was shared with need the new data key.
+	if err := fs.regrant(ctx, key, dataKey); err != nil {
+		log.Errorf("Failed to share %s again: %v", key, err)
+	}
+	return fs.replicate(ctx, log, requestID, meta)
+}
+
+// replicate streams the local copy of the file to the peers picked by
+// placement, it is already encrypted so the peers never see the plain text.
+func (fs *FileServer) replicate(ctx context.Context, log *logrus.Entry, requestID string, meta *store.FileMeta) error {
+	return fs.replicateTo(ctx, log, requestID, meta, fs.placement(meta.Size))
+}
+
+// replicateTo streams the version meta of the file to the peers, each one
+// from the bytes it received already on when its transfer broke off before.
+func (fs *FileServer) replicateTo(ctx context.Context, log *logrus.Entry, requestID string, meta *store.FileMeta, peers map[string]p2p.Peer) error {
+	if len(peers) == 0 {
+		return nil
+	}
+	if meta.Signature == nil {
+		// Written before the versions were signed, the peers refuse it so.
+		meta.Signature = auth.SignVersion(fs.Keystore.Signer(), fs.ID, meta.VersionID, meta.Digest)
+		if err := fs.FsStore.SetSignature(fs.ID, meta.Key, meta.VersionID, meta.Signature); err != nil {
+			log.Errorf("Failed to record the signature of %s: %v", meta.Key, err)
+		}
+	}
+	// The peers resuming from the same offset share a stream.
+	offsets := fs.partialSizes(ctx, meta, peers)
+	byOffset := map[int64]map[string]p2p.Peer{}
+	for addr, peer := range peers {
+		offset := offsets[addr]
+		if byOffset[offset] == nil {
+			byOffset[offset] = map[string]p2p.Peer{}
+		}
+		byOffset[offset][addr] = peer
+	}
+
+	// Every peer answers once it kept or refused its replica.
+	req := fs.openRequest(requestID, len(peers))
+	defer fs.closeRequest(requestID)
+
+	log.Info("Broadcasting to other Peers")
+	token := fs.issue(requestID, meta.Key, auth.OpStore)
+	addrs := []string{}
+	for offset, peers := range byOffset {
+		msg := Message{
+			// Payload if of message store file hinting remote server to store the data.
+			Payload: MessageStoreFile{
+				RequestID: requestID,
+				ID:        fs.ID,
+				Key:       meta.Key,
+				// Hashed key will be stored on network as we don't want the other server to guess about the file by its name.
+				// Specify the data size. (important)
+				Size:        meta.Size,
+				ModifiedAt:  meta.ModifiedAt,
+				KeyID:       meta.KeyID,
+				WrappedKey:  meta.WrappedKey,
+				PlainDigest: meta.PlainDigest,
+				VersionID:   meta.VersionID,
+				Clock:       meta.Clock,
+				Timestamp:   meta.Timestamp,
+				Signature:   meta.Signature,
+				Offset:      offset,
+				StreamID:    newRequestID(),
+				Token:       token,
+			},
+		}
+		// 3. BROADCAST THE FILE TO ALL KNOWN PEERS IN THE NETWORK.
+		streamed, err := fs.streamFile(ctx, log, peers, &msg)
+		if err != nil {
+			return err
+		}
+		addrs = append(addrs, streamed...)
+	}
+
+	// 4. Record where the replicas live in the metadata index. The peers
+	// which did not answer in time are assumed to have kept it.
+	refused := map[string]string{}
+	for _, r := range req.collect(len(peers)) {
+		if result := r.Payload.(MessageStoreFileResult); len(result.Error) > 0 {
+			refused[r.From] = result.Error
+		}
+	}
+	kept := []string{}
+	for _, addr := range addrs {
+		if reason, ok := refused[addr]; ok {
+			log.Errorf("Peer %s refused the replica of %s: %s", addr, meta.Key, reason)
+			continue
+		}
+		kept = append(kept, addr)
+	}
+	if err := fs.FsStore.AddReplicas(fs.ID, meta.Key, kept...); err != nil {
+		log.Errorf("Failed to record replicas of %s: %v", meta.Key, err)
+	}
+	if len(refused) > 0 {
+		return fmt.Errorf("%s is stored locally but %w by %d of %d peers", meta.Key, ErrReplicaRefused, len(refused), len(peers))
+	}
 	return nil
 }
 
-// Todo: IMplement it.
-// 1. Delete it Locally and through out the network.
-func (fs *FileServer) Delete(key string) error {
-	if err := fs.FsStore.Delete(fs.ID, key); err != nil {
-		logs.Logger.Errorf("Error Deleting Key Locally %s", key)
+// streamFile sends the message followed by the local copy of the version of
+// the file it stores to the peers and returns their addresses.
+func (fs *FileServer) streamFile(ctx context.Context, log *logrus.Entry, peers map[string]p2p.Peer, msg *Message) ([]string, error) {
+	file := msg.Payload.(MessageStoreFile)
+	// The message and the stream have to reach every peer back to back.
+	unlock := fs.lockPeers(peers)
+	defer unlock()
+
+	writers := []io.Writer{}
+	addrs := []string{}
+	for addr, peer := range peers {
+		if err := fs.writeMessage(ctx, peer, msg); err != nil {
+			return nil, err
+		}
+		writers = append(writers, peer)
+		addrs = append(addrs, addr)
+	}
+
+	// The file is streamed back from the disk rather than kept in memory.
+	_, blob, err := fs.FsStore.ReadVersion(file.ID, file.Key, file.VersionID)
+	if err != nil {
+		return nil, err
+	}
+	if rc, ok := blob.(io.Closer); ok {
+		defer rc.Close()
+	}
+	if err := skip(blob, file.Offset); err != nil {
+		return nil, err
+	}
+	_, streamSpan := startSpan(ctx, "p2p.Stream", file.Key, trace.WithAttributes(
+		attrPeer.StringSlice(addrs),
+		attrBytes.Int64(file.Size-file.Offset),
+	))
+	_, err = sendStream(io.MultiWriter(writers...), file.StreamID, nil, blob, file.Size-file.Offset)
+	endSpan(streamSpan, err)
+	if err != nil {
+		log.Errorf("Failed to stream data %v", err)
+		return nil, err
+	}
+	return addrs, nil
+}
+
+// Delete removes the file locally and throughout the network. A tombstone is
+// recorded and replicated so that peers which are offline right now drop
+// their copy once they reconnect instead of serving it again.
+func (fs *FileServer) Delete(key string) (err error) {
+	defer fs.metrics.observe("delete", time.Now(), &err)
+	if key, err = store.CleanKey(key); err != nil {
+		return err
+	}
+	ctx, span := startSpan(context.Background(), "FileServer.Delete", key)
+	defer func() { endSpan(span, err) }()
+	requestID := newRequestID()
+	log := fs.log.WithField(logs.FieldRequestID, requestID)
+	deletedAt := time.Now().UTC()
+	var versionID string
+	if meta, err := fs.FsStore.Stat(fs.ID, key); err == nil {
+		versionID = meta.VersionID
+	}
+	token := auth.IssueDelete(fs.Keystore.Signer(), fs.ID, requestID, key, versionID, deletedAt, tokenTTL)
+	if err := fs.tombstone(ctx, fs.ID, key, versionID, deletedAt, token); err != nil {
+		log.Errorf("Error Deleting Key Locally %s", key)
+		return err
 	}
 	msg := Message{
 		Payload: MessageDeleteFile{
-			ID:  fs.ID,
-			Key: key,
+			RequestID: requestID,
+			ID:        fs.ID,
+			Key:       key,
+			VersionID: versionID,
+			DeletedAt: deletedAt,
+			Token:     token,
 		},
 	}
-	logs.Logger.Infof("BroadCasting the Delete Request over the network %s", key)
-	if err := fs.BroadCast(&msg); err != nil {
-		logs.Logger.Errorf("Error Broadcasting the Delete Request: %+v", err)
+	log.Infof("BroadCasting the Delete Request over the network %s", key)
+	if err := fs.BroadCast(ctx, &msg); err != nil {
+		log.Errorf("Error Broadcasting the Delete Request: %+v", err)
 		return err
 	}
-	logs.Logger.Info("Deleting Data from Network")
+	log.Info("Deleting Data from Network")
 	return nil
 }
 
-// Broadcasting the message.
-func (fs *FileServer) BroadCast(msg *Message) error {
-	buf := new(bytes.Buffer)
-	if err := gob.NewEncoder(buf).Encode(msg); err != nil {
-		return err
+// List returns the files of the owner whose key starts with prefix, merging
+// the local metadata index with what the peers report to hold.
+func (fs *FileServer) List(prefix string) (_ []store.FileMeta, err error) {
+	ctx, span := tracer.Start(context.Background(), "FileServer.List", trace.WithAttributes(attribute.String("dfs.prefix", prefix)))
+	defer func() { endSpan(span, err) }()
+
+	local, err := fs.FsStore.List(fs.ID, prefix)
+	if err != nil {
+		return nil, err
 	}
-	for _, peer := range fs.Peers {
-		if err := peer.Send([]byte{p2p.IncomingMessage}); err != nil { // First send the incoming message after encoding.
-			logs.Logger.Error(err)
-			return err
+	files := make(map[string]*store.FileMeta, len(local))
+	for i := range local {
+		files[local[i].Key] = &local[i]
+	}
+
+	peers := fs.peers()
+	requestID := newRequestID()
+	req := fs.openRequest(requestID, len(peers))
+	defer fs.closeRequest(requestID)
+
+	msg := Message{
+		Payload: MessageListFiles{
+			RequestID: requestID,
+			ID:        fs.ID,
+			Prefix:    prefix,
+		},
+	}
+	if err := fs.BroadCast(ctx, &msg); err != nil {
+		return nil, err
+	}
+
+	for _, r := range req.collect(len(peers)) {
+		result := r.Payload.(MessageListFilesResult)
+		for _, remote := range result.Files {
+			if fs.FsStore.Tombstoned(remote.ID, remote.Key, remote.ModifiedAt) {
+				continue // A replica which did not hear about the delete yet.
+			}
+			meta, ok := files[remote.Key]
+			if !ok {
+				remote.Replicas = nil
+				meta = &remote
+				files[remote.Key] = meta
+			} else if meta.VersionID != remote.VersionID && meta.Clock.Concurrent(remote.Clock) {
+				// A version written through another node, not seen here yet.
+				meta.Siblings = appendUnique(meta.Siblings, remote.VersionID)
+			}
+			meta.Replicas = appendUnique(meta.Replicas, r.From)
 		}
-		// Then send the message to the peer.
-		if err := peer.Send(buf.Bytes()); err != nil {
-			logs.Logger.Error(err)
+	}
+
+	merged := make([]store.FileMeta, 0, len(files))
+	for _, meta := range files {
+		meta.ChunkDigests = nil
+		merged = append(merged, plainMeta(*meta))
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Key < merged[j].Key })
+	return merged, nil
+}
+
+// Broadcasting the message.
+func (fs *FileServer) BroadCast(ctx context.Context, msg *Message) error {
+	for _, peer := range fs.peers() {
+		if err := fs.send(ctx, peer, msg); err != nil {
+			fs.log.Error(err)
 			return err
 		}
 	}
@@ -235,7 +812,12 @@ func (s *FileServer) OnPeer(p p2p.Peer) error {
 	defer s.PeerLock.Unlock()
 
 	s.Peers[p.RemoteAddr().String()] = p
-	logs.Logger.Infof("connected with remote %s", p.RemoteAddr().String())
+	s.locks[p.RemoteAddr().String()] = &peerLocks{}
+	s.peerLog(p.RemoteAddr().String(), "").Info("connected with remote")
+	go s.streamLoop(p)
+	go s.sendTombstones(p)
+	go s.sendHeads(p)
+	go s.sendHeartbeat(p)
 	return nil
 }
 
@@ -243,7 +825,7 @@ func (fs *FileServer) ReadLoop() {
 	// Keeps on looping for ever unitl quit. Blockin in nature.
 	// Unless select it will again keeps on listenitng even if a channel has been hadled once.
 	defer func() {
-		logs.Logger.Info("File Server Stopped")
+		fs.log.Info("File Server Stopped")
 		fs.Transport.Close()
 	}()
 	for {
@@ -251,92 +833,295 @@ func (fs *FileServer) ReadLoop() {
 		case rpc := <-fs.Transport.Consume():
 			var m Message // This is what recived over the wire.
 			if err := gob.NewDecoder(bytes.NewReader(rpc.Payload)).Decode(&m); err != nil {
-				logs.Logger.Errorf("Decoding Error %+v", err.Error())
+				fs.peerLog(rpc.From, "").Errorf("Decoding Error %+v", err.Error())
 			}
 			if err := fs.handleMessage(rpc.From, &m); err != nil {
-				logs.Logger.Error(err)
+				fs.peerLog(rpc.From, requestIDOf(m.Payload)).Error(err)
 			}
 		case <-fs.Quitch:
-			logs.Logger.Info("User Quit Action")
+			fs.log.Info("User Quit Action")
 			return
 		}
 	}
 }
 
-func (fs *FileServer) handleMessage(from string, msg *Message) error {
+func (fs *FileServer) handleMessage(from string, msg *Message) (err error) {
+	ctx, span := tracer.Start(traceContext(msg), "handle "+messageName(msg.Payload),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrPeer.String(from)),
+	)
+	defer func() { endSpan(span, err) }()
+
 	switch v := msg.Payload.(type) {
 	case MessageStoreFile:
-		logs.Logger.Infof("Received key for Storing %+v\n from %s", v, from)
-		return fs.handleMessageStoreFile(from, &v)
+		fs.peerLog(from, v.RequestID).Infof("Received key for Storing %+v", v)
+		return fs.handleMessageStoreFile(ctx, from, &v)
 	case MessageGetFile:
-		return fs.handleMessageGetFile(from, v)
+		return fs.handleMessageGetFile(ctx, from, v)
 	case MessageDeleteFile:
-		return fs.handleMessageDeleteFile(from, v)
+		return fs.handleMessageDeleteFile(ctx, from, v)
+	case MessageRewrapFile:
+		return fs.handleMessageRewrapFile(ctx, from, v)
+	case MessageGrant:
+		return fs.handleMessageGrant(ctx, from, v)
+	case MessageTombstones:
+		return fs.handleMessageTombstones(ctx, from, v)
+	case MessageHeartbeat:
+		return fs.handleMessageHeartbeat(from, v)
+	case MessageHeads:
+		return fs.handleMessageHeads(ctx, from, v)
+	case MessageWantVersions:
+		return fs.handleMessageWantVersions(ctx, from, v)
+	case MessageListFiles:
+		return fs.handleMessageListFiles(ctx, from, v)
+	case MessageStatFile:
+		return fs.handleMessageStatFile(ctx, from, v)
+	case MessagePartialSize:
+		return fs.handleMessagePartialSize(ctx, from, v)
+	case MessageStoreFileResult:
+		fs.deliverReply(v.RequestID, from, v)
+	case MessageListFilesResult:
+		fs.deliverReply(v.RequestID, from, v)
+	case MessageStatFileResult:
+		fs.deliverReply(v.RequestID, from, v)
+	case MessagePartialSizeResult:
+		fs.deliverReply(v.RequestID, from, v)
 	}
 	return nil
 }
 
-func (fs *FileServer) handleMessageStoreFile(from string, msg *MessageStoreFile) error {
+// requestIDOf returns the request ID the sender attached to the payload.
+func requestIDOf(payload any) string {
+	switch v := payload.(type) {
+	case MessageStoreFile:
+		return v.RequestID
+	case MessageStoreFileResult:
+		return v.RequestID
+	case MessageGetFile:
+		return v.RequestID
+	case MessageDeleteFile:
+		return v.RequestID
+	case MessageRewrapFile:
+		return v.RequestID
+	case MessageGrant:
+		return v.RequestID
+	case MessageListFiles:
+		return v.RequestID
+	case MessageListFilesResult:
+		return v.RequestID
+	case MessageStatFile:
+		return v.RequestID
+	case MessageStatFileResult:
+		return v.RequestID
+	case MessagePartialSize:
+		return v.RequestID
+	case MessagePartialSizeResult:
+		return v.RequestID
+	}
+	return ""
+}
+
+func (fs *FileServer) handleMessageStoreFile(ctx context.Context, from string, msg *MessageStoreFile) error {
 	// Secuirty check.
-	peer, ok := fs.Peers[from]
+	peer, ok := fs.peer(from)
 	if !ok {
 		return fmt.Errorf("peer (%s) could not be found in the peer list", from)
 	}
-	// TODO: Add decryptor
+	stream, release, err := fs.awaitStream(peer, msg.StreamID)
+	if err != nil {
+		return err
+	}
 
 	// A limit reader is necassary as over the network
 	// when reading from the connection directly it will not send the EOF.
 	// Which results in keep waiting until EOF.
-	n, err := fs.FsStore.Write(msg.ID, msg.Key, io.LimitReader(peer, msg.Size))
+	lr := io.LimitReader(stream, msg.Size-msg.Offset)
+	log := fs.peerLog(from, msg.RequestID)
+	err = fs.storeReplica(ctx, log, msg, lr)
+	// What is left of a refused stream is skipped, the connection carries
+	// the next message right after it.
+	release()
+
+	result := MessageStoreFileResult{RequestID: msg.RequestID, Key: msg.Key}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if err := fs.send(ctx, peer, &Message{Payload: result}); err != nil {
+		log.Errorf("Failed to answer the store of %s: %v", msg.Key, err)
+	}
+	return err
+}
+
+// storeReplica writes the stream of a MessageStoreFile to disk, once the
+// owner and the quotas allow it.
+func (fs *FileServer) storeReplica(ctx context.Context, log *logrus.Entry, msg *MessageStoreFile, r io.Reader) error {
+	if err := fs.verify(msg.Token, msg.RequestID, msg.ID, auth.OpStore, msg.Key, time.Now()); err != nil {
+		return fmt.Errorf("refusing to store %s: %w", msg.Key, err)
+	}
+	if fs.FsStore.Tombstoned(msg.ID, msg.Key, msg.ModifiedAt) {
+		log.Infof("ignoring write of deleted key %s", msg.Key)
+		return nil
+	}
+	if err := fs.FsStore.CheckQuota(msg.ID, msg.Size); err != nil {
+		return fmt.Errorf("refusing to store %s: %w", msg.Key, err)
+	}
+	if err := fs.FsStore.CheckSpace(msg.Size); err != nil {
+		return fmt.Errorf("refusing to store %s: %w", msg.Key, err)
+	}
+	// The blob is only committed once it matches the digest the owner signed.
+	digest, err := signedDigest(msg.ID, msg.VersionID, msg.Signature)
+	if err != nil {
+		return fmt.Errorf("refusing to store %s: %w", msg.Key, err)
+	}
+	_, span := startSpan(ctx, "store.Write", msg.Key)
+	tmpl := store.FileMeta{
+		ID:          msg.ID,
+		Key:         msg.Key,
+		KeyID:       msg.KeyID,
+		WrappedKey:  msg.WrappedKey,
+		PlainDigest: msg.PlainDigest,
+		VersionID:   msg.VersionID,
+		Clock:       msg.Clock,
+		Timestamp:   msg.Timestamp,
+		Signature:   msg.Signature,
+		Digest:      digest,
+	}
+	if msg.Offset > 0 {
+		log.Infof("resuming %s at %d of %d bytes", msg.Key, msg.Offset, msg.Size)
+	}
+	meta, err := fs.FsStore.WriteSealedAt(tmpl, msg.Offset, r)
+	endSpan(span, err)
 	if err != nil {
-		logs.Logger.Errorf(err.Error())
 		return err
 	}
 
-	logs.Logger.Infof("[%s] written %d bytes to disk\n", fs.Transport.Addr(), n)
-	peer.CloseStream() // Will trigger the read loop again for the connection.
+	fs.metrics.bytesStored.WithLabelValues(originPeer).Add(float64(meta.Size - msg.Offset))
+	fs.metrics.replicationLag.Observe(time.Since(msg.ModifiedAt).Seconds())
+	log.Infof("written %d bytes to disk", meta.Size)
+	if latest, err := fs.FsStore.Stat(msg.ID, msg.Key); err == nil && len(latest.Siblings) > 0 {
+		log.Warnf("%s has %d concurrent versions", msg.Key, len(latest.Siblings)+1)
+	}
 	return nil
 }
 
-func (s *FileServer) handleMessageGetFile(from string, msg MessageGetFile) error {
-	peer, ok := s.Peers[from]
+func (s *FileServer) handleMessageGetFile(ctx context.Context, from string, msg MessageGetFile) error {
+	peer, ok := s.peer(from)
 	if !ok {
 		return fmt.Errorf("peer %s not in map", from)
 	}
-	if !s.FsStore.Has(msg.ID, msg.Key) {
-		return fmt.Errorf("[%s] need to serve file (%s) but it does not exist on disk", s.Transport.Addr(), msg.Key)
+	locks := s.locksOf(peer)
+	locks.write.Lock()
+	defer locks.write.Unlock()
+
+	meta, err := s.statLocal(msg.ID, msg.Key, msg.VersionID)
+	if err != nil || !s.FsStore.HasVersion(msg.ID, msg.Key, meta.VersionID) {
+		// Answer anyway with a negative size, the requester is waiting for a stream.
+		refuseStream(peer, msg.StreamID)
+		return fmt.Errorf("need to serve file (%s) but it does not exist on disk", msg.Key)
 	}
-	fmt.Printf("[%s] serving file (%s) over the network\n", s.Transport.Addr(), msg.Key)
-	fileSize, r, err := s.FsStore.Read(msg.ID, msg.Key)
+	grant, err := s.authorize(msg)
 	if err != nil {
+		refuseStream(peer, msg.StreamID)
+		return fmt.Errorf("refusing to serve file (%s) to %s: %w", msg.Key, msg.Requester, err)
+	}
+	log := s.peerLog(from, msg.RequestID)
+	log.Infof("serving file (%s) over the network", msg.Key)
+	if msg.RangeOffset != 0 || msg.RangeLength != 0 {
+		return s.serveRange(ctx, log, peer, msg, meta, grant)
+	}
+	_, span := startSpan(ctx, "store.Read", msg.Key)
+	fileSize, r, err := s.FsStore.ReadVersion(msg.ID, msg.Key, meta.VersionID)
+	endSpan(span, err)
+	if err != nil {
+		refuseStream(peer, msg.StreamID)
 		return err
 	}
 	if rc, ok := r.(io.ReadCloser); ok {
-		fmt.Println("closing readCloser")
 		defer rc.Close()
 	}
 
-	// 1. Send the "incomingStream" byte to the peer and then
-	// 2. Send the file size as an int64.
+	// 1. Send the "incomingStream" byte and the stream header to the peer,
+	// 2. Send the file size as an int64 and its wrapped data key, along with
+	//    the grant when the file is served to another user.
 	// 3. Stream the data over the network.
-	peer.Send([]byte{p2p.IncomingStream})
-	binary.Write(peer, binary.LittleEndian, fileSize)
-	n, err := io.Copy(peer, r)
+	// A transfer of the same version which broke off resumes where it stopped.
+	var offset int64
+	if msg.ResumeVersionID == meta.VersionID && msg.Offset > 0 && msg.Offset <= fileSize {
+		if err := skip(r, msg.Offset); err == nil {
+			offset = msg.Offset
+		}
+	}
+	_, span = startSpan(ctx, "p2p.Stream", msg.Key, trace.WithAttributes(attrPeer.String(from), attrBytes.Int64(fileSize-offset)))
+	head := bytes.NewBuffer(sizeHead(fileSize))
+	writeEnvelope(head, envelope{
+		keyID:      meta.KeyID,
+		wrappedKey: meta.WrappedKey,
+		grant:      grant,
+		versionID:  meta.VersionID,
+		clock:      meta.Clock,
+		timestamp:  meta.Timestamp,
+		signature:  meta.Signature,
+		offset:     offset,
+	})
+	n, err := sendStream(peer, msg.StreamID, head.Bytes(), r, fileSize-offset)
+	endSpan(span, err)
+	s.metrics.bytesServed.WithLabelValues(originPeer).Add(float64(n))
 	if err != nil {
 		return err
 	}
-	fmt.Printf("[%s] written (%d) bytes over the network to %s\n", s.Transport.Addr(), n, from)
+	log.Infof("written (%d) bytes over the network", n)
 	return nil
 }
 
-func (fs *FileServer) handleMessageDeleteFile(from string, msg MessageDeleteFile) error {
-	logs.Logger.Infof("Recived Delete Request from %+v", from)
+func (fs *FileServer) handleMessageDeleteFile(ctx context.Context, from string, msg MessageDeleteFile) error {
+	fs.peerLog(from, msg.RequestID).Infof("Recived Delete Request for %s", msg.Key)
 	// Security Check
-	_, ok := fs.Peers[from]
+	_, ok := fs.peer(from)
+	if !ok {
+		return fmt.Errorf("peer (%s) could not be found in the peer list", from)
+	}
+	tombstone := store.Tombstone{ID: msg.ID, Key: msg.Key, VersionID: msg.VersionID, DeletedAt: msg.DeletedAt, Token: msg.Token}
+	if err := fs.verifyDelete(tombstone, msg.RequestID); err != nil {
+		return fmt.Errorf("refusing to delete %s: %w", msg.Key, err)
+	}
+	return fs.tombstone(ctx, msg.ID, msg.Key, msg.VersionID, msg.DeletedAt, msg.Token)
+}
+
+func (fs *FileServer) handleMessageTombstones(ctx context.Context, from string, msg MessageTombstones) error {
+	if _, ok := fs.peer(from); !ok {
+		return fmt.Errorf("peer (%s) could not be found in the peer list", from)
+	}
+	for _, t := range msg.Tombstones {
+		if err := fs.verifyDelete(t, ""); err != nil {
+			fs.peerLog(from, "").Errorf("Refusing the tombstone of %s: %v", t.Key, err)
+			continue
+		}
+		if err := fs.tombstone(ctx, t.ID, t.Key, t.VersionID, t.DeletedAt, t.Token); err != nil {
+			fs.peerLog(from, "").Errorf("Error applying tombstone of %s: %v", t.Key, err)
+		}
+	}
+	return nil
+}
+
+func (fs *FileServer) handleMessageListFiles(ctx context.Context, from string, msg MessageListFiles) error {
+	peer, ok := fs.peer(from)
 	if !ok {
 		return fmt.Errorf("peer (%s) could not be found in the peer list", from)
 	}
-	return fs.FsStore.Delete(msg.ID, msg.Key)
+	files, err := fs.FsStore.List(msg.ID, msg.Prefix)
+	if err != nil {
+		return err
+	}
+	for i := range files {
+		files[i].ChunkDigests = nil // Only needed to fetch the file.
+	}
+	reply := Message{
+		Payload: MessageListFilesResult{
+			RequestID: msg.RequestID,
+			Files:     files,
+		},
+	}
+	return fs.send(ctx, peer, &reply)
 }
 
 // Non blocking
@@ -346,9 +1131,10 @@ func (fs *FileServer) bootStrapNetwork() error {
 			continue
 		}
 		go func(addr string) {
-			logs.Logger.Infof("attemting to connect with remote:%s", addr)
+			log := fs.peerLog(addr, "")
+			log.Info("attemting to connect with remote")
 			if err := fs.Transport.Dial(addr); err != nil {
-				logs.Logger.Errorf("Error BootStraping Network %v", err)
+				log.Errorf("Error BootStraping Network %v", err)
 			}
 		}(addr)
 	}
@@ -357,6 +1143,19 @@ func (fs *FileServer) bootStrapNetwork() error {
 
 func init() {
 	gob.Register(MessageStoreFile{})
+	gob.Register(MessageStoreFileResult{})
 	gob.Register(MessageGetFile{})
 	gob.Register(MessageDeleteFile{})
+	gob.Register(MessageRewrapFile{})
+	gob.Register(MessageGrant{})
+	gob.Register(MessageTombstones{})
+	gob.Register(MessageHeartbeat{})
+	gob.Register(MessageHeads{})
+	gob.Register(MessageWantVersions{})
+	gob.Register(MessageListFiles{})
+	gob.Register(MessageListFilesResult{})
+	gob.Register(MessageStatFile{})
+	gob.Register(MessageStatFileResult{})
+	gob.Register(MessagePartialSize{})
+	gob.Register(MessagePartialSizeResult{})
 }
@@ -0,0 +1,306 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/encrypt"
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/ranjankuldeep/distributed_file_system/p2p"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+	"github.com/sirupsen/logrus"
+)
+
+func TestStoreKeys(t *testing.T) {
+	fs := newTestFileServer(t)
+
+	if err := fs.Store("/docs//./a.txt", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	if !fs.FsStore.Has(fs.ID, "docs/a.txt") {
+		t.Errorf("expected the file to be stored under its clean key")
+	}
+
+	for key, want := range map[string]error{
+		"":             store.ErrInvalidKey,
+		"/../":         store.ErrInvalidKey,
+		"docs":         store.ErrIsDir,
+		"docs/a.txt/":  nil, // Cleaned into the file itself.
+		"docs/a.txt/b": store.ErrNotDir,
+	} {
+		err := fs.Store(key, strings.NewReader("b"))
+		if !errors.Is(err, want) {
+			t.Errorf("Store(%q): want %v have %v", key, want, err)
+		}
+	}
+	if err := fs.Delete("/"); !errors.Is(err, store.ErrInvalidKey) {
+		t.Errorf("Delete(/): want %v have %v", store.ErrInvalidKey, err)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "keystore.json")
+	ks, err := encrypt.CreateKeystore(path, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := FileServerOpts{
+		Keystore:          ks,
+		StorageRoot:       root,
+		PathTransformFunc: store.CASPathTransformFunc,
+		Transport:         p2p.NewTCPTransport(p2p.TCPTransportOpts{ListenAddr: ":0"}),
+	}
+	fs := NewFileServer(opts)
+	if err := fs.Store("docs/a.txt", strings.NewReader("kept")); err != nil {
+		t.Fatal(err)
+	}
+	fs.FsStore.Close()
+
+	// Started again without a name, with the keystore it had.
+	if opts.Keystore, err = encrypt.OpenKeystore(path, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewFileServer(opts)
+	defer restarted.FsStore.Close()
+	if restarted.ID != fs.ID {
+		t.Fatalf("want the owner ID %s have %s", fs.ID, restarted.ID)
+	}
+	r, err := restarted.Get("docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(r); string(b) != "kept" {
+		t.Errorf("want kept have %s", b)
+	}
+	if rc, ok := r.(io.Closer); ok {
+		rc.Close()
+	}
+}
+
+func TestList(t *testing.T) {
+	alice := startTestNode(t, FileServerOpts{ID: "alice"})
+	bob := startTestNode(t, FileServerOpts{ID: "bob"})
+	carol := startTestNode(t, FileServerOpts{ID: "carol"})
+	connect(t, alice, bob)
+	connect(t, alice, carol)
+
+	for _, key := range []string{"docs/a.txt", "docs/b.txt", "pics/c.jpg"} {
+		if err := alice.Store(key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the replicas of the peers are left of b.txt.
+	if err := alice.FsStore.Delete(alice.ID, "docs/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := alice.List("docs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Key != "docs/a.txt" || files[1].Key != "docs/b.txt" {
+		t.Fatalf("want docs/a.txt and docs/b.txt have %+v", files)
+	}
+	for _, f := range files {
+		if len(f.Replicas) != 2 {
+			t.Errorf("%s: want the replicas of both peers have %v", f.Key, f.Replicas)
+		}
+	}
+	if files, _ := bob.List("docs/"); len(files) != 0 {
+		t.Errorf("want none of the files of alice listed for bob have %+v", files)
+	}
+}
+
+func TestGetFromPeers(t *testing.T) {
+	alice := startTestNode(t, FileServerOpts{ID: "alice"})
+	bob := startTestNode(t, FileServerOpts{ID: "bob"})
+	connect(t, alice, bob)
+
+	keys := []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"}
+	for _, key := range keys {
+		if err := alice.Store(key, strings.NewReader(strings.Repeat(key, 10000))); err != nil {
+			t.Fatal(err)
+		}
+		if err := alice.FsStore.Delete(alice.ID, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The streams of the files come back over the same connection at once,
+	// each one to the get waiting for it.
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			r, err := alice.Get(key)
+			if err != nil {
+				t.Errorf("%s: %v", key, err)
+				return
+			}
+			got, err := io.ReadAll(r)
+			if rc, ok := r.(io.Closer); ok {
+				rc.Close()
+			}
+			if err != nil || string(got) != strings.Repeat(key, 10000) {
+				t.Errorf("%s: want the file stored have %d bytes and %v", key, len(got), err)
+			}
+		}(key)
+	}
+	wg.Wait()
+	for _, key := range keys {
+		if !alice.FsStore.Has(alice.ID, key) {
+			t.Errorf("%s: want the file kept once fetched", key)
+		}
+	}
+
+	if _, err := alice.Get("missing.txt"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("want %v have %v", ErrFileNotFound, err)
+	}
+	if _, err := bob.GetShared(alice.ID, "a.txt"); err == nil {
+		t.Error("want the file of alice refused to bob")
+	}
+}
+
+func TestRequestIDAcrossNodes(t *testing.T) {
+	out := &lockedBuffer{}
+	stderr, formatter := logs.Logger.Out, logs.Logger.Formatter
+	logs.Logger.SetOutput(out)
+	logs.Logger.SetFormatter(&logrus.JSONFormatter{})
+	defer func() {
+		logs.Logger.SetOutput(stderr)
+		logs.Logger.SetFormatter(formatter)
+	}()
+
+	alice := startTestNode(t, FileServerOpts{ID: "alice"})
+	bob := startTestNode(t, FileServerOpts{ID: "bob"})
+	connect(t, alice, bob)
+	if err := alice.Store("a.txt", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lines of both nodes about the store carry its request ID.
+	nodes := map[string]map[string]bool{}
+	lines := bufio.NewScanner(bytes.NewReader(out.Bytes()))
+	for lines.Scan() {
+		line := map[string]string{}
+		if json.Unmarshal(lines.Bytes(), &line) != nil || !strings.Contains(line["msg"], "a.txt") || len(line[logs.FieldRequestID]) == 0 {
+			continue
+		}
+		if nodes[line[logs.FieldRequestID]] == nil {
+			nodes[line[logs.FieldRequestID]] = map[string]bool{}
+		}
+		nodes[line[logs.FieldRequestID]][line[logs.FieldNode]] = true
+	}
+	found := false
+	for _, n := range nodes {
+		found = found || (n[alice.ID] && n[bob.ID])
+	}
+	if !found {
+		t.Errorf("want a request ID logged by both nodes have %v", nodes)
+	}
+}
+
+// lockedBuffer is a buffer the goroutines of the nodes log to at once.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
+
+func newTestFileServer(t *testing.T) *FileServer {
+	fs := NewFileServer(FileServerOpts{
+		EncKey:            make([]byte, 32),
+		StorageRoot:       t.TempDir(),
+		PathTransformFunc: store.CASPathTransformFunc,
+		Transport:         p2p.NewTCPTransport(p2p.TCPTransportOpts{ListenAddr: ":0"}),
+	})
+	t.Cleanup(func() { fs.FsStore.Close() })
+	return fs
+}
+
+// startTestNode starts a file server on a free port of the loopback
+// interface, the options left empty are the ones of newTestFileServer with a
+// key of its own.
+func startTestNode(t *testing.T, opts FileServerOpts) *FileServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	transport := p2p.NewTCPTransport(p2p.TCPTransportOpts{
+		ListenAddr:    addr,
+		HandshakeFunc: p2p.NOPHandshakeFunc,
+		Decoder:       p2p.DefaultDecoder{},
+	})
+	opts.Transport = transport
+	opts.PathTransformFunc = store.CASPathTransformFunc
+	if opts.Keystore == nil && opts.EncKey == nil {
+		opts.EncKey = encrypt.NewEncryptionKey()
+	}
+	if len(opts.StorageRoot) == 0 {
+		opts.StorageRoot = t.TempDir()
+	}
+	fs := NewFileServer(opts)
+	transport.OnPeer = fs.OnPeer
+	go fs.StartServer()
+	t.Cleanup(func() { fs.StopServer() })
+	return fs
+}
+
+// connect dials b from a and waits until they both know each other.
+func connect(t *testing.T, a *FileServer, b *FileServer) {
+	t.Helper()
+	aPeers, bPeers := len(a.peers()), len(b.peers())
+	waitFor(t, "the node to listen", func() bool { return a.Transport.Dial(b.Transport.Addr()) == nil })
+	waitFor(t, "the nodes to connect", func() bool { return len(a.peers()) > aPeers && len(b.peers()) > bPeers })
+}
+
+// waitFor polls cond until it holds, the test fails when it does not in a
+// few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// readAll reads the file the reader returned by a get, and closes it.
+func readAll(t *testing.T, r io.Reader) string {
+	t.Helper()
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,116 @@
+package fileserver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/auth"
+	"github.com/ranjankuldeep/distributed_file_system/encrypt"
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+// PublicKey returns the hex encoded X25519 public key other users share
+// their files with this user for.
+func (fs *FileServer) PublicKey() string {
+	return encrypt.PublicKeyString(fs.Keystore.Identity().PublicKey())
+}
+
+// Share gives the user holding the private key of grantee access to the
+// file for ttl. Its data key is wrapped for grantee and the grant, signed by
+// the owner, sent to the peers which serve the file to that user from then
+// on.
+func (fs *FileServer) Share(key string, grantee string, ttl time.Duration) (err error) {
+	defer fs.metrics.observe("share", time.Now(), &err)
+	ctx, span := startSpan(context.Background(), "FileServer.Share", key)
+	defer func() { endSpan(span, err) }()
+
+	if _, err := encrypt.ParsePublicKey(grantee); err != nil {
+		return err
+	}
+	meta, err := fs.FsStore.Stat(fs.ID, key)
+	if err != nil || len(meta.WrappedKey) == 0 {
+		// Not held locally, or stored before the files had keys of their own.
+		if err := fs.reseal(key); err != nil {
+			return err
+		}
+		if meta, err = fs.FsStore.Stat(fs.ID, key); err != nil {
+			return err
+		}
+	}
+	dataKey, err := fs.unwrap(meta)
+	if err != nil {
+		return err
+	}
+	return fs.grant(ctx, key, grantee, dataKey, ttl)
+}
+
+// regrant shares the file again with the users it was shared with, after
+// it was stored with a new data key. The grants keep their expiry.
+func (fs *FileServer) regrant(ctx context.Context, key string, dataKey []byte) error {
+	grants, err := fs.FsStore.Grants(fs.ID, key)
+	if err != nil {
+		return err
+	}
+	for _, g := range grants {
+		if g.Token == nil || time.Now().After(g.Token.Expiry) {
+			continue
+		}
+		if err := fs.grant(ctx, key, g.Grantee, dataKey, time.Until(g.Token.Expiry)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grant wraps the data key of the file for grantee, records the grant and
+// publishes it to the peers.
+func (fs *FileServer) grant(ctx context.Context, key string, grantee string, dataKey []byte, ttl time.Duration) error {
+	pub, err := encrypt.ParsePublicKey(grantee)
+	if err != nil {
+		return err
+	}
+	ephemeralKey, wrappedKey, err := encrypt.WrapKeyFor(pub, dataKey)
+	if err != nil {
+		return err
+	}
+	g := store.Grant{
+		ID:           fs.ID,
+		Key:          key,
+		Grantee:      grantee,
+		EphemeralKey: ephemeralKey,
+		WrappedKey:   wrappedKey,
+		CreatedAt:    time.Now().UTC(),
+		Token:        auth.Issue(fs.Keystore.Signer(), fs.ID, grantee, "", key, ttl, auth.OpGet),
+	}
+	if err := fs.FsStore.PutGrant(g); err != nil {
+		return err
+	}
+	requestID := newRequestID()
+	fs.log.WithField(logs.FieldRequestID, requestID).Infof("sharing %s with %s", key, grantee)
+	msg := Message{
+		Payload: MessageGrant{
+			RequestID: requestID,
+			Grant:     g,
+		},
+	}
+	return fs.BroadCast(ctx, &msg)
+}
+
+func (fs *FileServer) handleMessageGrant(ctx context.Context, from string, msg MessageGrant) error {
+	if fs.FsStore.Tombstoned(msg.Grant.ID, msg.Grant.Key, msg.Grant.CreatedAt) {
+		return nil
+	}
+	if err := fs.verifyGrant(&msg.Grant); err != nil {
+		return fmt.Errorf("refusing the grant of %s: %w", msg.Grant.Key, err)
+	}
+	_, span := startSpan(ctx, "store.PutGrant", msg.Grant.Key)
+	err := fs.FsStore.PutGrant(msg.Grant)
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("recording the grant of %s: %w", msg.Grant.Key, err)
+	}
+	fs.peerLog(from, msg.RequestID).Infof("%s was shared with %s", msg.Grant.Key, msg.Grant.Grantee)
+	return nil
+}
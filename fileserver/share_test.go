@@ -0,0 +1,43 @@
+package fileserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShare(t *testing.T) {
+	alice := startTestNode(t, FileServerOpts{ID: "alice"})
+	bob := startTestNode(t, FileServerOpts{ID: "bob"})
+	dave := startTestNode(t, FileServerOpts{ID: "dave"})
+	carol := startTestNode(t, FileServerOpts{ID: "carol"})
+	connect(t, alice, bob)
+	connect(t, dave, bob)
+	connect(t, carol, bob)
+
+	if err := alice.Store("a.txt", strings.NewReader("shared")); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Share("a.txt", dave.PublicKey(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the grant to reach bob", func() bool {
+		g, err := bob.FsStore.Grant(alice.ID, "a.txt", dave.PublicKey())
+		return err == nil && g != nil
+	})
+
+	// Bob serves the replica he holds to dave, who alone can open it.
+	r, err := dave.GetShared(alice.ID, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := readAll(t, r); data != "shared" {
+		t.Errorf("want shared have %s", data)
+	}
+	if _, err := carol.GetShared(alice.ID, "a.txt"); err == nil {
+		t.Error("want the file refused to the user it was not shared with")
+	}
+	if err := alice.Share("a.txt", "not a key", time.Hour); err == nil {
+		t.Error("want an error sharing with an invalid public key")
+	}
+}
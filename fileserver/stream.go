@@ -0,0 +1,135 @@
+package fileserver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/p2p"
+)
+
+// Every stream starts with the ID the request it belongs to gave it and its
+// length, so that a stream arriving after its reader gave up is skipped
+// rather than read as the answer to the next request.
+
+// How long a stream nobody asked for waits for its reader, the handler of
+// the message it follows may be busy with the ones before it.
+const streamClaimTimeout = time.Minute
+
+// streamSlot hands a stream of a peer over from streamLoop to its reader.
+type streamSlot struct {
+	ch   chan io.Reader
+	done chan struct{} // Closed by the reader once done with the stream.
+	gone chan struct{} // Closed when the reader gave up waiting.
+}
+
+func streamKey(peer p2p.Peer, streamID string) string {
+	return peer.RemoteAddr().String() + " " + streamID
+}
+
+// slot returns the slot of the stream, made by whichever of streamLoop and
+// the reader gets to it first.
+func (fs *FileServer) slot(key string) *streamSlot {
+	fs.streamsLock.Lock()
+	defer fs.streamsLock.Unlock()
+
+	s, ok := fs.streams[key]
+	if !ok {
+		s = &streamSlot{ch: make(chan io.Reader), done: make(chan struct{}), gone: make(chan struct{})}
+		fs.streams[key] = s
+	}
+	return s
+}
+
+func (fs *FileServer) dropSlot(key string, s *streamSlot) {
+	fs.streamsLock.Lock()
+	defer fs.streamsLock.Unlock()
+
+	if fs.streams[key] == s {
+		delete(fs.streams, key)
+	}
+}
+
+// awaitStream waits for the stream streamID of the peer and returns a reader
+// limited to it. release must be called once done with it, what is left of
+// the stream is skipped.
+func (fs *FileServer) awaitStream(peer p2p.Peer, streamID string) (_ io.Reader, release func(), err error) {
+	key := streamKey(peer, streamID)
+	s := fs.slot(key)
+	select {
+	case r := <-s.ch:
+		return r, func() { close(s.done) }, nil
+	case <-time.After(requestTimeout):
+		close(s.gone)
+		// Kept for a while so that the stream is skipped as soon as it comes.
+		time.AfterFunc(streamClaimTimeout, func() { fs.dropSlot(key, s) })
+		return nil, nil, fmt.Errorf("peer (%s) did not start streaming", peer.RemoteAddr())
+	}
+}
+
+// streamLoop reads the streams of the peer as the transport hands the
+// connection over, passes each one to the reader waiting for it and skips
+// what the reader left, until the peer disconnects.
+func (fs *FileServer) streamLoop(peer p2p.Peer) {
+	for range peer.IncomingStream() {
+		streamID, err := readShortBytes(peer)
+		var n int64
+		if err == nil {
+			err = binary.Read(peer, binary.LittleEndian, &n)
+		}
+		if err != nil {
+			fs.peerLog(peer.RemoteAddr().String(), "").Errorf("Unreadable stream header: %v", err)
+			peer.CloseStream()
+			continue
+		}
+
+		key := streamKey(peer, string(streamID))
+		s := fs.slot(key)
+		lr := io.LimitReader(peer, n)
+		select {
+		case s.ch <- lr:
+			<-s.done
+		case <-s.gone:
+			fs.peerLog(peer.RemoteAddr().String(), "").Warnf("Skipping stream %s, its reader gave up", streamID)
+		case <-time.After(streamClaimTimeout):
+			fs.peerLog(peer.RemoteAddr().String(), "").Warnf("Skipping stream %s, nobody asked for it", streamID)
+		}
+		fs.dropSlot(key, s)
+		io.Copy(io.Discard, lr) // Keep the connection in sync for the next message.
+		peer.CloseStream()      // Will trigger the read loop again for the connection.
+	}
+}
+
+// sendStream writes a stream to w: the IncomingStream byte, the ID and the
+// length of the stream, then head followed by n bytes of body.
+func sendStream(w io.Writer, streamID string, head []byte, body io.Reader, n int64) (int64, error) {
+	if _, err := w.Write([]byte{p2p.IncomingStream}); err != nil {
+		return 0, err
+	}
+	if err := writeShortBytes(w, []byte(streamID)); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(w, binary.LittleEndian, int64(len(head))+n); err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(head); err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return io.CopyN(w, body, n)
+}
+
+// refuseStream answers a request for a file with a negative size, the
+// requester is waiting for a stream.
+func refuseStream(w io.Writer, streamID string) error {
+	_, err := sendStream(w, streamID, sizeHead(-1), nil, 0)
+	return err
+}
+
+// sizeHead returns the size a file stream starts with.
+func sizeHead(size int64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, uint64(size))
+}
@@ -0,0 +1,183 @@
+package fileserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/encrypt"
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/ranjankuldeep/distributed_file_system/p2p"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+	"github.com/sirupsen/logrus"
+)
+
+// A version held by several peers is fetched from all of them at once, a
+// chunk of store.ChunkSize bytes from each at a time. Every chunk is checked
+// against its digest before it is written, a peer failing to serve one is
+// dropped and the chunk goes to the others. The whole blob is then checked
+// against the digest its owner signed.
+
+// How long the peers have to tell whether they hold a version, the ones
+// answering later are left out of the swarm rather than holding up the
+// fetch.
+const locateTimeout = 250 * time.Millisecond
+
+// locate asks the peers for the metadata of the version of the file in
+// getFile and returns the newest version they hold, along with the peers
+// holding that very blob.
+func (fs *FileServer) locate(ctx context.Context, getFile MessageGetFile) (*store.FileMeta, map[string]p2p.Peer) {
+	peers := fs.peers()
+	requestID := newRequestID()
+	req := fs.openRequest(requestID, len(peers))
+	defer fs.closeRequest(requestID)
+
+	msg := Message{
+		Payload: MessageStatFile{
+			RequestID: requestID,
+			ID:        getFile.ID,
+			Key:       getFile.Key,
+			VersionID: getFile.VersionID,
+		},
+	}
+	if err := fs.BroadCast(ctx, &msg); err != nil {
+		return nil, nil
+	}
+
+	// The replicas of a version are the same blob, one with another digest
+	// is not counted along with them.
+	type holders struct {
+		meta  *store.FileMeta
+		peers map[string]p2p.Peer
+	}
+	blobs := map[string]*holders{}
+	var newest *holders
+	for _, r := range req.collectWithin(len(peers), locateTimeout) {
+		meta := r.Payload.(MessageStatFileResult).Meta
+		peer, ok := peers[r.From]
+		if meta == nil || !ok || fs.FsStore.Tombstoned(getFile.ID, getFile.Key, meta.ModifiedAt) {
+			continue
+		}
+		h, ok := blobs[meta.VersionID+meta.Digest]
+		if !ok {
+			h = &holders{meta: meta, peers: map[string]p2p.Peer{}}
+			blobs[meta.VersionID+meta.Digest] = h
+		}
+		h.peers[r.From] = peer
+		if newest == nil || newest.meta.Timestamp.Before(meta.Timestamp) ||
+			(newest.meta.VersionID == meta.VersionID && len(newest.peers) < len(h.peers)) {
+			newest = h
+		}
+	}
+	if newest == nil {
+		return nil, nil
+	}
+	return newest.meta, newest.peers
+}
+
+// fetchChunks writes the version meta to the local store, as a candidate for
+// the latest version with latest, fetching its chunks from the peers at once.
+// The chunks received are kept when it fails, a later fetch resumes them.
+func (fs *FileServer) fetchChunks(ctx context.Context, log *logrus.Entry, getFile MessageGetFile, meta *store.FileMeta, peers map[string]p2p.Peer, latest bool) error {
+	// The digest comes from the peers, the owner has to vouch for it.
+	if err := meta.Signature.Verify(getFile.ID, meta.VersionID, meta.Digest); err != nil {
+		return err
+	}
+	// Asked by version ID, the peers serve the same blob whatever they write
+	// in the meantime.
+	getFile.VersionID = meta.VersionID
+	tmpl := *meta
+	tmpl.ID, tmpl.Key = getFile.ID, getFile.Key
+	written, err := fs.FsStore.WriteChunks(tmpl, latest, func(w io.WriterAt, missing []int) error {
+		log.Infof("fetching %d chunks of (%s) from %d peers", len(missing), getFile.Key, len(peers))
+		todo := make(chan int, len(missing))
+		for _, i := range missing {
+			todo <- i
+		}
+		var left atomic.Int64
+		left.Store(int64(len(missing)))
+		if len(missing) == 0 {
+			return nil
+		}
+
+		var wg sync.WaitGroup
+		for addr, peer := range peers {
+			wg.Add(1)
+			go func(addr string, peer p2p.Peer) {
+				defer wg.Done()
+				buf := make([]byte, store.ChunkSize)
+				served := 0
+				for i := range todo {
+					off, n := store.ChunkRange(meta.Size, i)
+					err := fs.fetchChunk(ctx, peer, getFile, off, buf[:n])
+					if err == nil {
+						_, err = w.WriteAt(buf[:n], off)
+					}
+					if err != nil {
+						// The chunk is left to the other peers.
+						log.WithField(logs.FieldPeer, addr).Errorf("Dropping the peer after chunk %d of (%s): %v", i, getFile.Key, err)
+						todo <- i
+						return
+					}
+					served++
+					if left.Add(-1) == 0 {
+						close(todo)
+					}
+				}
+				log.WithField(logs.FieldPeer, addr).Infof("received %d chunks of (%s)", served, getFile.Key)
+			}(addr, peer)
+		}
+		wg.Wait()
+		if n := left.Load(); n > 0 {
+			return fmt.Errorf("%d chunks of %s were not served", n, getFile.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fs.metrics.bytesStored.WithLabelValues(originPeer).Add(float64(written.Size))
+	return nil
+}
+
+// fetchChunk reads the bytes of the blob at off from a single peer into buf.
+func (fs *FileServer) fetchChunk(ctx context.Context, peer p2p.Peer, getFile MessageGetFile, off int64, buf []byte) error {
+	// The slices are asked for in plain text offsets, the peer sends the IV
+	// of the blob first: it is the start of the first chunk.
+	n := int64(len(buf))
+	getFile.RangeOffset, getFile.RangeLength = off-encrypt.Overhead, n
+	if off == 0 {
+		getFile.RangeOffset, getFile.RangeLength = 0, n-encrypt.Overhead
+	}
+	got, err := fs.streamRange(ctx, peer, getFile, func(env envelope, r io.Reader) (int64, error) {
+		if env.versionID != getFile.VersionID || env.offset != getFile.RangeOffset+encrypt.Overhead || env.length != getFile.RangeLength {
+			return 0, fmt.Errorf("peer streamed %d+%d of version %s", env.offset, env.length, env.versionID)
+		}
+		if off > 0 {
+			if _, err := io.CopyN(io.Discard, r, encrypt.Overhead); err != nil {
+				return 0, err
+			}
+		}
+		m, err := io.ReadFull(r, buf)
+		return int64(m), err
+	})
+	if err == nil && got < 0 {
+		err = fmt.Errorf("%w: %s", ErrFileNotFound, getFile.Key)
+	}
+	return err
+}
+
+func (fs *FileServer) handleMessageStatFile(ctx context.Context, from string, msg MessageStatFile) error {
+	peer, ok := fs.peer(from)
+	if !ok {
+		return fmt.Errorf("peer (%s) could not be found in the peer list", from)
+	}
+	result := MessageStatFileResult{RequestID: msg.RequestID}
+	if meta, err := fs.statLocal(msg.ID, msg.Key, msg.VersionID); err == nil && fs.FsStore.HasVersion(msg.ID, msg.Key, meta.VersionID) {
+		result.Meta = meta
+	}
+	return fs.send(ctx, peer, &Message{Payload: result})
+}
@@ -0,0 +1,58 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+func TestSwarmGet(t *testing.T) {
+	alice := startTestNode(t, FileServerOpts{ID: "alice"})
+	bob := startTestNode(t, FileServerOpts{ID: "bob"})
+	carol := startTestNode(t, FileServerOpts{ID: "carol"})
+	connect(t, alice, bob)
+	connect(t, alice, carol)
+
+	// A little more than two chunks, each one different.
+	data := make([]byte, 2*store.ChunkSize+12345)
+	for i := 0; i+8 <= len(data); i += 8 {
+		binary.LittleEndian.PutUint64(data[i:], uint64(i))
+	}
+	if err := alice.Store("big.bin", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := alice.FsStore.Stat(alice.ID, "big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.FsStore.Delete(alice.ID, "big.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, peers := alice.locate(context.Background(), MessageGetFile{ID: alice.ID, Key: "big.bin"})
+	if meta == nil || meta.VersionID != stored.VersionID || len(peers) != 2 {
+		t.Fatalf("want version %s held by bob and carol have %+v on %d peers", stored.VersionID, meta, len(peers))
+	}
+	if len(meta.ChunkDigests) != 3 {
+		t.Errorf("want the digests of 3 chunks have %d", len(meta.ChunkDigests))
+	}
+
+	r, err := alice.Get("big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if rc, ok := r.(io.Closer); ok {
+		rc.Close()
+	}
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("want the %d bytes stored have %d and %v", len(data), len(got), err)
+	}
+	if meta, err := alice.FsStore.Stat(alice.ID, "big.bin"); err != nil || meta.Digest != stored.Digest {
+		t.Errorf("want the blob put back together kept have %+v and %v", meta, err)
+	}
+}
@@ -0,0 +1,59 @@
+package fileserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/p2p"
+)
+
+const (
+	defaultTombstoneGracePeriod = 7 * 24 * time.Hour
+	tombstoneGCInterval         = time.Hour
+)
+
+// sendTombstones hands all the known tombstones to a newly connected peer.
+func (fs *FileServer) sendTombstones(peer p2p.Peer) {
+	tombstones, err := fs.FsStore.Tombstones()
+	if err != nil {
+		fs.log.Errorf("Error reading tombstones: %v", err)
+		return
+	}
+	if len(tombstones) == 0 {
+		return
+	}
+	msg := Message{
+		Payload: MessageTombstones{Tombstones: tombstones},
+	}
+	if err := fs.send(context.Background(), peer, &msg); err != nil {
+		fs.peerLog(peer.RemoteAddr().String(), "").Errorf("Error sending tombstones: %v", err)
+	}
+}
+
+// tombstoneGCLoop purges the tombstones older than the grace period, and the
+// versions partly received which were left for too long, until the server
+// quits.
+func (fs *FileServer) tombstoneGCLoop() {
+	ticker := time.NewTicker(tombstoneGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n, err := fs.FsStore.PurgeTombstones(time.Now().Add(-fs.TombstoneGracePeriod))
+			if err != nil {
+				fs.log.Errorf("Error purging tombstones: %v", err)
+				continue
+			}
+			if n > 0 {
+				fs.log.Infof("purged %d tombstones", n)
+			}
+			if n, err := fs.FsStore.PurgePartials(time.Now().Add(-partialMaxAge)); err != nil {
+				fs.log.Errorf("Error purging partial blobs: %v", err)
+			} else if n > 0 {
+				fs.log.Infof("purged %d partial blobs", n)
+			}
+		case <-fs.Quitch:
+			return
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package fileserver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+func TestDeletePropagation(t *testing.T) {
+	alice := startTestNode(t, FileServerOpts{ID: "alice"})
+	bob := startTestNode(t, FileServerOpts{ID: "bob"})
+	connect(t, alice, bob)
+
+	for _, key := range []string{"a.txt", "b.txt"} {
+		if err := alice.Store(key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := alice.Delete("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the delete to reach bob", func() bool {
+		return !bob.FsStore.Has(alice.ID, "a.txt") && bob.FsStore.Tombstoned(alice.ID, "a.txt", time.Time{})
+	})
+
+	// A node which missed the delete learns about it when it connects.
+	carol := startTestNode(t, FileServerOpts{ID: "carol"})
+	connect(t, bob, carol)
+	waitFor(t, "the tombstone to reach carol", func() bool {
+		return carol.FsStore.Tombstoned(alice.ID, "a.txt", time.Time{})
+	})
+
+	// The token of the delete does not allow another one.
+	tombstones, err := bob.FsStore.Tombstones()
+	if err != nil || len(tombstones) != 1 {
+		t.Fatalf("want the tombstone of a.txt have %v and %v", tombstones, err)
+	}
+	moved, redated := tombstones[0], tombstones[0]
+	moved.Key = "b.txt"
+	redated.DeletedAt = redated.DeletedAt.Add(time.Second)
+	var from string
+	for addr := range bob.peers() {
+		from = addr
+	}
+	if err := bob.handleMessageTombstones(context.Background(), from, MessageTombstones{Tombstones: []store.Tombstone{moved, redated}}); err != nil {
+		t.Fatal(err)
+	}
+	if !bob.FsStore.Has(alice.ID, "b.txt") || bob.FsStore.Tombstoned(alice.ID, "b.txt", time.Time{}) {
+		t.Error("want the tombstone moved to another key refused")
+	}
+	if bob.FsStore.Tombstoned(alice.ID, "a.txt", tombstones[0].DeletedAt) {
+		t.Error("want the redated tombstone refused")
+	}
+}
@@ -0,0 +1,67 @@
+package fileserver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/auth"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Spans go nowhere until a tracer provider is installed, see tracing.Setup.
+var tracer = otel.Tracer("github.com/ranjankuldeep/distributed_file_system/fileserver")
+
+// Attribute keys of the spans.
+const (
+	attrKey      = attribute.Key("dfs.key")
+	attrPeer     = attribute.Key("dfs.peer")
+	attrServedBy = attribute.Key("dfs.served_by")
+	attrBytes    = attribute.Key("dfs.bytes")
+)
+
+// startSpan starts a span about the file with the given key.
+func startSpan(ctx context.Context, name string, key string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	opts = append(opts, trace.WithAttributes(attrKey.String(key)))
+	return tracer.Start(ctx, name, opts...)
+}
+
+// endSpan records err, if any, on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// withTraceContext returns a copy of the message carrying the span context
+// of ctx, so the peer handling it continues the same trace.
+func withTraceContext(ctx context.Context, msg *Message) *Message {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	traced := *msg
+	traced.TraceContext = carrier
+	return &traced
+}
+
+// traceContext returns the context of the span the sender was in.
+func traceContext(msg *Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(msg.TraceContext))
+}
+
+func messageName(payload any) string {
+	return fmt.Sprintf("%T", payload)
+}
+
+// tombstone records the tombstone in the store within a span.
+func (fs *FileServer) tombstone(ctx context.Context, id string, key string, versionID string, deletedAt time.Time, token *auth.Token) error {
+	_, span := startSpan(ctx, "store.Tombstone", key)
+	err := fs.FsStore.Tombstone(id, key, versionID, deletedAt, token)
+	endSpan(span, err)
+	return err
+}
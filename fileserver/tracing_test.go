@@ -0,0 +1,44 @@
+package fileserver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTraceAcrossNodes(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer provider.Shutdown(context.Background())
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	alice := startTestNode(t, FileServerOpts{ID: "alice"})
+	bob := startTestNode(t, FileServerOpts{ID: "bob"})
+	connect(t, alice, bob)
+	if err := alice.Store("a.txt", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The handling of the replica by bob joins the trace of the store.
+	spanOf := func(name string) sdktrace.ReadOnlySpan {
+		for _, span := range recorder.Ended() {
+			if span.Name() == name {
+				return span
+			}
+		}
+		return nil
+	}
+	waitFor(t, "the spans to end", func() bool {
+		return spanOf("FileServer.Store") != nil && spanOf("handle fileserver.MessageStoreFile") != nil
+	})
+	store, handle := spanOf("FileServer.Store"), spanOf("handle fileserver.MessageStoreFile")
+	if handle.SpanContext().TraceID() != store.SpanContext().TraceID() || !handle.Parent().IsRemote() {
+		t.Errorf("want the span of bob in trace %s under a remote parent have trace %s", store.SpanContext().TraceID(), handle.SpanContext().TraceID())
+	}
+}
@@ -0,0 +1,47 @@
+package fileserver
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+// GetVersion returns a version of a file of the owner, fetched from the
+// peers when this node does not hold it.
+func (fs *FileServer) GetVersion(key string, versionID string) (io.Reader, error) {
+	if len(versionID) == 0 {
+		return fs.Get(key)
+	}
+	return fs.get(fs.ID, key, versionID)
+}
+
+// Versions returns the versions of a file of the owner, oldest first, with
+// the sizes of the plain text. The node of the owner writes every version
+// first, so its own index knows them all.
+func (fs *FileServer) Versions(key string) ([]store.FileMeta, error) {
+	versions, err := fs.FsStore.Versions(fs.ID, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+	for i := range versions {
+		versions[i] = plainMeta(versions[i])
+	}
+	return versions, nil
+}
+
+// Restore makes a version of the file the latest one again. It is stored as
+// a new version, so the versions written since are kept as well.
+func (fs *FileServer) Restore(key string, versionID string) error {
+	r, err := fs.GetVersion(key, versionID)
+	if err != nil {
+		return err
+	}
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+	return fs.Store(key, r)
+}
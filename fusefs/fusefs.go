@@ -0,0 +1,206 @@
+//go:build linux || darwin
+
+package fusefs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+// How long listings and attributes are trusted before asking the node again.
+const listingTTL = time.Second
+
+// Client is what the mount needs from a running node, *control.Client
+// satisfies it.
+type Client interface {
+	List(prefix string) ([]store.FileMeta, error)
+	Get(key string) (int64, io.ReadCloser, error)
+	Store(key string, path string) error
+	Delete(key string) error
+}
+
+// FS exposes the files of the node owner as a directory tree, keys are split
+// on "/" into directories. Files are downloaded whole into the cache
+// directory on open, named by their digest so they are reused until they
+// change, and written back to the node when a modified file is flushed.
+type FS struct {
+	client   Client
+	cacheDir string
+
+	mu       sync.Mutex
+	files    map[string]store.FileMeta
+	dirs     map[string]bool // Directories created with mkdir and still empty.
+	listedAt time.Time
+}
+
+func New(client Client, cacheDir string) (*FS, error) {
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return nil, err
+	}
+	return &FS{
+		client:   client,
+		cacheDir: cacheDir,
+		files:    make(map[string]store.FileMeta),
+		dirs:     make(map[string]bool),
+	}, nil
+}
+
+// Mount serves the file system on dir until it is unmounted.
+func Mount(dir string, fsys *FS) (*fuse.Server, error) {
+	ttl := listingTTL
+	return fs.Mount(dir, &dirNode{fsys: fsys}, &fs.Options{
+		EntryTimeout: &ttl,
+		AttrTimeout:  &ttl,
+		MountOptions: fuse.MountOptions{
+			FsName: "dfs",
+			Name:   "dfs",
+			// Mount directly when privileged, fusermount is used otherwise.
+			DirectMount: true,
+		},
+	})
+}
+
+// refresh reloads the listing of the node once it is older than listingTTL.
+func (f *FS) refresh(force bool) error {
+	f.mu.Lock()
+	fresh := !force && time.Since(f.listedAt) < listingTTL
+	f.mu.Unlock()
+	if fresh {
+		return nil
+	}
+
+	metas, err := f.client.List("")
+	if err != nil {
+		return err
+	}
+	files := make(map[string]store.FileMeta, len(metas))
+	for _, meta := range metas {
+		files[meta.Key] = meta
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Files created but not flushed yet are only known locally.
+	for key, meta := range f.files {
+		if _, ok := files[key]; !ok && len(meta.Digest) == 0 {
+			files[key] = meta
+		}
+	}
+	f.files = files
+	f.listedAt = time.Now()
+	return nil
+}
+
+type entry struct {
+	name  string
+	isDir bool
+	meta  store.FileMeta
+}
+
+// children lists the entries right under dir, "" being the root.
+func (f *FS) children(dir string) map[string]entry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	prefix := ""
+	if len(dir) > 0 {
+		prefix = dir + "/"
+	}
+	entries := map[string]entry{}
+	for key, meta := range f.files {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if name, _, nested := strings.Cut(rest, "/"); nested {
+			entries[name] = entry{name: name, isDir: true}
+		} else {
+			entries[rest] = entry{name: rest, meta: meta}
+		}
+	}
+	for d := range f.dirs {
+		if parent, name := splitPath(d); parent == dir {
+			if _, ok := entries[name]; !ok {
+				entries[name] = entry{name: name, isDir: true}
+			}
+		}
+	}
+	return entries
+}
+
+func (f *FS) setFile(meta store.FileMeta) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[meta.Key] = meta
+	// The directory is not empty anymore, the file keeps it alive.
+	dir, _ := splitPath(meta.Key)
+	delete(f.dirs, dir)
+}
+
+func (f *FS) removeFile(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.files, key)
+}
+
+func (f *FS) meta(key string) (store.FileMeta, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	meta, ok := f.files[key]
+	return meta, ok
+}
+
+// cached returns the path of a local copy of the file, downloading it from
+// the node when the cache has no copy of this content yet.
+func (f *FS) cached(meta store.FileMeta) (string, error) {
+	if len(meta.Digest) == 0 {
+		// Created but not flushed yet, the node does not have it.
+		return "", os.ErrNotExist
+	}
+	cachePath := filepath.Join(f.cacheDir, meta.Digest)
+	if _, err := os.Stat(cachePath); err == nil {
+		return cachePath, nil
+	}
+
+	_, r, err := f.client.Get(meta.Key)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	tmp, err := os.CreateTemp(f.cacheDir, "fetch*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed.
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return cachePath, os.Rename(tmp.Name(), cachePath)
+}
+
+func splitPath(p string) (dir string, name string) {
+	i := strings.LastIndex(p, "/")
+	if i < 0 {
+		return "", p
+	}
+	return p[:i], p[i+1:]
+}
+
+func joinPath(dir string, name string) string {
+	if len(dir) == 0 {
+		return name
+	}
+	return dir + "/" + name
+}
@@ -0,0 +1,188 @@
+//go:build linux || darwin
+
+package fusefs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+// memClient is a node holding its files in memory.
+type memClient struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	gets  int
+}
+
+func newMemClient(files map[string]string) *memClient {
+	c := &memClient{files: map[string][]byte{}}
+	for key, data := range files {
+		c.files[key] = []byte(data)
+	}
+	return c
+}
+
+// file returns the content of the file the node holds, if any.
+func (c *memClient) file(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.files[key]
+	return string(data), ok
+}
+
+func (c *memClient) List(prefix string) ([]store.FileMeta, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	metas := []store.FileMeta{}
+	for key, data := range c.files {
+		if strings.HasPrefix(key, prefix) {
+			sum := sha256.Sum256(data)
+			metas = append(metas, store.FileMeta{Key: key, Size: int64(len(data)), Digest: hex.EncodeToString(sum[:]), ModifiedAt: time.Now()})
+		}
+	}
+	return metas, nil
+}
+
+func (c *memClient) Get(key string) (int64, io.ReadCloser, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.files[key]
+	if !ok {
+		return 0, nil, os.ErrNotExist
+	}
+	c.gets++
+	return int64(len(data)), io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (c *memClient) Store(key string, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files[key] = data
+	return nil
+}
+
+func (c *memClient) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.files, key)
+	return nil
+}
+
+func TestChildren(t *testing.T) {
+	fsys, err := New(newMemClient(map[string]string{"a.txt": "a", "docs/b.txt": "b", "docs/old/c.txt": "c"}), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.refresh(true); err != nil {
+		t.Fatal(err)
+	}
+	// Created but not flushed yet, it stays across the listings.
+	fsys.setFile(store.FileMeta{Key: "docs/new.txt"})
+	fsys.dirs["empty"] = true
+	if err := fsys.refresh(true); err != nil {
+		t.Fatal(err)
+	}
+
+	for dir, want := range map[string][]string{
+		"":         {"a.txt", "docs/", "empty/"},
+		"docs":     {"b.txt", "new.txt", "old/"},
+		"docs/old": {"c.txt"},
+		"empty":    {},
+	} {
+		have := []string{}
+		for name, e := range fsys.children(dir) {
+			if e.isDir {
+				name += "/"
+			}
+			have = append(have, name)
+		}
+		sort.Strings(have)
+		if strings.Join(have, " ") != strings.Join(want, " ") {
+			t.Errorf("%q: want %v have %v", dir, want, have)
+		}
+	}
+}
+
+func TestCached(t *testing.T) {
+	client := newMemClient(map[string]string{"a.txt": "first"})
+	fsys, err := New(client, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.refresh(true); err != nil {
+		t.Fatal(err)
+	}
+	meta, _ := fsys.meta("a.txt")
+	for i := 0; i < 2; i++ {
+		p, err := fsys.cached(meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := os.ReadFile(p); string(b) != "first" {
+			t.Errorf("want first have %s", b)
+		}
+	}
+	if client.gets != 1 {
+		t.Errorf("want the copy in the cache reused have %d gets", client.gets)
+	}
+
+	// Another content is another copy.
+	client.files["a.txt"] = []byte("second")
+	if err := fsys.refresh(true); err != nil {
+		t.Fatal(err)
+	}
+	meta, _ = fsys.meta("a.txt")
+	if p, err := fsys.cached(meta); err != nil {
+		t.Fatal(err)
+	} else if b, _ := os.ReadFile(p); string(b) != "second" {
+		t.Errorf("want second have %s", b)
+	}
+	if _, err := fsys.cached(store.FileMeta{Key: "new.txt"}); !os.IsNotExist(err) {
+		t.Errorf("want a file not flushed yet missing have %v", err)
+	}
+}
+
+func TestMount(t *testing.T) {
+	client := newMemClient(map[string]string{"docs/a.txt": "hello"})
+	fsys, err := New(client, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	server, err := Mount(dir, fsys)
+	if err != nil {
+		t.Skipf("cannot mount here: %v", err)
+	}
+	defer server.Unmount()
+
+	if b, err := os.ReadFile(filepath.Join(dir, "docs", "a.txt")); err != nil || string(b) != "hello" {
+		t.Errorf("want hello have %s and %v", b, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "docs", "b.txt"), []byte("written"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := client.file("docs/b.txt"); data != "written" {
+		t.Errorf("want the file stored on the node when closed have %q", data)
+	}
+	if err := os.Remove(filepath.Join(dir, "docs", "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.file("docs/a.txt"); ok {
+		t.Error("want the file deleted on the node")
+	}
+}
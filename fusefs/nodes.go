@@ -0,0 +1,321 @@
+//go:build linux || darwin
+
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+// dirNode is a directory, path is its key prefix without the trailing slash.
+type dirNode struct {
+	fs.Inode
+	fsys *FS
+	path string
+}
+
+var (
+	_ fs.NodeLookuper  = (*dirNode)(nil)
+	_ fs.NodeReaddirer = (*dirNode)(nil)
+	_ fs.NodeCreater   = (*dirNode)(nil)
+	_ fs.NodeMkdirer   = (*dirNode)(nil)
+	_ fs.NodeUnlinker  = (*dirNode)(nil)
+	_ fs.NodeRmdirer   = (*dirNode)(nil)
+)
+
+func (d *dirNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFDIR | 0755
+	return fs.OK
+}
+
+func (d *dirNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if err := d.fsys.refresh(false); err != nil {
+		return nil, syscall.EIO
+	}
+	e, ok := d.fsys.children(d.path)[name]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	return d.newChild(ctx, e, out), fs.OK
+}
+
+func (d *dirNode) newChild(ctx context.Context, e entry, out *fuse.EntryOut) *fs.Inode {
+	key := joinPath(d.path, e.name)
+	if e.isDir {
+		out.Mode = fuse.S_IFDIR | 0755
+		return d.NewInode(ctx, &dirNode{fsys: d.fsys, path: key}, fs.StableAttr{Mode: fuse.S_IFDIR})
+	}
+	fillAttr(&out.Attr, e.meta)
+	return d.NewInode(ctx, &fileNode{fsys: d.fsys, key: key}, fs.StableAttr{Mode: fuse.S_IFREG})
+}
+
+func (d *dirNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	if err := d.fsys.refresh(false); err != nil {
+		return nil, syscall.EIO
+	}
+	entries := []fuse.DirEntry{}
+	for _, e := range d.fsys.children(d.path) {
+		mode := uint32(fuse.S_IFREG)
+		if e.isDir {
+			mode = fuse.S_IFDIR
+		}
+		entries = append(entries, fuse.DirEntry{Name: e.name, Mode: mode})
+	}
+	return fs.NewListDirStream(entries), fs.OK
+}
+
+func (d *dirNode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	key := joinPath(d.path, name)
+	meta := store.FileMeta{Key: key, ModifiedAt: time.Now()}
+	d.fsys.setFile(meta)
+
+	node := &fileNode{fsys: d.fsys, key: key}
+	fh, err := node.openWritable(meta, true)
+	if err != nil {
+		return nil, nil, 0, fs.ToErrno(err)
+	}
+	fillAttr(&out.Attr, meta)
+	return d.NewInode(ctx, node, fs.StableAttr{Mode: fuse.S_IFREG}), fh, 0, fs.OK
+}
+
+func (d *dirNode) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	key := joinPath(d.path, name)
+	if _, ok := d.fsys.children(d.path)[name]; ok {
+		return nil, syscall.EEXIST
+	}
+	d.fsys.mu.Lock()
+	d.fsys.dirs[key] = true
+	d.fsys.mu.Unlock()
+	return d.newChild(ctx, entry{name: name, isDir: true}, out), fs.OK
+}
+
+func (d *dirNode) Unlink(ctx context.Context, name string) syscall.Errno {
+	key := joinPath(d.path, name)
+	if err := d.fsys.client.Delete(key); err != nil {
+		logs.Logger.Errorf("Error deleting %s: %v", key, err)
+		return syscall.EIO
+	}
+	d.fsys.removeFile(key)
+	return fs.OK
+}
+
+func (d *dirNode) Rmdir(ctx context.Context, name string) syscall.Errno {
+	key := joinPath(d.path, name)
+	if len(d.fsys.children(key)) > 0 {
+		return syscall.ENOTEMPTY
+	}
+	d.fsys.mu.Lock()
+	delete(d.fsys.dirs, key)
+	d.fsys.mu.Unlock()
+	return fs.OK
+}
+
+// fileNode is a file, key is its full key on the node.
+type fileNode struct {
+	fs.Inode
+	fsys *FS
+	key  string
+}
+
+var (
+	_ fs.NodeOpener    = (*fileNode)(nil)
+	_ fs.NodeGetattrer = (*fileNode)(nil)
+	_ fs.NodeSetattrer = (*fileNode)(nil)
+)
+
+func (n *fileNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	if h, ok := fh.(*fileHandle); ok {
+		return h.Getattr(ctx, out)
+	}
+	meta, ok := n.fsys.meta(n.key)
+	if !ok {
+		return syscall.ENOENT
+	}
+	fillAttr(&out.Attr, meta)
+	return fs.OK
+}
+
+// Setattr only supports truncating an open file, the rest is ignored.
+func (n *fileNode) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	if size, ok := in.GetSize(); ok {
+		h, isOpen := fh.(*fileHandle)
+		if !isOpen {
+			return syscall.ENOTSUP
+		}
+		if errno := h.truncate(int64(size)); errno != fs.OK {
+			return errno
+		}
+	}
+	return n.Getattr(ctx, fh, out)
+}
+
+func (n *fileNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	meta, ok := n.fsys.meta(n.key)
+	if !ok {
+		return nil, 0, syscall.ENOENT
+	}
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		fh, err := n.openWritable(meta, flags&syscall.O_TRUNC != 0)
+		if err != nil {
+			return nil, 0, fs.ToErrno(err)
+		}
+		return fh, 0, fs.OK
+	}
+
+	cachePath, err := n.fsys.cached(meta)
+	if err != nil {
+		logs.Logger.Errorf("Error fetching %s: %v", n.key, err)
+		return nil, 0, syscall.EIO
+	}
+	f, err := os.Open(cachePath)
+	if err != nil {
+		return nil, 0, fs.ToErrno(err)
+	}
+	return &fileHandle{node: n, file: f}, 0, fs.OK
+}
+
+// openWritable returns a handle on a private working copy of the file,
+// stored back to the node when flushed.
+func (n *fileNode) openWritable(meta store.FileMeta, truncate bool) (*fileHandle, error) {
+	work, err := os.CreateTemp(n.fsys.cacheDir, "write*")
+	if err != nil {
+		return nil, err
+	}
+	if !truncate {
+		if err := copyCached(n.fsys, meta, work); err != nil {
+			work.Close()
+			os.Remove(work.Name())
+			return nil, err
+		}
+	}
+	// A truncated or new file has to reach the node even if nothing is written.
+	return &fileHandle{node: n, file: work, writable: true, dirty: truncate}, nil
+}
+
+func copyCached(fsys *FS, meta store.FileMeta, dst *os.File) error {
+	cachePath, err := fsys.cached(meta)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(cachePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+type fileHandle struct {
+	node     *fileNode
+	mu       sync.Mutex
+	file     *os.File
+	writable bool
+	dirty    bool
+}
+
+var (
+	_ fs.FileReader    = (*fileHandle)(nil)
+	_ fs.FileWriter    = (*fileHandle)(nil)
+	_ fs.FileFlusher   = (*fileHandle)(nil)
+	_ fs.FileFsyncer   = (*fileHandle)(nil)
+	_ fs.FileReleaser  = (*fileHandle)(nil)
+	_ fs.FileGetattrer = (*fileHandle)(nil)
+)
+
+func (h *fileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n, err := h.file.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		return nil, fs.ToErrno(err)
+	}
+	return fuse.ReadResultData(dest[:n]), fs.OK
+}
+
+func (h *fileHandle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.writable {
+		return 0, syscall.EBADF
+	}
+	n, err := h.file.WriteAt(data, off)
+	h.dirty = true
+	return uint32(n), fs.ToErrno(err)
+}
+
+func (h *fileHandle) truncate(size int64) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.writable {
+		return syscall.EBADF
+	}
+	h.dirty = true
+	return fs.ToErrno(h.file.Truncate(size))
+}
+
+func (h *fileHandle) Getattr(ctx context.Context, out *fuse.AttrOut) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fi, err := h.file.Stat()
+	if err != nil {
+		return fs.ToErrno(err)
+	}
+	meta, _ := h.node.fsys.meta(h.node.key)
+	meta.Size = fi.Size()
+	if h.dirty {
+		meta.ModifiedAt = fi.ModTime()
+	}
+	fillAttr(&out.Attr, meta)
+	return fs.OK
+}
+
+// Flush stores the working copy on the node when it was modified.
+func (h *fileHandle) Flush(ctx context.Context) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.dirty {
+		return fs.OK
+	}
+	if err := h.node.fsys.client.Store(h.node.key, h.file.Name()); err != nil {
+		logs.Logger.Errorf("Error storing %s: %v", h.node.key, err)
+		return syscall.EIO
+	}
+	h.dirty = false
+	// The new digest and size come with the next listing.
+	return fs.ToErrno(h.node.fsys.refresh(true))
+}
+
+func (h *fileHandle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	return h.Flush(ctx)
+}
+
+func (h *fileHandle) Release(ctx context.Context) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.file.Close()
+	if h.writable {
+		os.Remove(h.file.Name())
+	}
+	return fs.OK
+}
+
+func fillAttr(attr *fuse.Attr, meta store.FileMeta) {
+	attr.Mode = fuse.S_IFREG | 0644
+	attr.Size = uint64(meta.Size)
+	attr.Blocks = (attr.Size + 511) / 512
+	attr.SetTimes(nil, &meta.ModifiedAt, &meta.ModifiedAt)
+}
@@ -0,0 +1,197 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/ranjankuldeep/distributed_file_system/fileserver"
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+const filesPrefix = "/files/"
+
+// Gateway exposes the file server over HTTP so that applications can use
+// the cluster without the CLI:
+//
+//	PUT    /files/{key}  store the request body
+//	GET    /files/{key}  fetch the file, Range requests are supported
+//	HEAD   /files/{key}  the headers of GET, without fetching the file
+//	DELETE /files/{key}  delete the file throughout the network
+//
+// The requests carry the token of the gateway as a bearer token in their
+// Authorization header. Without a token the gateway is open to anyone who
+// can reach it, it listens on the loopback interface unless given a host.
+type Gateway struct {
+	fs     *fileserver.FileServer
+	token  string
+	server *http.Server
+}
+
+type Options struct {
+	ListenAddr string
+	Token      string
+}
+
+func New(opts Options, fs *fileserver.FileServer) *Gateway {
+	addr := opts.ListenAddr
+	if host, port, err := net.SplitHostPort(addr); err == nil && len(host) == 0 && len(opts.Token) == 0 {
+		addr = net.JoinHostPort("localhost", port)
+	}
+	g := &Gateway{fs: fs, token: opts.Token}
+	g.server = &http.Server{
+		Addr:    addr,
+		Handler: g,
+	}
+	return g
+}
+
+// ListenAndServe blocks until the gateway is closed.
+func (g *Gateway) ListenAndServe() error {
+	logs.Logger.Infof("HTTP gateway listening on %s", g.server.Addr)
+	if err := g.server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (g *Gateway) Close() error {
+	return g.server.Close()
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, filesPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+	if !g.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="dfs"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, filesPrefix)
+	if len(key) == 0 {
+		http.Error(w, "missing file key", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		g.handlePut(w, r, key)
+	case http.MethodGet:
+		g.handleGet(w, r, key)
+	case http.MethodHead:
+		g.handleHead(w, r, key)
+	case http.MethodDelete:
+		g.handleDelete(w, r, key)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (g *Gateway) handlePut(w http.ResponseWriter, r *http.Request, key string) {
+	_, err := g.fs.Stat(key)
+	existed := err == nil
+
+	// The body is streamed straight to the store.
+	err = g.fs.Store(key, r.Body)
+	switch {
+	case errors.Is(err, store.ErrInvalidKey):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, store.ErrIsDir), errors.Is(err, store.ErrNotDir):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		logs.Logger.Errorf("Error Storing %s over HTTP: %v", key, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if meta, err := g.fs.Stat(key); err == nil {
+		w.Header().Set("ETag", etag(meta.Digest))
+	}
+	if existed {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (g *Gateway) handleGet(w http.ResponseWriter, r *http.Request, key string) {
+	rd, err := g.fs.Get(key)
+	if errors.Is(err, fileserver.ErrFileNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logs.Logger.Errorf("Error Retrieving %s over HTTP: %v", key, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rc, ok := rd.(io.Closer); ok {
+		defer rc.Close()
+	}
+	meta, err := g.fs.Stat(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", etag(meta.Digest))
+
+	rs, ok := rd.(io.ReadSeeker)
+	if !ok {
+		// Without seeking there is no Range support, send it whole.
+		w.Header().Set("Content-Length", fmt.Sprint(meta.Size))
+		io.Copy(w, rd)
+		return
+	}
+	// ServeContent takes care of Range and conditional requests.
+	http.ServeContent(w, r, path.Base(key), meta.ModifiedAt, rs)
+}
+
+// handleHead answers from the metadata, the file is not fetched.
+func (g *Gateway) handleHead(w http.ResponseWriter, r *http.Request, key string) {
+	meta, err := g.fs.StatNetwork(key)
+	if errors.Is(err, fileserver.ErrFileNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", etag(meta.Digest))
+	w.Header().Set("Last-Modified", meta.ModifiedAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Length", fmt.Sprint(meta.Size))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g *Gateway) handleDelete(w http.ResponseWriter, r *http.Request, key string) {
+	if err := g.fs.Delete(key); err != nil {
+		logs.Logger.Errorf("Error Deleting %s over HTTP: %v", key, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorized reports whether the request carries the token of the gateway.
+func (g *Gateway) authorized(r *http.Request) bool {
+	if len(g.token) == 0 {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(g.token)) == 1
+}
+
+func etag(digest string) string {
+	return `"` + digest + `"`
+}
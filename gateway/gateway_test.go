@@ -0,0 +1,125 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ranjankuldeep/distributed_file_system/fileserver"
+	"github.com/ranjankuldeep/distributed_file_system/p2p"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+const testToken = "secret"
+
+func TestGateway(t *testing.T) {
+	fs := newFileServer(t)
+	ts := httptest.NewServer(New(Options{Token: testToken}, fs))
+	defer ts.Close()
+
+	data := "hello over http"
+	if res := do(t, ts, http.MethodPut, "/files/docs/a.txt", data, nil); res.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT: want status 201 have %d", res.StatusCode)
+	}
+	if !fs.FsStore.Has(fs.ID, "docs/a.txt") {
+		t.Errorf("expected the file to be stored under its key")
+	}
+	if res := do(t, ts, http.MethodPut, "/files/docs/a.txt", data, nil); res.StatusCode != http.StatusNoContent {
+		t.Errorf("PUT again: want status 204 have %d", res.StatusCode)
+	}
+	if res := do(t, ts, http.MethodPut, "/files/docs", data, nil); res.StatusCode != http.StatusConflict {
+		t.Errorf("PUT over a directory: want status 409 have %d", res.StatusCode)
+	}
+
+	res := do(t, ts, http.MethodGet, "/files/docs/a.txt", "", nil)
+	if b, _ := io.ReadAll(res.Body); string(b) != data {
+		t.Errorf("GET: want %s have %s", data, b)
+	}
+	etag := res.Header.Get("ETag")
+
+	res = do(t, ts, http.MethodHead, "/files/docs/a.txt", "", nil)
+	if res.StatusCode != http.StatusOK || res.ContentLength != int64(len(data)) || res.Header.Get("ETag") != etag {
+		t.Errorf("HEAD: unexpected status %d, length %d and etag %s", res.StatusCode, res.ContentLength, res.Header.Get("ETag"))
+	}
+	if res := do(t, ts, http.MethodHead, "/files/docs/missing.txt", "", nil); res.StatusCode != http.StatusNotFound {
+		t.Errorf("HEAD missing: want status 404 have %d", res.StatusCode)
+	}
+
+	res = do(t, ts, http.MethodGet, "/files/docs/a.txt", "", map[string]string{"Range": "bytes=6-9"})
+	if b, _ := io.ReadAll(res.Body); res.StatusCode != http.StatusPartialContent || string(b) != "over" {
+		t.Errorf("GET Range: want status 206 and over have %d and %s", res.StatusCode, b)
+	}
+
+	if res := do(t, ts, http.MethodDelete, "/files/docs/a.txt", "", nil); res.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE: want status 204 have %d", res.StatusCode)
+	}
+	if res := do(t, ts, http.MethodGet, "/files/docs/a.txt", "", nil); res.StatusCode != http.StatusNotFound {
+		t.Errorf("GET deleted: want status 404 have %d", res.StatusCode)
+	}
+}
+
+func TestGatewayToken(t *testing.T) {
+	ts := httptest.NewServer(New(Options{Token: testToken}, newFileServer(t)))
+	defer ts.Close()
+
+	for _, header := range []string{"", "Bearer wrong", testToken} {
+		r, _ := http.NewRequest(http.MethodPut, ts.URL+"/files/a.txt", strings.NewReader("a"))
+		if len(header) > 0 {
+			r.Header.Set("Authorization", header)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: want status 401 have %d", header, res.StatusCode)
+		}
+	}
+}
+
+func TestGatewayListenAddr(t *testing.T) {
+	for _, tc := range []struct {
+		opts Options
+		want string
+	}{
+		{Options{ListenAddr: ":8080"}, "localhost:8080"},
+		{Options{ListenAddr: ":8080", Token: testToken}, ":8080"},
+		{Options{ListenAddr: "10.0.0.1:8080", Token: testToken}, "10.0.0.1:8080"},
+	} {
+		if have := New(tc.opts, nil).server.Addr; have != tc.want {
+			t.Errorf("%+v: want %s have %s", tc.opts, tc.want, have)
+		}
+	}
+}
+
+func do(t *testing.T, ts *httptest.Server, method string, path string, body string, header map[string]string) *http.Response {
+	t.Helper()
+	r, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+func newFileServer(t *testing.T) *fileserver.FileServer {
+	fs := fileserver.NewFileServer(fileserver.FileServerOpts{
+		EncKey:            make([]byte, 32),
+		StorageRoot:       t.TempDir(),
+		PathTransformFunc: store.CASPathTransformFunc,
+		Transport:         p2p.NewTCPTransport(p2p.TCPTransportOpts{ListenAddr: ":0"}),
+	})
+	t.Cleanup(func() { fs.FsStore.Close() })
+	return fs
+}
@@ -15,6 +15,29 @@ import (
 // Quiet specifies whether to only print machine-readable IDs
 var Quiet bool
 
+// Names of the fields attached to the log lines, so they can be searched for
+// across nodes.
+const (
+	FieldNode      = "node"
+	FieldPeer      = "peer"
+	FieldRequestID = "request_id"
+)
+
+// Log output formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options configures the logger, an empty Format and File keep the colored
+// text on stderr.
+type Options struct {
+	Format string
+	Level  logrus.Level
+	// Path of a file the logs are appended to instead of stderr.
+	File string
+}
+
 // logger wraps the logrus Logger together with the exit code
 type logger struct {
 	*logrus.Logger
@@ -50,35 +73,72 @@ func init() {
 	// Initialize the logger
 	Logger = newLogger()
 
-	// Set the output to be stdout in the normal case, but discard all log output in quiet mode
+	// Set the output to be stderr in the normal case, but discard all log output in quiet mode.
+	// Stdout is left to the commands, dfs get writes the file there.
 	if Quiet {
 		Logger.SetOutput(io.Discard)
 	} else {
-		Logger.SetOutput(os.Stdout)
+		Logger.SetOutput(os.Stderr)
 	}
 
 	// Enable reporting the caller's file and line number
 	Logger.SetReportCaller(true)
 
 	// Customize the log output format
-	Logger.SetFormatter(&logrus.TextFormatter{
-		DisableTimestamp:       false,
-		ForceColors:            true,
-		DisableColors:          false,
-		FullTimestamp:          false,
-		DisableLevelTruncation: false,
-		PadLevelText:           true,
-		CallerPrettyfier: func(f *runtime.Frame) (string, string) {
-			filename := filepath.Base(f.File)
-			return "", filename + ":" + fmt.Sprint(f.Line)
-		},
-	})
+	Logger.SetFormatter(textFormatter(true))
 
 	// Disable the stdlib's automatic add of the timestamp in beginning of the log message,
 	// as we stream the logs from stdlib log to this logrus instance.
 	log.SetFlags(0)
 	log.SetOutput(Logger.Writer())
-	Logger.SetLevel(logrus.DebugLevel)
+	Logger.SetLevel(logrus.InfoLevel)
+}
+
+// Configure applies the options to Logger, it is meant to be called once
+// the flags are parsed and before anything is logged.
+func Configure(opts Options) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	colors := !Quiet
+	if len(opts.File) > 0 {
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		Logger.SetOutput(f)
+		colors = false
+	}
+
+	switch opts.Format {
+	case "", FormatText:
+		Logger.SetFormatter(textFormatter(colors))
+	case FormatJSON:
+		Logger.SetFormatter(&logrus.JSONFormatter{
+			CallerPrettyfier: callerPrettyfier,
+		})
+	default:
+		return fmt.Errorf("unknown log format %q, want %s or %s", opts.Format, FormatText, FormatJSON)
+	}
+	Logger.SetLevel(opts.Level)
+	return nil
+}
+
+func textFormatter(colors bool) *logrus.TextFormatter {
+	return &logrus.TextFormatter{
+		DisableTimestamp:       false,
+		ForceColors:            colors,
+		DisableColors:          !colors,
+		FullTimestamp:          !colors, // Files are read later, the elapsed time means little there.
+		DisableLevelTruncation: false,
+		PadLevelText:           true,
+		CallerPrettyfier:       callerPrettyfier,
+	}
+}
+
+func callerPrettyfier(f *runtime.Frame) (string, string) {
+	filename := filepath.Base(f.File)
+	return "", filename + ":" + fmt.Sprint(f.Line)
 }
 func IsLoggerInitialized() bool {
 	return Logger != nil && Logger.Out != io.Discard
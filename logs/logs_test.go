@@ -0,0 +1,52 @@
+package logs
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestConfigure(t *testing.T) {
+	out, formatter, level := Logger.Out, Logger.Formatter, Logger.Level
+	defer func() {
+		Logger.SetOutput(out)
+		Logger.SetFormatter(formatter)
+		Logger.SetLevel(level)
+	}()
+
+	path := filepath.Join(t.TempDir(), "dfs.log")
+	if err := Configure(Options{Format: FormatJSON, Level: logrus.DebugLevel, File: path}); err != nil {
+		t.Fatal(err)
+	}
+	Logger.WithField(FieldNode, "alice").WithField(FieldRequestID, "r1").Debug("stored")
+	Logger.Out.(*os.File).Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := bufio.NewScanner(f)
+	if !lines.Scan() {
+		t.Fatal("want a line logged")
+	}
+	line := map[string]string{}
+	if err := json.Unmarshal(lines.Bytes(), &line); err != nil {
+		t.Fatalf("want a JSON line have %s: %v", lines.Text(), err)
+	}
+	if line[FieldNode] != "alice" || line[FieldRequestID] != "r1" || line["msg"] != "stored" || line["level"] != "debug" {
+		t.Errorf("unexpected fields %v", line)
+	}
+	if !strings.HasPrefix(line["file"], "logs_test.go:") {
+		t.Errorf("want the file and line of the caller have %s", line["file"])
+	}
+
+	if err := Configure(Options{Format: "xml"}); err == nil {
+		t.Error("want an error for an unknown format")
+	}
+}
@@ -1,29 +1,9 @@
 package main
 
 import (
-	"log"
-
 	"github.com/ranjankuldeep/distributed_file_system/cmd"
-	"github.com/ranjankuldeep/distributed_file_system/logs"
-	"github.com/spf13/viper"
 )
 
-func init() {
-	viper.SetConfigName("config")
-	viper.AddConfigPath(".")
-	viper.WriteConfigAs(".")
-
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			logs.Logger.Info("No Config File Found")
-			logs.Logger.Info("Initializing Config files")
-		} else {
-			logs.Logger.Errorf("Error Occured Reading Config File %+v", err)
-			log.Fatal(err)
-		}
-	}
-}
-
 func main() {
 	cmd.Execute()
 	// // needed port to run and the peers port
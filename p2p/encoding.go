@@ -1,7 +1,9 @@
 package p2p
 
 import (
+	"encoding/binary"
 	"encoding/gob"
+	"fmt"
 	"io"
 )
 
@@ -34,14 +36,30 @@ func (dec DefaultDecoder) Decode(r io.Reader, msg *RPC) error {
 		return nil
 	}
 
-	// This can be done only for the incoming message type as it's not so big data.
-	buf := make([]byte, 1028)
-	n, err := r.Read(buf)
-	if err != nil {
+	// Messages are length prefixed (see FrameMessage), so a payload is never
+	// cut short or merged with the next one, whatever the size.
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return err
+	}
+	if size > MaxMessageSize {
+		return fmt.Errorf("message of %d bytes exceeds the limit of %d bytes", size, MaxMessageSize)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
 		return err
 	}
 
-	msg.Payload = buf[:n]
-	// Converitng slice of byte to string and sending over as payload to rpc object
+	msg.Payload = buf
 	return nil
 }
+
+// FrameMessage prepends the IncomingMessage byte and the payload length,
+// which is the layout DefaultDecoder expects for a message.
+func FrameMessage(payload []byte) []byte {
+	frame := make([]byte, 5+len(payload))
+	frame[0] = IncomingMessage
+	binary.LittleEndian.PutUint32(frame[1:5], uint32(len(payload)))
+	copy(frame[5:], payload)
+	return frame
+}
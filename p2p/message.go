@@ -5,6 +5,9 @@ const (
 	IncomingStream  = 0x2
 )
 
+// MaxMessageSize bounds the payload of a single message, streams are not limited.
+const MaxMessageSize = 16 << 20
+
 // RPC holds any arbitrary data that is being sent over the
 // each transport between two nodes in the network.
 type RPC struct {
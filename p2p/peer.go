@@ -7,5 +7,9 @@ import "net"
 type Peer interface {
 	net.Conn
 	Send([]byte) error
+	// IncomingStream is signaled once the transport read the IncomingStream
+	// byte and stopped reading the connection, the stream can then be read
+	// directly from the peer until CloseStream is called.
+	IncomingStream() <-chan struct{}
 	CloseStream()
 }
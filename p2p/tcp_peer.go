@@ -15,6 +15,7 @@ type TCPPeer struct {
 	// if we accept and retrieve a conn => outbound == false
 	outbound bool
 	wg       *sync.WaitGroup
+	streamch chan struct{}
 }
 
 func NewTCPPeer(conn net.Conn, outbound bool) *TCPPeer {
@@ -22,9 +23,14 @@ func NewTCPPeer(conn net.Conn, outbound bool) *TCPPeer {
 		Conn:     conn,
 		outbound: outbound,
 		wg:       &sync.WaitGroup{},
+		streamch: make(chan struct{}, 1),
 	}
 }
 
+func (p *TCPPeer) IncomingStream() <-chan struct{} {
+	return p.streamch
+}
+
 func (p *TCPPeer) CloseStream() {
 	p.wg.Done()
 }
@@ -2,7 +2,6 @@ package p2p
 
 import (
 	"errors"
-	"fmt"
 	"io"
 	"net"
 
@@ -63,7 +62,7 @@ func (t *TCPTransport) ListenAndAccept() error {
 		return err
 	}
 	go t.startAcceptLoop()
-	logs.Logger.Infof("TCP transport listening on port: %s\n", t.ListenAddr)
+	logs.Logger.Infof("TCP transport listening on port: %s", t.ListenAddr)
 	return nil
 }
 
@@ -75,7 +74,7 @@ func (t *TCPTransport) startAcceptLoop() {
 			return
 		}
 		if err != nil {
-			fmt.Printf("TCP accept error: %s\n", err)
+			logs.Logger.Errorf("TCP accept error: %s", err)
 		}
 		go t.handleConn(conn, false)
 	}
@@ -84,12 +83,15 @@ func (t *TCPTransport) startAcceptLoop() {
 // Spinned up for every request in seperate go routine.
 func (t *TCPTransport) handleConn(conn net.Conn, outbound bool) {
 	var err error
+	log := logs.Logger.WithField(logs.FieldPeer, conn.RemoteAddr().String())
 	defer func() {
-		logs.Logger.Infof("dropping peer connection: %s", err)
+		log.Infof("dropping peer connection: %s", err)
 		conn.Close()
 	}()
 
 	peer := NewTCPPeer(conn, outbound) // outbound represents that request for connecton is sent by the client.
+	// The reader of the streams stops along with the connection.
+	defer close(peer.streamch)
 	if err = t.HandshakeFunc(peer); err != nil {
 		return
 	}
@@ -110,20 +112,21 @@ func (t *TCPTransport) handleConn(conn net.Conn, outbound bool) {
 		err = t.Decoder.Decode(conn, &rpc)
 		if err != nil {
 			if err != io.EOF {
-				logs.Logger.Errorf("error decoding message: %s", err)
+				log.Errorf("error decoding message: %s", err)
 			}
 			return
 		}
 
-		logs.Logger.Info("still decoding")
+		log.Debug("still decoding")
 		rpc.From = conn.RemoteAddr().String()
 
 		// Do not handle here, stream data could be very huge, resulting in full memory blockage.
 		if rpc.Stream {
 			peer.wg.Add(1)
-			logs.Logger.Infof("[%s] incoming stream, waiting...\n", conn.RemoteAddr())
+			peer.streamch <- struct{}{} // Hand the connection over to the reader of the stream.
+			log.Debug("incoming stream, waiting...")
 			peer.wg.Wait() // Blocks the read loop, means no other message will be hadled here until the stream is read.
-			logs.Logger.Infof("[%s] stream closed, resuming read loop\n", conn.RemoteAddr())
+			log.Debug("stream closed, resuming read loop")
 			continue
 		}
 		t.rpcch <- rpc
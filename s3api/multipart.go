@@ -0,0 +1,150 @@
+package s3api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var (
+	errNoSuchUpload = errors.New("the specified multipart upload does not exist")
+	errInvalidPart  = errors.New("one or more of the specified parts could not be found")
+)
+
+// uploads keeps the parts of the multipart uploads in progress on disk,
+// one directory per upload holding one file per part.
+type uploads struct {
+	dir string
+}
+
+// Every upload records the key it was created for, a completion for another
+// key is refused.
+const uploadKeyFile = "key"
+
+func (u *uploads) create(key string) (string, error) {
+	uploadID := uuid.NewString()
+	dir := filepath.Join(u.dir, uploadID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, uploadKeyFile), []byte(key), 0600); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+// uploadDir returns the directory of the upload after checking that it
+// belongs to the key.
+func (u *uploads) uploadDir(uploadID string, key string) (string, error) {
+	if _, err := uuid.Parse(uploadID); err != nil {
+		return "", errNoSuchUpload
+	}
+	dir := filepath.Join(u.dir, uploadID)
+	b, err := os.ReadFile(filepath.Join(dir, uploadKeyFile))
+	if err != nil || string(b) != key {
+		return "", errNoSuchUpload
+	}
+	return dir, nil
+}
+
+// putPart writes a part and returns its ETag.
+func (u *uploads) putPart(uploadID string, key string, partNumber int, r io.Reader) (string, error) {
+	dir, err := u.uploadDir(uploadID, key)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, "part*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name()) // No-op once renamed.
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), r); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	etag := hex.EncodeToString(hash.Sum(nil))
+	if err := os.Rename(f.Name(), u.partPath(dir, partNumber, etag)); err != nil {
+		return "", err
+	}
+	return etag, nil
+}
+
+// A part uploaded again replaces the previous one, the ETag in the name
+// tells them apart.
+func (u *uploads) partPath(dir string, partNumber int, etag string) string {
+	return filepath.Join(dir, fmt.Sprintf("%05d.%s", partNumber, etag))
+}
+
+// open returns a reader over the listed parts, in order, to be closed by
+// the caller.
+func (u *uploads) open(uploadID string, key string, parts []completePart) (io.ReadCloser, error) {
+	dir, err := u.uploadDir(uploadID, key)
+	if err != nil {
+		return nil, err
+	}
+	files := []*os.File{}
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	last := 0
+	for _, part := range parts {
+		if part.PartNumber <= last {
+			closeAll()
+			return nil, fmt.Errorf("parts must be listed in ascending order")
+		}
+		last = part.PartNumber
+		etag := strings.Trim(part.ETag, `"`)
+		f, err := os.Open(u.partPath(dir, part.PartNumber, etag))
+		if err != nil {
+			closeAll()
+			return nil, errInvalidPart
+		}
+		files = append(files, f)
+	}
+	readers := make([]io.Reader, len(files))
+	for i, f := range files {
+		readers[i] = f
+	}
+	return &multiReadCloser{Reader: io.MultiReader(readers...), close: closeAll}, nil
+}
+
+func (u *uploads) abort(uploadID string, key string) error {
+	dir, err := u.uploadDir(uploadID, key)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+type multiReadCloser struct {
+	io.Reader
+	close func()
+}
+
+func (m *multiReadCloser) Close() error {
+	m.close()
+	return nil
+}
+
+func parsePartNumber(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > 10000 {
+		return 0, fmt.Errorf("part number must be an integer between 1 and 10000")
+	}
+	return n, nil
+}
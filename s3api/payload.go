@@ -0,0 +1,151 @@
+package s3api
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Chunks bigger than this are refused, clients use 64KB to a few MB.
+const maxChunkSize = 16 << 20
+
+var errContentSHA256Mismatch = errors.New("the provided x-amz-content-sha256 does not match what was received")
+
+// payloadReader returns the body of the request decoded and verified
+// according to its x-amz-content-sha256 header. Verification errors surface
+// from Read, so a write consuming it fails before being committed.
+func payloadReader(body io.Reader, req *signedRequest) (io.Reader, error) {
+	switch req.payloadHash {
+	case unsignedPayload:
+		return body, nil
+	case streamingPayload:
+		return &chunkedReader{r: bufio.NewReader(body), req: req, prevSig: req.seed, signed: true}, nil
+	case streamingUnsignedTrailer:
+		return &chunkedReader{r: bufio.NewReader(body), req: req}, nil
+	}
+	want, err := hex.DecodeString(req.payloadHash)
+	if err != nil || len(want) != sha256.Size {
+		return nil, fmt.Errorf("unsupported x-amz-content-sha256 %q", req.payloadHash)
+	}
+	return &hashReader{r: body, hash: sha256.New(), want: want}, nil
+}
+
+// hashReader fails the last Read when the content does not match the hash.
+type hashReader struct {
+	r    io.Reader
+	hash hash.Hash
+	want []byte
+}
+
+func (h *hashReader) Read(b []byte) (int, error) {
+	n, err := h.r.Read(b)
+	h.hash.Write(b[:n])
+	if err == io.EOF && !hmac.Equal(h.hash.Sum(nil), h.want) {
+		return n, errContentSHA256Mismatch
+	}
+	return n, err
+}
+
+// chunkedReader decodes an aws-chunked body:
+//
+//	<hex size>;chunk-signature=<signature>\r\n<data>\r\n ... 0;chunk-signature=<signature>\r\n\r\n
+//
+// Every chunk signature chains from the previous one, starting with the
+// signature of the headers. The unsigned variant has no signatures and may
+// end with trailing checksum headers, which are skipped.
+type chunkedReader struct {
+	r       *bufio.Reader
+	req     *signedRequest
+	signed  bool
+	prevSig string
+	chunk   []byte
+	done    bool
+}
+
+func (c *chunkedReader) Read(b []byte) (int, error) {
+	for len(c.chunk) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, c.chunk)
+	c.chunk = c.chunk[n:]
+	return n, nil
+}
+
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	sizeHex, ext, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return fmt.Errorf("malformed chunk size %q", sizeHex)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return err
+	}
+
+	if c.signed {
+		sig, ok := strings.CutPrefix(ext, "chunk-signature=")
+		if !ok {
+			return errSignatureMismatch
+		}
+		if !hmac.Equal([]byte(sig), []byte(c.chunkSignature(data))) {
+			return errSignatureMismatch
+		}
+		c.prevSig = sig
+	}
+
+	if size == 0 {
+		c.done = true
+		// Skip the trailers up to the final empty line.
+		for {
+			line, err := c.readLine()
+			if err != nil || len(line) == 0 {
+				return err
+			}
+		}
+	}
+	if line, err := c.readLine(); err != nil || len(line) != 0 {
+		return fmt.Errorf("malformed chunk terminator")
+	}
+	c.chunk = data
+	return nil
+}
+
+func (c *chunkedReader) chunkSignature(data []byte) string {
+	hash := sha256.Sum256(data)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256-PAYLOAD",
+		c.req.amzDate,
+		c.req.scope,
+		c.prevSig,
+		emptySHA256,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+	return hex.EncodeToString(hmacSHA256(c.req.signingKey, []byte(stringToSign)))
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,413 @@
+package s3api
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/fileserver"
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/ranjankuldeep/distributed_file_system/store"
+)
+
+const (
+	defaultMaxKeys  = 1000
+	lastModifiedFmt = "2006-01-02T15:04:05.000Z"
+	// Bound of the CompleteMultipartUpload request body.
+	maxCompleteBodySize = 1 << 20
+)
+
+type Options struct {
+	ListenAddr  string
+	Credentials []Credentials
+	// Directory holding the parts of the multipart uploads in progress,
+	// defaults to a folder under the storage root of the file server.
+	UploadDir string
+}
+
+// Server is an S3 compatible front end of the file server, enough of the API
+// for aws-cli and the SDKs: PutObject, GetObject, HeadObject, DeleteObject,
+// ListObjectsV2 and multipart uploads. Requests must be signed with
+// Signature Version 4 and use path style addressing (endpoint/bucket/key).
+//
+// A bucket is a namespace of the owner of the node, the object "photos/a.jpg"
+// of the bucket "family" is the file "family/photos/a.jpg". Buckets exist as
+// soon as an object is stored in them.
+type Server struct {
+	fs      *fileserver.FileServer
+	creds   map[string]Credentials
+	uploads *uploads
+	server  *http.Server
+	now     func() time.Time
+}
+
+func New(opts Options, fs *fileserver.FileServer) *Server {
+	if len(opts.UploadDir) == 0 {
+		opts.UploadDir = filepath.Join(fs.StorageRoot, "_s3_uploads")
+	}
+	creds := make(map[string]Credentials, len(opts.Credentials))
+	for _, c := range opts.Credentials {
+		creds[c.AccessKey] = c
+	}
+	s := &Server{
+		fs:      fs,
+		creds:   creds,
+		uploads: &uploads{dir: opts.UploadDir},
+		now:     time.Now,
+	}
+	s.server = &http.Server{
+		Addr:    opts.ListenAddr,
+		Handler: s,
+	}
+	return s
+}
+
+// ListenAndServe blocks until the server is closed.
+func (s *Server) ListenAndServe() error {
+	logs.Logger.Infof("S3 API listening on %s", s.server.Addr)
+	if err := s.server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := verifyRequest(r, s.creds, s.now())
+	if err != nil {
+		s.writeAuthError(w, r, err)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	switch {
+	case len(bucket) == 0:
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "listing buckets is not supported")
+	case len(key) == 0:
+		s.serveBucket(w, r, bucket)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.createMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.completeMultipartUpload(w, r, req, bucket, key)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		s.uploadPart(w, r, req, bucket, key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		s.abortMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, req, bucket, key)
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		s.getObject(w, r, bucket, key)
+	case r.Method == http.MethodDelete:
+		s.deleteObject(w, r, bucket, key)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "the method is not allowed against this resource")
+	}
+}
+
+func (s *Server) writeAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errMissingAuth):
+		writeError(w, r, http.StatusForbidden, "AccessDenied", err.Error())
+	case errors.Is(err, errInvalidAccessKey):
+		writeError(w, r, http.StatusForbidden, "InvalidAccessKeyId", err.Error())
+	case errors.Is(err, errSignatureMismatch):
+		writeError(w, r, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+	case errors.Is(err, errClockSkew):
+		writeError(w, r, http.StatusForbidden, "RequestTimeTooSkewed", err.Error())
+	default:
+		writeError(w, r, http.StatusBadRequest, "AuthorizationHeaderMalformed", err.Error())
+	}
+}
+
+// writeStoreError reports why storing a payload failed.
+func (s *Server) writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errSignatureMismatch):
+		writeError(w, r, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+	case errors.Is(err, errContentSHA256Mismatch):
+		writeError(w, r, http.StatusBadRequest, "XAmzContentSHA256Mismatch", err.Error())
+	case errors.Is(err, store.ErrInvalidKey), errors.Is(err, store.ErrIsDir), errors.Is(err, store.ErrNotDir):
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", err.Error())
+	default:
+		logs.Logger.Errorf("Error Storing %s over S3: %v", r.URL.Path, err)
+		writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+	}
+}
+
+func (s *Server) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listObjects(w, r, bucket)
+	case http.MethodHead, http.MethodPut:
+		// Buckets are implicit namespaces, they always exist.
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "the method is not allowed against this resource")
+	}
+}
+
+func objectKey(bucket string, key string) string {
+	return bucket + "/" + key
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, req *signedRequest, bucket string, key string) {
+	if len(r.Header.Get("X-Amz-Copy-Source")) > 0 {
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "CopyObject is not supported")
+		return
+	}
+	body, err := payloadReader(r.Body, req)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	if err := s.fs.Store(objectKey(bucket, key), body); err != nil {
+		s.writeStoreError(w, r, err)
+		return
+	}
+	if meta, err := s.fs.Stat(objectKey(bucket, key)); err == nil {
+		w.Header().Set("ETag", etag(meta.Digest))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	rd, err := s.fs.Get(objectKey(bucket, key))
+	if errors.Is(err, fileserver.ErrFileNotFound) {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	if rc, ok := rd.(io.Closer); ok {
+		defer rc.Close()
+	}
+	meta, err := s.fs.Stat(objectKey(bucket, key))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	w.Header().Set("ETag", etag(meta.Digest))
+	rs, ok := rd.(io.ReadSeeker)
+	if !ok {
+		w.Header().Set("Content-Length", strconv.FormatInt(meta.Size, 10))
+		w.Header().Set("Last-Modified", meta.ModifiedAt.UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			io.Copy(w, rd)
+		}
+		return
+	}
+	// ServeContent takes care of Range, conditional requests and HEAD.
+	http.ServeContent(w, r, path.Base(key), meta.ModifiedAt, rs)
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	if err := s.fs.Delete(objectKey(bucket, key)); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	var (
+		prefix    = query.Get("prefix")
+		delimiter = query.Get("delimiter")
+		maxKeys   = defaultMaxKeys
+		marker    = query.Get("start-after")
+		token     = query.Get("continuation-token")
+	)
+	if v := query.Get("max-keys"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "max-keys must be a positive integer")
+			return
+		}
+		maxKeys = min(n, defaultMaxKeys)
+	}
+	if len(token) > 0 {
+		b, err := base64.URLEncoding.DecodeString(token)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid continuation token")
+			return
+		}
+		marker = string(b)
+	}
+
+	files, err := s.fs.List(objectKey(bucket, prefix))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	result := listBucketResult{
+		Xmlns:             s3Namespace,
+		Name:              bucket,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: token,
+		MaxKeys:           maxKeys,
+	}
+	seenPrefixes := map[string]bool{}
+	last := ""
+	for _, f := range files {
+		key := strings.TrimPrefix(f.Key, bucket+"/")
+		if key <= marker || skipsPrefix(key, marker, delimiter) {
+			continue
+		}
+		common := commonPrefixOf(key, prefix, delimiter)
+		if len(common) > 0 && seenPrefixes[common] {
+			continue
+		}
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = base64.URLEncoding.EncodeToString([]byte(last))
+			break
+		}
+		result.KeyCount++
+		if len(common) > 0 {
+			seenPrefixes[common] = true
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: common})
+			last = common
+			continue
+		}
+		result.Contents = append(result.Contents, toObject(key, f))
+		last = key
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// A page ending with a common prefix resumes after all the keys it groups.
+func skipsPrefix(key string, marker string, delimiter string) bool {
+	return len(delimiter) > 0 && strings.HasSuffix(marker, delimiter) && strings.HasPrefix(key, marker)
+}
+
+// commonPrefixOf returns the prefix grouping the key when it holds the
+// delimiter after the listed prefix.
+func commonPrefixOf(key string, prefix string, delimiter string) string {
+	if len(delimiter) == 0 {
+		return ""
+	}
+	i := strings.Index(key[len(prefix):], delimiter)
+	if i < 0 {
+		return ""
+	}
+	return key[:len(prefix)+i+len(delimiter)]
+}
+
+func toObject(key string, meta store.FileMeta) object {
+	return object{
+		Key:          key,
+		LastModified: meta.ModifiedAt.UTC().Format(lastModifiedFmt),
+		ETag:         etag(meta.Digest),
+		Size:         meta.Size,
+		StorageClass: "STANDARD",
+	}
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	uploadID, err := s.uploads.create(objectKey(bucket, key))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: uploadID,
+	})
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, req *signedRequest, bucket string, key string) {
+	query := r.URL.Query()
+	partNumber, err := parsePartNumber(query.Get("partNumber"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	body, err := payloadReader(r.Body, req)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	partETag, err := s.uploads.putPart(query.Get("uploadId"), objectKey(bucket, key), partNumber, body)
+	if errors.Is(err, errNoSuchUpload) {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", err.Error())
+		return
+	}
+	if err != nil {
+		s.writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(partETag))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, req *signedRequest, bucket string, key string) {
+	uploadID := r.URL.Query().Get("uploadId")
+	body, err := payloadReader(io.LimitReader(r.Body, maxCompleteBodySize), req)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	complete := completeMultipartUpload{}
+	if err := xml.NewDecoder(body).Decode(&complete); err != nil || len(complete.Parts) == 0 {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", "the XML provided was not well-formed")
+		return
+	}
+
+	parts, err := s.uploads.open(uploadID, objectKey(bucket, key), complete.Parts)
+	switch {
+	case errors.Is(err, errNoSuchUpload):
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", err.Error())
+		return
+	case errors.Is(err, errInvalidPart):
+		writeError(w, r, http.StatusBadRequest, "InvalidPart", err.Error())
+		return
+	case err != nil:
+		writeError(w, r, http.StatusBadRequest, "InvalidPartOrder", err.Error())
+		return
+	}
+	err = s.fs.Store(objectKey(bucket, key), parts)
+	parts.Close()
+	if err != nil {
+		s.writeStoreError(w, r, err)
+		return
+	}
+	if err := s.uploads.abort(uploadID, objectKey(bucket, key)); err != nil {
+		logs.Logger.Errorf("Error cleaning up upload %s: %v", uploadID, err)
+	}
+
+	result := completeMultipartUploadResult{Xmlns: s3Namespace, Bucket: bucket, Key: key}
+	if meta, err := s.fs.Stat(objectKey(bucket, key)); err == nil {
+		result.ETag = etag(meta.Digest)
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	if err := s.uploads.abort(r.URL.Query().Get("uploadId"), objectKey(bucket, key)); err != nil {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func etag(digest string) string {
+	return `"` + digest + `"`
+}
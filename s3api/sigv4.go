@@ -0,0 +1,230 @@
+package s3api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat  = "20060102T150405Z"
+	maxClockSkew   = 15 * time.Minute
+
+	// Values of the x-amz-content-sha256 header which are not a payload hash.
+	unsignedPayload          = "UNSIGNED-PAYLOAD"
+	streamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// Credentials is the access key pair the clients sign their requests with.
+type Credentials struct {
+	AccessKey string
+	SecretKey string
+}
+
+var (
+	errMissingAuth       = errors.New("missing AWS Signature Version 4 authorization")
+	errInvalidAccessKey  = errors.New("the access key does not exist")
+	errSignatureMismatch = errors.New("the request signature does not match")
+	errClockSkew         = errors.New("the difference between the request time and the server time is too large")
+)
+
+// authorization is the parsed Authorization header of a request.
+type authorization struct {
+	accessKey     string
+	date          string // YYYYMMDD of the credential scope.
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+}
+
+func (a *authorization) scope() string {
+	return strings.Join([]string{a.date, a.region, a.service, "aws4_request"}, "/")
+}
+
+// signedRequest holds what is needed to verify the chunks of a streaming
+// payload once the headers have been verified.
+type signedRequest struct {
+	amzDate     string
+	scope       string
+	signingKey  []byte
+	seed        string // Signature of the headers, the first chunk chains from it.
+	payloadHash string
+}
+
+// Example: AWS4-HMAC-SHA256 Credential=AKID/20130524/us-east-1/s3/aws4_request,
+// SignedHeaders=host;range;x-amz-date, Signature=fe5f80f7...
+func parseAuthorization(header string) (*authorization, error) {
+	if !strings.HasPrefix(header, sigV4Algorithm+" ") {
+		return nil, errMissingAuth
+	}
+	auth := &authorization{}
+	for _, field := range strings.Split(strings.TrimPrefix(header, sigV4Algorithm+" "), ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed authorization field %q", field)
+		}
+		switch k {
+		case "Credential":
+			parts := strings.Split(v, "/")
+			if len(parts) != 5 || parts[4] != "aws4_request" {
+				return nil, fmt.Errorf("malformed credential %q", v)
+			}
+			auth.accessKey, auth.date, auth.region, auth.service = parts[0], parts[1], parts[2], parts[3]
+		case "SignedHeaders":
+			auth.signedHeaders = strings.Split(v, ";")
+		case "Signature":
+			auth.signature = v
+		}
+	}
+	if len(auth.accessKey) == 0 || len(auth.signedHeaders) == 0 || len(auth.signature) == 0 {
+		return nil, errMissingAuth
+	}
+	return auth, nil
+}
+
+// verifyRequest checks the signature of the request headers against the
+// known credentials.
+func verifyRequest(r *http.Request, creds map[string]Credentials, now time.Time) (*signedRequest, error) {
+	auth, err := parseAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return nil, err
+	}
+	cred, ok := creds[auth.accessKey]
+	if !ok {
+		return nil, errInvalidAccessKey
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) == 0 {
+		amzDate = r.Header.Get("Date")
+	}
+	t, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x-amz-date %q", amzDate)
+	}
+	if d := now.Sub(t); d > maxClockSkew || d < -maxClockSkew {
+		return nil, errClockSkew
+	}
+	if !strings.HasPrefix(amzDate, auth.date) {
+		return nil, errSignatureMismatch
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if len(payloadHash) == 0 {
+		payloadHash = unsignedPayload
+	}
+	key := signingKey(cred.SecretKey, auth.date, auth.region, auth.service)
+	creq := canonicalRequest(r, auth.signedHeaders, payloadHash)
+	sig := hex.EncodeToString(hmacSHA256(key, []byte(stringToSign(amzDate, auth.scope(), creq))))
+	if !hmac.Equal([]byte(sig), []byte(auth.signature)) {
+		return nil, errSignatureMismatch
+	}
+	return &signedRequest{
+		amzDate:     amzDate,
+		scope:       auth.scope(),
+		signingKey:  key,
+		seed:        sig,
+		payloadHash: payloadHash,
+	}, nil
+}
+
+func canonicalRequest(r *http.Request, signedHeaders []string, payloadHash string) string {
+	return strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path, false),
+		canonicalQuery(r.URL.Query()),
+		canonicalHeaders(r, signedHeaders),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func canonicalQuery(values url.Values) string {
+	pairs := []string{}
+	for k, vs := range values {
+		if k == "X-Amz-Signature" {
+			continue
+		}
+		for _, v := range vs {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// Every signed header on its own line followed by a newline, so the block
+// ends with an empty line once joined.
+func canonicalHeaders(r *http.Request, signedHeaders []string) string {
+	b := strings.Builder{}
+	for _, name := range signedHeaders {
+		var values []string
+		switch {
+		case name == "host":
+			values = []string{r.Host}
+		case name == "content-length" && r.ContentLength >= 0:
+			// net/http moves it out of the header map.
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		default:
+			values = append([]string(nil), r.Header.Values(name)...)
+		}
+		for i, v := range values {
+			values[i] = strings.Join(strings.Fields(v), " ")
+		}
+		b.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+	return b.String()
+}
+
+func stringToSign(amzDate string, scope string, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	return strings.Join([]string{sigV4Algorithm, amzDate, scope, hex.EncodeToString(hash[:])}, "\n")
+}
+
+func signingKey(secret string, date string, region string, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), []byte(date))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte(service))
+	return hmacSHA256(key, []byte("aws4_request"))
+}
+
+func hmacSHA256(key []byte, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// uriEncode percent encodes everything but the unreserved characters, as
+// specified for the canonical request. The slash is kept in paths.
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&0xf])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,228 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ChunkSize is the size of the slices of a blob hashed on their own, a
+// version can be fetched from several peers at once chunk by chunk.
+const ChunkSize = 4 << 20
+
+// ErrChunkDigest is returned for a chunk not matching the digest recorded.
+var ErrChunkDigest = errors.New("store: chunk digest mismatch")
+
+// ErrBlobDigest is returned for a blob received from the peers not matching the
+// digest recorded.
+var ErrBlobDigest = errors.New("store: blob digest mismatch")
+
+// chunkHasher records the sha256 of every ChunkSize bytes written.
+type chunkHasher struct {
+	hash    hash.Hash
+	n       int64
+	digests [][]byte
+}
+
+func newChunkHasher() *chunkHasher {
+	return &chunkHasher{hash: sha256.New()}
+}
+
+func (c *chunkHasher) Write(b []byte) (int, error) {
+	written := len(b)
+	for len(b) > 0 {
+		n := min(int64(len(b)), ChunkSize-c.n%ChunkSize)
+		c.hash.Write(b[:n])
+		c.n += n
+		b = b[n:]
+		if c.n%ChunkSize == 0 {
+			c.digests = append(c.digests, c.hash.Sum(nil))
+			c.hash.Reset()
+		}
+	}
+	return written, nil
+}
+
+// Sum returns the digests of the chunks, the last one being shorter.
+func (c *chunkHasher) Sum() [][]byte {
+	if c.n%ChunkSize == 0 && c.n > 0 {
+		return c.digests
+	}
+	return append(c.digests, c.hash.Sum(nil))
+}
+
+// ChunkRange returns the offset and the size of the chunk i of a blob of size
+// bytes.
+func ChunkRange(size int64, i int) (int64, int64) {
+	off := int64(i) * ChunkSize
+	return off, min(ChunkSize, size-off)
+}
+
+// WriteChunks writes a version fetched from several peers at once, tmpl
+// holding its metadata as recorded by them, chunk digests included. fill
+// is given the chunks missing and writes each of them with a single WriteAt
+// at its offset, in any order and from as many goroutines as it likes; a
+// chunk not matching its digest is refused with ErrChunkDigest. The chunks
+// already received are kept when fill fails, in a partial blob a later
+// attempt or WriteSealedAt resumes. The version is then committed like
+// WriteSealed does, as the latest one with latest.
+func (s *Store) WriteChunks(tmpl FileMeta, latest bool, fill func(w io.WriterAt, missing []int) error) (*FileMeta, error) {
+	id, key := tmpl.ID, tmpl.Key
+	if len(tmpl.VersionID) == 0 || int64(len(tmpl.ChunkDigests)) != (tmpl.Size+ChunkSize-1)/ChunkSize {
+		return nil, fmt.Errorf("store: no chunk digests for %s", key)
+	}
+	if err := s.CheckQuota(id, tmpl.Size); err != nil {
+		return nil, err
+	}
+	idx, err := s.index()
+	if err != nil {
+		return nil, err
+	}
+	p := s.partialPath(id, key, tmpl.VersionID)
+	held := int64(0)
+	if fi, err := os.Stat(p); err == nil {
+		held = fi.Size()
+	}
+	if err := s.CheckSpace(tmpl.Size - held); err != nil {
+		return nil, err
+	}
+	unlock, err := s.lockPartial(p)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = idx.db.Update(func(tx *bolt.Tx) error {
+		return putPartial(tx, &Partial{ID: id, Key: key, VersionID: tmpl.VersionID, UpdatedAt: time.Now().UTC()})
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	w := &chunkWriter{f: f, size: tmpl.Size, digests: tmpl.ChunkDigests, held: make([]bool, len(tmpl.ChunkDigests))}
+	missing := w.check()
+	if err := fill(w, missing); err != nil {
+		// Only the chunks in a row from the start are kept, the transfers
+		// resuming from the size of the partial blob rely on it.
+		f.Truncate(w.prefix())
+		f.Sync()
+		f.Close()
+		return nil, err
+	}
+	if w.prefix() != tmpl.Size {
+		f.Close()
+		return nil, fmt.Errorf("store: chunks of %s left unwritten", key)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// Every chunk matched its digest, which the peers may still have made up
+	// along with the blob: the whole of it must match the digest recorded.
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, tmpl.Size)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != tmpl.Digest {
+		s.removePartials(func(p Partial) bool { return p.ID == id && p.Key == key && p.VersionID == tmpl.VersionID })
+		return nil, fmt.Errorf("%w: %s", ErrBlobDigest, key)
+	}
+
+	now := time.Now().UTC()
+	meta := tmpl
+	meta.Replicas, meta.Siblings = nil, nil
+	meta.CreatedAt, meta.ModifiedAt = now, now
+	err = idx.commitBlob(&meta, p, s.blobPath(id, key, meta.VersionID), s.StoreOpts, latest)
+	if err != nil {
+		s.removePartials(func(p Partial) bool { return p.ID == id && p.Key == key && p.VersionID == meta.VersionID })
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// chunkWriter writes the chunks of a blob into its partial file, checking
+// each of them against its digest.
+type chunkWriter struct {
+	f       *os.File
+	size    int64
+	digests [][]byte
+
+	lock sync.Mutex
+	held []bool
+}
+
+// check marks the chunks the partial file holds already and returns the
+// other ones.
+func (w *chunkWriter) check() []int {
+	missing := []int{}
+	buf := make([]byte, ChunkSize)
+	for i := range w.digests {
+		off, n := ChunkRange(w.size, i)
+		if _, err := w.f.ReadAt(buf[:n], off); err == nil {
+			sum := sha256.Sum256(buf[:n])
+			if bytes.Equal(sum[:], w.digests[i]) {
+				w.held[i] = true
+				continue
+			}
+		}
+		missing = append(missing, i)
+	}
+	return missing
+}
+
+func (w *chunkWriter) WriteAt(b []byte, off int64) (int, error) {
+	i := int(off / ChunkSize)
+	if off%ChunkSize != 0 || i >= len(w.digests) {
+		return 0, fmt.Errorf("store: no chunk at %d", off)
+	}
+	if _, n := ChunkRange(w.size, i); int64(len(b)) != n {
+		return 0, fmt.Errorf("store: chunk %d is %d bytes, not %d", i, n, len(b))
+	}
+	if sum := sha256.Sum256(b); !bytes.Equal(sum[:], w.digests[i]) {
+		return 0, fmt.Errorf("%w: chunk %d", ErrChunkDigest, i)
+	}
+	n, err := w.f.WriteAt(b, off)
+	if err != nil {
+		return n, err
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.held[i] = true
+	return n, nil
+}
+
+// prefix returns the bytes of the chunks held in a row from the start.
+func (w *chunkWriter) prefix() int64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for i, held := range w.held {
+		if !held {
+			off, _ := ChunkRange(w.size, i)
+			return off
+		}
+	}
+	return w.size
+}
+
+var _ io.WriterAt = (*chunkWriter)(nil)
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	nodeBucket   = []byte("node")
+	replicaIDKey = []byte("replica_id")
+)
+
+// VersionVector counts the writes of a key made through every node, by the
+// replica ID of the node. A version has seen all the writes of another when
+// its vector descends from the one of the other.
+type VersionVector map[string]uint64
+
+// Descends reports whether v has seen every write w has, it does when they
+// are equal.
+func (v VersionVector) Descends(w VersionVector) bool {
+	for id, n := range w {
+		if v[id] < n {
+			return false
+		}
+	}
+	return true
+}
+
+// Concurrent reports whether neither v nor w has seen all the writes of the
+// other one.
+func (v VersionVector) Concurrent(w VersionVector) bool {
+	return !v.Descends(w) && !w.Descends(v)
+}
+
+// Merge returns the writes seen by either v or w.
+func (v VersionVector) Merge(w VersionVector) VersionVector {
+	merged := make(VersionVector, len(v)+len(w))
+	for id, n := range v {
+		merged[id] = n
+	}
+	for id, n := range w {
+		merged[id] = max(merged[id], n)
+	}
+	return merged
+}
+
+func (v VersionVector) increment(id string) VersionVector {
+	next := v.Merge(nil)
+	next[id]++
+	return next
+}
+
+// Timestamp is a reading of a hybrid logical clock: the wall clock in
+// nanoseconds, and a counter ordering the readings within the same
+// nanosecond or behind a peer whose clock runs ahead.
+type Timestamp struct {
+	Wall    int64  `json:"wall"`
+	Logical uint32 `json:"logical,omitempty"`
+}
+
+// Before reports whether t happened before u.
+func (t Timestamp) Before(u Timestamp) bool {
+	return t.Wall < u.Wall || (t.Wall == u.Wall && t.Logical < u.Logical)
+}
+
+// hlc is the hybrid logical clock of the node, it never goes backwards and
+// stays ahead of the timestamps received from the peers.
+type hlc struct {
+	mu   sync.Mutex
+	last Timestamp
+}
+
+func (c *hlc) now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if wall := time.Now().UnixNano(); wall > c.last.Wall {
+		c.last = Timestamp{Wall: wall}
+	} else {
+		c.last.Logical++
+	}
+	return c.last
+}
+
+func (c *hlc) update(remote Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.last.Before(remote) {
+		c.last = remote
+	}
+}
+
+// replicaID returns the ID of this node in the version vectors, created
+// along with the index.
+func replicaID(tx *bolt.Tx) (string, error) {
+	b, err := tx.CreateBucketIfNotExists(nodeBucket)
+	if err != nil {
+		return "", err
+	}
+	if id := b.Get(replicaIDKey); id != nil {
+		return string(id), nil
+	}
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+	return id, b.Put(replicaIDKey, []byte(id))
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ConflictPolicy settles the concurrent versions of a key, written through
+// different nodes without either having seen the other.
+type ConflictPolicy string
+
+const (
+	// ConflictLWW keeps the version with the latest timestamp as the latest
+	// one, the others stay in the history of the key.
+	ConflictLWW ConflictPolicy = "lww"
+	// ConflictKeepBoth keeps the concurrent versions as siblings of the latest
+	// one until a write following all of them.
+	ConflictKeepBoth ConflictPolicy = "keep-both"
+)
+
+// heads returns the latest version of the key along with its siblings, none
+// when the key is unknown.
+func heads(tx *bolt.Tx, id string, key string) ([]*FileMeta, error) {
+	latest, err := getMeta(tx, id, key)
+	if err == ErrNoMeta {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	heads := []*FileMeta{latest}
+	for _, versionID := range latest.Siblings {
+		if sibling, err := getVersion(tx, id, key, versionID); err == nil {
+			heads = append(heads, sibling)
+		}
+	}
+	return heads, nil
+}
+
+// supersedes reports whether a has seen all the writes of b and b has not
+// seen all the ones of a.
+func supersedes(a *FileMeta, b *FileMeta) bool {
+	return a.Clock.Descends(b.Clock) && !b.Clock.Descends(a.Clock)
+}
+
+// resolve makes meta the latest version of its key unless one of the heads
+// already superseded it. The heads it does not supersede are concurrent with
+// it and settled by the policy, the winner being the one with the latest
+// timestamp. It reports whether the latest version changed.
+func resolve(tx *bolt.Tx, meta *FileMeta, heads []*FileMeta, policy ConflictPolicy) (bool, error) {
+	for _, h := range heads {
+		if h.VersionID == meta.VersionID || supersedes(h, meta) {
+			return false, nil
+		}
+	}
+	live := []*FileMeta{meta}
+	for _, h := range heads {
+		if !supersedes(meta, h) {
+			live = append(live, h)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool {
+		if live[i].Timestamp != live[j].Timestamp {
+			return live[j].Timestamp.Before(live[i].Timestamp)
+		}
+		return live[i].VersionID > live[j].VersionID
+	})
+
+	winner := live[0]
+	winner.Siblings = nil
+	if len(heads) > 0 && winner != meta {
+		winner.Replicas = heads[0].Replicas
+	}
+	if policy == ConflictKeepBoth {
+		for _, sibling := range live[1:] {
+			winner.Siblings = append(winner.Siblings, sibling.VersionID)
+		}
+	} else {
+		// The winner has seen the losers from now on, a write following
+		// it follows them too.
+		for _, loser := range live[1:] {
+			winner.Clock = winner.Clock.Merge(loser.Clock)
+		}
+		if winner != meta {
+			if err := putVersion(tx, winner); err != nil {
+				return false, err
+			}
+		}
+	}
+	if err := putMeta(tx, winner); err != nil {
+		return false, err
+	}
+	return winner == meta, clearTombstone(tx, winner)
+}
+
+// Heads returns the latest versions of all the files of the owner along
+// with their siblings.
+func (s *Store) Heads(id string) ([]FileMeta, error) {
+	files, err := s.List(id, "")
+	if err != nil {
+		return nil, err
+	}
+	all := make([]FileMeta, 0, len(files))
+	for _, latest := range files {
+		all = append(all, latest)
+		for _, versionID := range latest.Siblings {
+			if sibling, err := s.StatVersion(id, latest.Key, versionID); err == nil {
+				all = append(all, *sibling)
+			}
+		}
+	}
+	return all, nil
+}
+
+// Knows reports whether the node holds the version of the file or a later
+// one, written after it was seen.
+func (s *Store) Knows(meta FileMeta) bool {
+	if _, err := s.StatVersion(meta.ID, meta.Key, meta.VersionID); err == nil {
+		return true
+	}
+	idx, err := s.index()
+	if err != nil {
+		return false
+	}
+	known := false
+	idx.db.View(func(tx *bolt.Tx) error {
+		hs, err := heads(tx, meta.ID, meta.Key)
+		for _, h := range hs {
+			known = known || supersedes(h, &meta)
+		}
+		return err
+	})
+	return known
+}
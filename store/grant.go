@@ -0,0 +1,116 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/auth"
+	bolt "go.etcd.io/bbolt"
+)
+
+var grantsBucket = []byte("grants")
+
+// Grant gives another user access to a file: the data key of the file
+// wrapped for the public key of the grantee.
+type Grant struct {
+	ID      string `json:"id"` // ID of the owner of the file.
+	Key     string `json:"key"`
+	Grantee string `json:"grantee"` // Hex encoded X25519 public key.
+	// Public key of the ephemeral pair the data key was wrapped with.
+	EphemeralKey []byte    `json:"ephemeral_key"`
+	WrappedKey   []byte    `json:"wrapped_key"`
+	CreatedAt    time.Time `json:"created_at"`
+	// Token of the owner allowing the grantee to get the file.
+	Token *auth.Token `json:"token,omitempty"`
+}
+
+// The grants of an owner are keyed by the file key followed by the grantee,
+// so the ones of a file are next to each other.
+func grantKey(key string, grantee string) []byte {
+	return []byte(key + "\x00" + grantee)
+}
+
+func putGrant(tx *bolt.Tx, g *Grant) error {
+	b, err := tx.Bucket(grantsBucket).CreateBucketIfNotExists([]byte(g.ID))
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	return b.Put(grantKey(g.Key, g.Grantee), v)
+}
+
+func fileGrants(tx *bolt.Tx, id string, key string) ([]Grant, error) {
+	grants := []Grant{}
+	b := tx.Bucket(grantsBucket).Bucket([]byte(id))
+	if b == nil {
+		return grants, nil
+	}
+	prefix := grantKey(key, "")
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		g := Grant{}
+		if err := json.Unmarshal(v, &g); err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	return grants, nil
+}
+
+func deleteGrants(tx *bolt.Tx, id string, key string) error {
+	b := tx.Bucket(grantsBucket).Bucket([]byte(id))
+	if b == nil {
+		return nil
+	}
+	prefix := grantKey(key, "")
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PutGrant records the grant, replacing the previous one of the grantee.
+func (s *Store) PutGrant(g Grant) error {
+	idx, err := s.index()
+	if err != nil {
+		return err
+	}
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		return putGrant(tx, &g)
+	})
+}
+
+// Grant returns the grant of the file to grantee, nil when there is none.
+func (s *Store) Grant(id string, key string, grantee string) (*Grant, error) {
+	grants, err := s.Grants(id, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range grants {
+		if g.Grantee == grantee {
+			return &g, nil
+		}
+	}
+	return nil, nil
+}
+
+// Grants returns the grants of the file, they are dropped with the file.
+func (s *Store) Grants(id string, key string) ([]Grant, error) {
+	idx, err := s.index()
+	if err != nil {
+		return nil, err
+	}
+	var grants []Grant
+	err = idx.db.View(func(tx *bolt.Tx) error {
+		grants, err = fileGrants(tx, id, key)
+		return err
+	})
+	return grants, err
+}
@@ -0,0 +1,300 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/auth"
+	bolt "go.etcd.io/bbolt"
+)
+
+const metaFileName = "meta.db"
+
+var (
+	filesBucket = []byte("files")
+
+	// ErrNoMeta is returned when the index holds no record for a key.
+	ErrNoMeta = errors.New("store: no metadata for key")
+)
+
+// FileMeta is the record kept in the metadata index for every stored file.
+// CASPathTransformFunc is one way, so this is the only place where the
+// original key of a blob can be recovered from.
+type FileMeta struct {
+	ID         string    `json:"id"` // ID of the owner of the file.
+	Key        string    `json:"key"`
+	Size       int64     `json:"size"`
+	Digest     string    `json:"digest"` // Hex encoded sha256 of the bytes on disk.
+	CreatedAt  time.Time `json:"created_at"`
+	ModifiedAt time.Time `json:"modified_at"`
+	Replicas   []string  `json:"replicas,omitempty"` // Addresses of the peers holding a copy.
+	// The blob is encrypted with a data key of its own, stored wrapped with
+	// the key KeyID of the owner. Both are empty for plain blobs.
+	KeyID      string `json:"key_id,omitempty"`
+	WrappedKey []byte `json:"wrapped_key,omitempty"`
+	// Hex encoded sha256 of the plain text of an encrypted blob, when the
+	// node which encrypted it recorded it.
+	PlainDigest string `json:"plain_digest,omitempty"`
+	// sha256 of every ChunkSize bytes on disk, to check the chunks fetched
+	// from several peers at once.
+	ChunkDigests [][]byte `json:"chunk_digests,omitempty"`
+	// Signature of the owner over Digest, nil for the versions written
+	// before they were signed.
+	Signature *auth.VersionSignature `json:"signature,omitempty"`
+	// Every write of the key is a version of its own, see NewVersionID.
+	VersionID string `json:"version_id,omitempty"`
+	// Writes the version has seen, and when it was written. A version not
+	// superseding the latest one is one of its siblings, see ConflictPolicy.
+	Clock     VersionVector `json:"clock,omitempty"`
+	Timestamp Timestamp     `json:"timestamp"`
+	Siblings  []string      `json:"siblings,omitempty"` // Version IDs, on the latest version only.
+}
+
+// metaIndex is an embedded bbolt database, one nested bucket per owner ID
+// with the file key as the bucket key.
+type metaIndex struct {
+	db *bolt.DB
+	// Counts the writes made through this node in the version vectors.
+	replicaID string
+	clock     hlc
+}
+
+// openMetaIndex opens the index at path, blobPath locates the blobs to move
+// when the index predates the versions.
+func openMetaIndex(path string, blobPath func(id string, key string, versionID string) string) (*metaIndex, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	m := &metaIndex{db: db}
+	err = db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if m.replicaID, err = replicaID(tx); err != nil {
+			return err
+		}
+		for _, name := range [][]byte{filesBucket, tombstonesBucket, grantsBucket, dirsBucket, partialsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(versionsBucket) == nil {
+			if _, err := tx.CreateBucket(versionsBucket); err != nil {
+				return err
+			}
+			if err := migrateVersions(tx, blobPath); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(usageBucket) != nil {
+			return nil
+		}
+		if _, err := tx.CreateBucket(usageBucket); err != nil {
+			return err
+		}
+		return rebuildUsage(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *metaIndex) close() error {
+	return m.db.Close()
+}
+
+func ownerBucket(tx *bolt.Tx, id string, create bool) (*bolt.Bucket, error) {
+	files := tx.Bucket(filesBucket)
+	if create {
+		return files.CreateBucketIfNotExists([]byte(id))
+	}
+	return files.Bucket([]byte(id)), nil
+}
+
+func getMeta(tx *bolt.Tx, id string, key string) (*FileMeta, error) {
+	b, _ := ownerBucket(tx, id, false)
+	if b == nil {
+		return nil, ErrNoMeta
+	}
+	v := b.Get([]byte(key))
+	if v == nil {
+		return nil, ErrNoMeta
+	}
+	meta := &FileMeta{}
+	if err := json.Unmarshal(v, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func putMeta(tx *bolt.Tx, meta *FileMeta) error {
+	b, err := ownerBucket(tx, meta.ID, true)
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(meta.Key), v)
+}
+
+func deleteMeta(tx *bolt.Tx, id string, key string) error {
+	b, _ := ownerBucket(tx, id, false)
+	if b == nil {
+		return nil
+	}
+	return b.Delete([]byte(key))
+}
+
+func (m *metaIndex) get(id string, key string) (*FileMeta, error) {
+	var meta *FileMeta
+	err := m.db.View(func(tx *bolt.Tx) error {
+		var err error
+		meta, err = getMeta(tx, id, key)
+		return err
+	})
+	return meta, err
+}
+
+// list returns every record of the owner whose key starts with prefix,
+// sorted by key.
+func (m *metaIndex) list(id string, prefix string) ([]FileMeta, error) {
+	metas := []FileMeta{}
+	err := m.db.View(func(tx *bolt.Tx) error {
+		b, _ := ownerBucket(tx, id, false)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = c.Next() {
+			meta := FileMeta{}
+			if err := json.Unmarshal(v, &meta); err != nil {
+				return err
+			}
+			metas = append(metas, meta)
+		}
+		return nil
+	})
+	return metas, err
+}
+
+// delete drops the records of the file and of all its versions, which are
+// returned so that their blobs can be removed.
+func (m *metaIndex) delete(id string, key string) ([]FileMeta, error) {
+	var versions []FileMeta
+	err := m.db.Update(func(tx *bolt.Tx) error {
+		if err := deleteGrants(tx, id, key); err != nil {
+			return err
+		}
+		var err error
+		if versions, err = deleteVersions(tx, id, key); err != nil {
+			return err
+		}
+		return deleteMeta(tx, id, key)
+	})
+	return versions, err
+}
+
+func (m *metaIndex) addReplicas(id string, key string, addrs ...string) error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		meta, err := getMeta(tx, id, key)
+		if err != nil {
+			return err
+		}
+		meta.Replicas = mergeAddrs(meta.Replicas, addrs)
+		return putMeta(tx, meta)
+	})
+}
+
+// updateVersion applies update to a version of the key, and to the latest
+// version when it is the one.
+func (m *metaIndex) updateVersion(id string, key string, versionID string, update func(*FileMeta)) error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		version, err := getVersion(tx, id, key, versionID)
+		if err != nil {
+			return err
+		}
+		update(version)
+		if err := putVersion(tx, version); err != nil {
+			return err
+		}
+		meta, err := getMeta(tx, id, key)
+		if err != nil || meta.VersionID != versionID {
+			return nil
+		}
+		update(meta)
+		return putMeta(tx, meta)
+	})
+}
+
+// commitBlob records the version meta and moves the fully written temporary
+// blob into place inside a single transaction, so the index never points at
+// a partially written file. A failed rename rolls the record back, as does a
+// blob going over the quotas. With latest, the version becomes the latest
+// one of the key unless a version which has seen it is already there, see
+// resolve. A version without a clock is a write made through this node,
+// following all the versions it knows.
+func (m *metaIndex) commitBlob(meta *FileMeta, tmpPath string, fullPath string, opts StoreOpts, latest bool) error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		delta := meta.Size
+		if prev, err := getVersion(tx, meta.ID, meta.Key, meta.VersionID); err == nil {
+			delta -= prev.Size // The same version written again.
+		}
+		if err := checkQuota(tx, opts.Quotas, meta.ID, delta); err != nil {
+			return err
+		}
+		hs, err := heads(tx, meta.ID, meta.Key)
+		if err != nil {
+			return err
+		}
+		if len(hs) > 0 {
+			meta.CreatedAt = hs[0].CreatedAt
+		}
+		switch {
+		case meta.Clock == nil && latest:
+			clock := VersionVector{}
+			for _, h := range hs {
+				clock = clock.Merge(h.Clock)
+			}
+			meta.Clock = clock.increment(m.replicaID)
+			meta.Timestamp = m.clock.now()
+		case meta.Clock != nil:
+			m.clock.update(meta.Timestamp)
+		}
+		if latest {
+			if _, err := resolve(tx, meta, hs, opts.ConflictPolicy); err != nil {
+				return err
+			}
+		}
+		if err := putVersion(tx, meta); err != nil {
+			return err
+		}
+		if err := addUsage(tx, meta.ID, delta); err != nil {
+			return err
+		}
+		if err := deletePartial(tx, meta.ID, meta.Key, meta.VersionID); err != nil {
+			return err
+		}
+		return os.Rename(tmpPath, fullPath)
+	})
+}
+
+// mergeAddrs returns the sorted set of the addresses of have and add.
+func mergeAddrs(have []string, add []string) []string {
+	set := make(map[string]struct{}, len(have)+len(add))
+	for _, addr := range append(have, add...) {
+		set[addr] = struct{}{}
+	}
+	merged := make([]string, 0, len(set))
+	for addr := range set {
+		merged = append(merged, addr)
+	}
+	sort.Strings(merged)
+	return merged
+}
@@ -0,0 +1,278 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// Directories made with Mkdir, the other ones only exist through the
+	// keys of the files in them.
+	dirsBucket = []byte("dirs")
+
+	// ErrInvalidKey is returned for a key which does not name a file.
+	ErrInvalidKey = errors.New("store: invalid key")
+	// ErrNotDir is returned when a file stands where a directory is needed.
+	ErrNotDir = errors.New("store: not a directory")
+	// ErrIsDir is returned when a directory stands where a file is needed.
+	ErrIsDir = errors.New("store: is a directory")
+	// ErrExist is returned when renaming over an existing file.
+	ErrExist = errors.New("store: file exists")
+)
+
+// Keys are slash separated paths, without a leading slash so the keys
+// written before the directories live at the root.
+//
+// CleanKey returns the canonical form of the path of a file, with the
+// duplicate slashes and the . and .. elements left out.
+func CleanKey(key string) (string, error) {
+	clean := strings.TrimPrefix(path.Clean("/"+key), "/")
+	if len(clean) == 0 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return clean, nil
+}
+
+// CleanDir is CleanKey for a directory, the root being the empty string.
+func CleanDir(dir string) string {
+	return strings.TrimPrefix(path.Clean("/"+dir), "/")
+}
+
+// DirPrefix returns the prefix of the keys in the directory.
+func DirPrefix(dir string) string {
+	if len(dir) == 0 {
+		return ""
+	}
+	return dir + "/"
+}
+
+// parentDirs returns the directories leading to the key, outermost first.
+func parentDirs(key string) []string {
+	dirs := []string{}
+	for i, c := range key {
+		if c == '/' {
+			dirs = append(dirs, key[:i])
+		}
+	}
+	return dirs
+}
+
+type dirMeta struct {
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func putDir(tx *bolt.Tx, id string, dir string) error {
+	if _, err := getMeta(tx, id, dir); err == nil {
+		return fmt.Errorf("%w: %s", ErrNotDir, dir)
+	}
+	b, err := tx.Bucket(dirsBucket).CreateBucketIfNotExists([]byte(id))
+	if err != nil {
+		return err
+	}
+	if b.Get([]byte(dir)) != nil {
+		return nil
+	}
+	v, err := json.Marshal(dirMeta{CreatedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(dir), v)
+}
+
+// Mkdir records the directory along with its parents, the ones already there
+// are left as they are.
+func (s *Store) Mkdir(id string, dir string) error {
+	idx, err := s.index()
+	if err != nil {
+		return err
+	}
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		for _, d := range append(parentDirs(dir), dir) {
+			if err := putDir(tx, id, d); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Dirs returns the directories made with Mkdir whose path starts with
+// prefix, sorted.
+func (s *Store) Dirs(id string, prefix string) ([]string, error) {
+	idx, err := s.index()
+	if err != nil {
+		return nil, err
+	}
+	dirs := []string{}
+	err = idx.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(dirsBucket).Bucket([]byte(id))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, _ := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = c.Next() {
+			dirs = append(dirs, string(k))
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// IsDir reports whether the directory was made with Mkdir or holds files of
+// the owner.
+func (s *Store) IsDir(id string, dir string) bool {
+	if len(dir) == 0 {
+		return true
+	}
+	if dirs, err := s.Dirs(id, dir); err == nil && len(dirs) > 0 && dirs[0] == dir {
+		return true
+	}
+	files, err := s.List(id, DirPrefix(dir))
+	return err == nil && len(files) > 0
+}
+
+// CheckFile reports whether a file of the owner can be written at key: key
+// is not a directory and none of the directories leading to it is a file.
+func (s *Store) CheckFile(id string, key string) error {
+	if s.IsDir(id, key) {
+		return fmt.Errorf("%w: %s", ErrIsDir, key)
+	}
+	for _, dir := range parentDirs(key) {
+		if _, err := s.Stat(id, dir); err == nil {
+			return fmt.Errorf("%w: %s", ErrNotDir, dir)
+		}
+	}
+	return nil
+}
+
+// RemoveDirs forgets the directory made with Mkdir and the ones below it.
+func (s *Store) RemoveDirs(id string, dir string) error {
+	idx, err := s.index()
+	if err != nil {
+		return err
+	}
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(dirsBucket).Bucket([]byte(id))
+		if b == nil {
+			return nil
+		}
+		if err := b.Delete([]byte(dir)); err != nil {
+			return err
+		}
+		prefix := []byte(DirPrefix(dir))
+		c := b.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Rename moves the file src to dst along with all its versions, which keep
+// their IDs. The grants of src are dropped: they were issued for its key.
+func (s *Store) Rename(id string, src string, dst string) error {
+	idx, err := s.index()
+	if err != nil {
+		return err
+	}
+	// The blobs are moved back when the records could not be.
+	moved := [][2]string{}
+	err = idx.db.Update(func(tx *bolt.Tx) error {
+		latest, err := getMeta(tx, id, src)
+		if err != nil {
+			return err
+		}
+		if _, err := getMeta(tx, id, dst); err == nil {
+			return fmt.Errorf("%w: %s", ErrExist, dst)
+		}
+		for _, dir := range parentDirs(dst) {
+			if _, err := getMeta(tx, id, dir); err == nil {
+				return fmt.Errorf("%w: %s", ErrNotDir, dir)
+			}
+		}
+		versions, err := keyVersions(tx, id, src)
+		if err != nil {
+			return err
+		}
+		vb := tx.Bucket(versionsBucket).Bucket([]byte(id))
+		for _, v := range versions {
+			from, to := s.blobPath(id, src, v.VersionID), s.blobPath(id, dst, v.VersionID)
+			if err := os.MkdirAll(filepath.Dir(to), os.ModePerm); err != nil {
+				return err
+			}
+			if err := os.Rename(from, to); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			moved = append(moved, [2]string{from, to})
+			if err := vb.Delete(versionKey(src, v.VersionID)); err != nil {
+				return err
+			}
+			v.Key = dst
+			if err := putVersion(tx, &v); err != nil {
+				return err
+			}
+		}
+
+		// The replicas hold src, the file has to be replicated again.
+		latest.Key, latest.Replicas = dst, nil
+		latest.ModifiedAt = time.Now().UTC()
+		if err := deleteMeta(tx, id, src); err != nil {
+			return err
+		}
+		if err := deleteGrants(tx, id, src); err != nil {
+			return err
+		}
+		if err := deleteTombstone(tx, id, dst); err != nil {
+			return err
+		}
+		return putMeta(tx, latest)
+	})
+	if err != nil {
+		for _, m := range moved {
+			os.Rename(m[1], m[0])
+		}
+		return err
+	}
+	s.pruneDirs(id, s.PathTransformFunc(src).PathName)
+	return nil
+}
+
+// RenameDirs moves the directories made with Mkdir from under src to dst.
+func (s *Store) RenameDirs(id string, src string, dst string) error {
+	dirs, err := s.Dirs(id, src)
+	if err != nil {
+		return err
+	}
+	idx, err := s.index()
+	if err != nil {
+		return err
+	}
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		for _, dir := range dirs {
+			if dir != src && !strings.HasPrefix(dir, DirPrefix(src)) {
+				continue // Shares the prefix only, eg- src2 for src.
+			}
+			if err := tx.Bucket(dirsBucket).Bucket([]byte(id)).Delete([]byte(dir)); err != nil {
+				return err
+			}
+			moved := dst + strings.TrimPrefix(dir, src)
+			for _, d := range append(parentDirs(moved), moved) {
+				if err := putDir(tx, id, d); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,188 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The versions received from the peers are written to a partial blob next to
+// their final location, which is kept when the transfer breaks off so that it
+// resumes where it stopped rather than from the start.
+var partialsBucket = []byte("partials")
+
+// ErrNoPartial is returned when resuming a transfer past the bytes held.
+var ErrNoPartial = errors.New("store: partial blob too short")
+
+// Partial is a version of a file partly received from a peer.
+type Partial struct {
+	ID        string    `json:"id"`
+	Key       string    `json:"key"`
+	VersionID string    `json:"version_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func putPartial(tx *bolt.Tx, p *Partial) error {
+	b, err := tx.Bucket(partialsBucket).CreateBucketIfNotExists([]byte(p.ID))
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return b.Put(versionKey(p.Key, p.VersionID), v)
+}
+
+func deletePartial(tx *bolt.Tx, id string, key string, versionID string) error {
+	b := tx.Bucket(partialsBucket).Bucket([]byte(id))
+	if b == nil {
+		return nil
+	}
+	return b.Delete(versionKey(key, versionID))
+}
+
+// partials returns the partial blobs of every owner.
+func partials(tx *bolt.Tx) ([]Partial, error) {
+	all := []Partial{}
+	err := tx.Bucket(partialsBucket).ForEach(func(id []byte, _ []byte) error {
+		return tx.Bucket(partialsBucket).Bucket(id).ForEach(func(_ []byte, v []byte) error {
+			p := Partial{}
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			all = append(all, p)
+			return nil
+		})
+	})
+	return all, err
+}
+
+func (s *Store) partialPath(id string, key string, versionID string) string {
+	return s.blobPath(id, key, versionID) + ".part"
+}
+
+// PartialSize returns the bytes of the version received before its transfer
+// broke off, zero when there are none.
+func (s *Store) PartialSize(id string, key string, versionID string) int64 {
+	fi, err := os.Stat(s.partialPath(id, key, versionID))
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// Partials returns the versions of the files of the owner partly received.
+func (s *Store) Partials(id string) ([]Partial, error) {
+	idx, err := s.index()
+	if err != nil {
+		return nil, err
+	}
+	owned := []Partial{}
+	err = idx.db.View(func(tx *bolt.Tx) error {
+		all, err := partials(tx)
+		for _, p := range all {
+			if p.ID == id {
+				owned = append(owned, p)
+			}
+		}
+		return err
+	})
+	return owned, err
+}
+
+// PurgePartials removes the partial blobs not written to since before, and
+// returns how many were removed.
+func (s *Store) PurgePartials(before time.Time) (int, error) {
+	return s.removePartials(func(p Partial) bool { return p.UpdatedAt.Before(before) })
+}
+
+func (s *Store) removePartials(match func(Partial) bool) (int, error) {
+	idx, err := s.index()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	err = idx.db.Update(func(tx *bolt.Tx) error {
+		all, err := partials(tx)
+		if err != nil {
+			return err
+		}
+		for _, p := range all {
+			if !match(p) {
+				continue
+			}
+			if err := os.Remove(s.partialPath(p.ID, p.Key, p.VersionID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			if err := deletePartial(tx, p.ID, p.Key, p.VersionID); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// openPartial opens the partial blob of the version for writing at offset,
+// the bytes before it are written to hash.
+func (s *Store) openPartial(idx *metaIndex, meta *FileMeta, offset int64, hash io.Writer) (*os.File, error) {
+	p := s.partialPath(meta.ID, meta.Key, meta.VersionID)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = func() error {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if fi.Size() < offset {
+			return fmt.Errorf("%w: %d bytes of %s held, not %d", ErrNoPartial, fi.Size(), meta.Key, offset)
+		}
+		if err := f.Truncate(offset); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(hash, f, offset); err != nil {
+			return err
+		}
+		return idx.db.Update(func(tx *bolt.Tx) error {
+			return putPartial(tx, &Partial{ID: meta.ID, Key: meta.Key, VersionID: meta.VersionID, UpdatedAt: time.Now().UTC()})
+		})
+	}()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// lockPartial keeps two transfers of the same version from writing to its
+// partial blob at once.
+func (s *Store) lockPartial(path string) (unlock func(), err error) {
+	s.partsLock.Lock()
+	defer s.partsLock.Unlock()
+
+	if s.parts == nil {
+		s.parts = make(map[string]bool)
+	}
+	if s.parts[path] {
+		return nil, fmt.Errorf("store: %s is being received already", filepath.Base(path))
+	}
+	s.parts[path] = true
+	return func() {
+		s.partsLock.Lock()
+		defer s.partsLock.Unlock()
+		delete(s.parts, path)
+	}, nil
+}
@@ -0,0 +1,187 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	usageBucket = []byte("usage")
+
+	// ErrQuotaExceeded is returned for a write which would take its owner or
+	// the node over the quota.
+	ErrQuotaExceeded = errors.New("store: quota exceeded")
+)
+
+// Quotas limit the bytes kept on disk, zero means no limit.
+type Quotas struct {
+	Node  int64 // All the owners together, replicas held for other nodes included.
+	Owner int64 // Every owner ID not listed in Owners.
+	// Quotas of single owner IDs, replacing Owner.
+	Owners map[string]int64
+	// Free space kept on the disk, the writes going below are refused.
+	MinFree int64
+}
+
+// OwnerLimit returns the quota of the owner ID.
+func (q Quotas) OwnerLimit(id string) int64 {
+	if limit, ok := q.Owners[id]; ok {
+		return limit
+	}
+	return q.Owner
+}
+
+// The usage bucket holds the bytes on disk of every owner ID, kept up to
+// date by commitBlob and Delete.
+func getUsage(tx *bolt.Tx, id string) int64 {
+	v := tx.Bucket(usageBucket).Get([]byte(id))
+	if len(v) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(v))
+}
+
+func addUsage(tx *bolt.Tx, id string, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	b := tx.Bucket(usageBucket)
+	used := getUsage(tx, id) + delta
+	if used <= 0 {
+		return b.Delete([]byte(id))
+	}
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(used))
+	return b.Put([]byte(id), v)
+}
+
+func nodeUsage(tx *bolt.Tx) int64 {
+	var used int64
+	tx.Bucket(usageBucket).ForEach(func(_, v []byte) error {
+		if len(v) == 8 {
+			used += int64(binary.BigEndian.Uint64(v))
+		}
+		return nil
+	})
+	return used
+}
+
+// room returns how many bytes a new blob of the owner ID can take, -1 when
+// there is no limit.
+func room(tx *bolt.Tx, q Quotas, id string) int64 {
+	left := int64(-1)
+	if limit := q.OwnerLimit(id); limit > 0 {
+		left = max(limit-getUsage(tx, id), 0)
+	}
+	if q.Node > 0 {
+		nodeLeft := max(q.Node-nodeUsage(tx), 0)
+		if left < 0 || nodeLeft < left {
+			left = nodeLeft
+		}
+	}
+	return left
+}
+
+// checkQuota returns ErrQuotaExceeded when the owner ID growing by delta
+// bytes goes over its quota or the one of the node.
+func checkQuota(tx *bolt.Tx, q Quotas, id string, delta int64) error {
+	if delta <= 0 {
+		return nil
+	}
+	if limit := q.OwnerLimit(id); limit > 0 {
+		if used := getUsage(tx, id) + delta; used > limit {
+			return fmt.Errorf("%w: %s would use %d of its %d bytes", ErrQuotaExceeded, id, used, limit)
+		}
+	}
+	if q.Node > 0 {
+		if used := nodeUsage(tx) + delta; used > q.Node {
+			return fmt.Errorf("%w: the node would use %d of its %d bytes", ErrQuotaExceeded, used, q.Node)
+		}
+	}
+	return nil
+}
+
+// rebuildUsage sums up the sizes of the versions, for the indexes written
+// before the usage was tracked.
+func rebuildUsage(tx *bolt.Tx) error {
+	versions := tx.Bucket(versionsBucket)
+	return versions.ForEach(func(id, _ []byte) error {
+		return versions.Bucket(id).ForEach(func(_, v []byte) error {
+			meta := FileMeta{}
+			if err := json.Unmarshal(v, &meta); err != nil {
+				return err
+			}
+			return addUsage(tx, string(id), meta.Size)
+		})
+	})
+}
+
+// CheckQuota returns ErrQuotaExceeded when writing a version of size bytes
+// for the owner ID would go over the quotas. The previous versions are kept,
+// so they still count.
+func (s *Store) CheckQuota(id string, size int64) error {
+	idx, err := s.index()
+	if err != nil {
+		return err
+	}
+	return idx.db.View(func(tx *bolt.Tx) error {
+		return checkQuota(tx, s.Quotas, id, size)
+	})
+}
+
+// room is the room left for a new blob of the owner ID, along with the error
+// of the limit it hits first. It is -1 when there is no limit or it cannot be
+// read: commitBlob checks the quotas again anyway.
+func (s *Store) room(id string) (int64, error) {
+	left, limit := int64(-1), ErrQuotaExceeded
+	if s.Quotas.Node > 0 || s.Quotas.OwnerLimit(id) > 0 {
+		if idx, err := s.index(); err == nil {
+			idx.db.View(func(tx *bolt.Tx) error {
+				left = room(tx, s.Quotas, id)
+				return nil
+			})
+		}
+	}
+	if disk := s.diskRoom(); disk >= 0 && (left < 0 || disk < left) {
+		left, limit = disk, ErrDiskFull
+	}
+	return left, limit
+}
+
+// Usage returns the bytes stored on disk for every owner ID, replicas held
+// for other nodes included.
+func (s *Store) Usage() (map[string]int64, error) {
+	idx, err := s.index()
+	if err != nil {
+		return nil, err
+	}
+	usage := map[string]int64{}
+	err = idx.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usageBucket).ForEach(func(id, _ []byte) error {
+			usage[string(id)] = getUsage(tx, string(id))
+			return nil
+		})
+	})
+	return usage, err
+}
+
+// roomWriter fails a write of unknown size as soon as it goes over the room
+// left, instead of once the whole blob is on disk.
+type roomWriter struct {
+	w     io.Writer
+	left  int64
+	limit error
+}
+
+func (r *roomWriter) Write(b []byte) (int, error) {
+	if int64(len(b)) > r.left {
+		return 0, fmt.Errorf("%w: the blob does not fit", r.limit)
+	}
+	r.left -= int64(len(b))
+	return r.w.Write(b)
+}
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrDiskFull is returned for a write which would leave less free space on
+// the disk than the watermark.
+var ErrDiskFull = errors.New("store: disk full")
+
+// DiskSpace describes the disk the store lives on.
+type DiskSpace struct {
+	Capacity int64
+	Free     int64 // Available to the user running the node.
+}
+
+// DiskSpace returns the size and the free space of the disk of the root
+// folder, errors.ErrUnsupported on the platforms it cannot be read on.
+func (s *Store) DiskSpace() (DiskSpace, error) {
+	if err := os.MkdirAll(s.Root, os.ModePerm); err != nil {
+		return DiskSpace{}, err
+	}
+	return diskSpace(s.Root)
+}
+
+// CheckSpace returns ErrDiskFull when writing size more bytes would leave
+// less than MinFree on the disk. Nothing is refused when the free space
+// cannot be read.
+func (s *Store) CheckSpace(size int64) error {
+	if s.Quotas.MinFree <= 0 {
+		return nil
+	}
+	space, err := s.DiskSpace()
+	if err != nil {
+		return nil
+	}
+	if space.Free-size < s.Quotas.MinFree {
+		return fmt.Errorf("%w: %d bytes free, %d are kept free", ErrDiskFull, space.Free, s.Quotas.MinFree)
+	}
+	return nil
+}
+
+// diskRoom returns how many bytes can be written before going below MinFree,
+// -1 when there is no watermark or the free space cannot be read.
+func (s *Store) diskRoom() int64 {
+	if s.Quotas.MinFree <= 0 {
+		return -1
+	}
+	space, err := s.DiskSpace()
+	if err != nil {
+		return -1
+	}
+	return max(space.Free-s.Quotas.MinFree, 0)
+}
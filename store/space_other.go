@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !windows
+
+package store
+
+import "errors"
+
+func diskSpace(path string) (DiskSpace, error) {
+	return DiskSpace{}, errors.ErrUnsupported
+}
@@ -0,0 +1,16 @@
+//go:build linux || darwin
+
+package store
+
+import "syscall"
+
+func diskSpace(path string) (DiskSpace, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return DiskSpace{}, err
+	}
+	return DiskSpace{
+		Capacity: int64(st.Blocks) * int64(st.Bsize),
+		Free:     int64(st.Bavail) * int64(st.Bsize),
+	}, nil
+}
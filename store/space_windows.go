@@ -0,0 +1,17 @@
+//go:build windows
+
+package store
+
+import "golang.org/x/sys/windows"
+
+func diskSpace(path string) (DiskSpace, error) {
+	dir, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return DiskSpace{}, err
+	}
+	var free, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(dir, &free, &total, &totalFree); err != nil {
+		return DiskSpace{}, err
+	}
+	return DiskSpace{Capacity: int64(total), Free: int64(free)}, nil
+}
@@ -1,12 +1,17 @@
 package store
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
+	"github.com/ranjankuldeep/distributed_file_system/auth"
 	"github.com/ranjankuldeep/distributed_file_system/encrypt"
 	"github.com/ranjankuldeep/distributed_file_system/logs"
 )
@@ -16,6 +21,13 @@ const defaultRootFolderName = "ggnetwork"
 // It represents the abstraction of hasing or fetching ivolved.
 type Store struct {
 	StoreOpts
+
+	metaLock sync.Mutex
+	meta     *metaIndex
+
+	// Partial blobs being written, see lockPartial.
+	partsLock sync.Mutex
+	parts     map[string]bool
 }
 
 func NewStore(opts StoreOpts) *Store {
@@ -35,80 +47,330 @@ type StoreOpts struct {
 	// Root is the folder name of the root, containing all folders/files of the system.
 	Root              string
 	PathTransformFunc PathTransformFunc
+	// Quotas the writes are checked against, none when zero.
+	Quotas Quotas
+	// Settles the concurrent versions of a key, ConflictLWW when empty.
+	ConflictPolicy ConflictPolicy
 }
 
 type PathTransformFunc func(string) PathKey
 
+// index lazily opens the metadata database living under the root folder.
+func (s *Store) index() (*metaIndex, error) {
+	s.metaLock.Lock()
+	defer s.metaLock.Unlock()
+
+	if s.meta != nil {
+		return s.meta, nil
+	}
+	if err := os.MkdirAll(s.Root, os.ModePerm); err != nil {
+		return nil, err
+	}
+	meta, err := openMetaIndex(filepath.Join(s.Root, metaFileName), s.blobPath)
+	if err != nil {
+		return nil, err
+	}
+	s.meta = meta
+	return meta, nil
+}
+
+// Close releases the metadata database.
+func (s *Store) Close() error {
+	s.metaLock.Lock()
+	defer s.metaLock.Unlock()
+
+	if s.meta == nil {
+		return nil
+	}
+	err := s.meta.close()
+	s.meta = nil
+	return err
+}
+
 func (s *Store) Clear() error {
+	if err := s.Close(); err != nil {
+		return err
+	}
 	return os.RemoveAll(s.Root)
 }
+
+// Stat returns the metadata recorded for the key.
+func (s *Store) Stat(id string, key string) (*FileMeta, error) {
+	idx, err := s.index()
+	if err != nil {
+		return nil, err
+	}
+	return idx.get(id, key)
+}
+
+// List returns the metadata of all the files of the owner whose key starts with prefix.
+func (s *Store) List(id string, prefix string) ([]FileMeta, error) {
+	idx, err := s.index()
+	if err != nil {
+		return nil, err
+	}
+	return idx.list(id, prefix)
+}
+
+// AddReplicas records the addresses of the peers holding a copy of the file.
+func (s *Store) AddReplicas(id string, key string, addrs ...string) error {
+	idx, err := s.index()
+	if err != nil {
+		return err
+	}
+	return idx.addReplicas(id, key, addrs...)
+}
+
+// SetWrappedKey replaces the wrapped data key of a version of the blob, when
+// the owner rewraps it with another of its keys.
+func (s *Store) SetWrappedKey(id string, key string, versionID string, keyID string, wrappedKey []byte) error {
+	idx, err := s.index()
+	if err != nil {
+		return err
+	}
+	return idx.updateVersion(id, key, versionID, func(meta *FileMeta) {
+		meta.KeyID, meta.WrappedKey = keyID, wrappedKey
+	})
+}
+
+// SetPlainDigest records the digest of the plain text of a version of the
+// blob, known once the node which encrypted it wrote it.
+func (s *Store) SetPlainDigest(id string, key string, versionID string, digest string) error {
+	idx, err := s.index()
+	if err != nil {
+		return err
+	}
+	return idx.updateVersion(id, key, versionID, func(meta *FileMeta) {
+		meta.PlainDigest = digest
+	})
+}
+
+// SetSignature records the signature of the owner over the digest of a
+// version.
+func (s *Store) SetSignature(id string, key string, versionID string, sig *auth.VersionSignature) error {
+	idx, err := s.index()
+	if err != nil {
+		return err
+	}
+	return idx.updateVersion(id, key, versionID, func(meta *FileMeta) {
+		meta.Signature = sig
+	})
+}
+
+// blobPath is where the blob of a version of the key lives, next to the
+// other versions of the key.
+func (s *Store) blobPath(id string, key string, versionID string) string {
+	fullPath := s.PathTransformFunc(key).FullPath()
+	if len(versionID) > 0 {
+		fullPath += "." + versionID
+	}
+	return fmt.Sprintf("%s/%s/%s", s.Root, id, fullPath)
+}
+
+// Has reports whether the blob of the latest version of the file is on disk.
 func (s *Store) Has(id string, key string) bool {
-	pathKey := s.PathTransformFunc(key)
-	fullPathWithRoot := fmt.Sprintf("%s/%s/%s", s.Root, id, pathKey.FullPath())
-	_, err := os.Stat(fullPathWithRoot)
-	return !errors.Is(err, os.ErrNotExist)
+	meta, err := s.Stat(id, key)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(s.blobPath(id, key, meta.VersionID))
+	return err == nil
 }
 
+// Delete removes the file along with all its versions.
 func (s *Store) Delete(id string, key string) error {
 	pathKey := s.PathTransformFunc(key)
 	defer func() {
-		log.Printf("deleted [%s] from disk", pathKey.Filename)
+		logs.Logger.Infof("deleted [%s] from disk", pathKey.Filename)
 	}()
-	firstPathNameWithRoot := fmt.Sprintf("%s/%s/%s", s.Root, id, pathKey.FirstPathName())
-	if err := os.RemoveAll(firstPathNameWithRoot); err != nil {
-		logs.Logger.Errorf("Error removing file")
-		if pathError, ok := err.(*os.PathError); ok {
+	idx, err := s.index()
+	if err != nil {
+		return err
+	}
+	versions, err := idx.delete(id, key)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if err := os.Remove(s.blobPath(id, key, v.VersionID)); err != nil && !errors.Is(err, os.ErrNotExist) {
 			logs.Logger.Errorf("Error removing file")
-			return pathError
+			return err
 		}
 	}
+	if _, err := s.removePartials(func(p Partial) bool { return p.ID == id && p.Key == key }); err != nil {
+		return err
+	}
+	// Only the emptied directories are pruned, other keys may share the leading
+	// path segments of the hashed key.
+	s.pruneDirs(id, pathKey.PathName)
 	return nil
 }
 
+func (s *Store) pruneDirs(id string, pathName string) {
+	ownerRoot := fmt.Sprintf("%s/%s", s.Root, id)
+	for dir := filepath.Join(ownerRoot, pathName); dir != ownerRoot && dir != "."; dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
+
 // Returns data size written locally and an error.
 func (s *Store) Write(id string, key string, r io.Reader) (int64, error) {
 	return s.writeStream(id, key, r)
 }
 
-func (s *Store) WriteDecrypt(encKey []byte, id string, key string, r io.Reader) (int64, error) {
-	f, err := s.openFileForWriting(id, key)
+// WriteSealed writes the latest version of a blob encrypted with a data key
+// and returns its metadata. The ID, Key, KeyID and WrappedKey of tmpl are
+// recorded with it, along with its VersionID, Clock and Timestamp when it is
+// a version written through another node.
+func (s *Store) WriteSealed(tmpl FileMeta, r io.Reader) (*FileMeta, error) {
+	return s.WriteSealedAt(tmpl, 0, r)
+}
+
+// WriteSealedAt is WriteSealed resuming a transfer, r holding the bytes of
+// the blob from offset on. The version received from a peer is kept partly
+// written when r fails, PartialSize tells where to resume it. When tmpl has
+// a Digest, the whole blob must match it to be committed, it is removed
+// with ErrBlobDigest otherwise.
+func (s *Store) WriteSealedAt(tmpl FileMeta, offset int64, r io.Reader) (*FileMeta, error) {
+	err := s.writeBlob(&tmpl, true, offset, func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	n, err := encrypt.CopyDecrypt(encKey, r, f)
+	return &tmpl, nil
+}
+
+func (s *Store) WriteDecrypt(encKey []byte, id string, key string, r io.Reader) (int64, error) {
+	var n int
+	err := s.writeBlob(&FileMeta{ID: id, Key: key}, true, 0, func(w io.Writer) error {
+		var err error
+		n, err = encrypt.CopyDecrypt(encKey, r, w)
+		return err
+	})
 	return int64(n), err
 }
 
 func (s *Store) writeStream(id string, key string, r io.Reader) (int64, error) {
-	logs.Logger.Info(key)
-	f, err := s.openFileForWriting(id, key)
+	logs.Logger.Debugf("writing %s", key)
+	var n int64
+	err := s.writeBlob(&FileMeta{ID: id, Key: key}, true, 0, func(w io.Writer) error {
+		var err error
+		n, err = io.Copy(w, r)
+		return err
+	})
+	return n, err
+}
+
+// writeBlob writes the blob into a temporary file next to its final location,
+// hashing it and its chunks on the way, and only then commits it together with meta, as a
+// new version unless meta has one already. A version written elsewhere goes
+// to its partial blob instead, from offset on, and is checked against the
+// digest of meta if any.
+func (s *Store) writeBlob(meta *FileMeta, latest bool, offset int64, write func(io.Writer) error) error {
+	id, key, want := meta.ID, meta.Key, meta.Digest
+	idx, err := s.index()
 	if err != nil {
-		return 0, err
+		return err
+	}
+	hash := sha256.New()
+	chunks := newChunkHasher()
+	sums := io.MultiWriter(hash, chunks)
+	var f *os.File
+	partial := len(meta.VersionID) > 0
+	if partial {
+		unlock, err := s.lockPartial(s.partialPath(id, key, meta.VersionID))
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		if f, err = s.openPartial(idx, meta, offset, sums); err != nil {
+			return err
+		}
+	} else {
+		if offset > 0 {
+			return fmt.Errorf("%w: no version of %s to resume", ErrNoPartial, key)
+		}
+		if f, err = s.openFileForWriting(id, key); err != nil {
+			return err
+		}
+		defer os.Remove(f.Name()) // No-op once the file has been renamed.
+	}
+
+	cw := &countWriter{w: io.MultiWriter(f, sums), n: offset}
+	var w io.Writer = cw
+	if left, limit := s.room(id); left >= 0 {
+		w = &roomWriter{w: cw, left: left - offset, limit: limit}
+	}
+	if err := write(w); err != nil {
+		f.Sync() // What was received is kept for the next attempt.
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
 	}
-	return io.Copy(f, r)
+
+	now := time.Now().UTC()
+	meta.Size = cw.n
+	meta.Digest = hex.EncodeToString(hash.Sum(nil))
+	if partial && len(want) > 0 && meta.Digest != want {
+		s.removePartials(func(p Partial) bool { return p.ID == id && p.Key == key && p.VersionID == meta.VersionID })
+		return fmt.Errorf("%w: %s", ErrBlobDigest, key)
+	}
+	meta.ChunkDigests = chunks.Sum()
+	meta.CreatedAt = now
+	meta.ModifiedAt = now
+	if len(meta.VersionID) == 0 {
+		meta.VersionID = NewVersionID(now)
+	}
+	err = idx.commitBlob(meta, f.Name(), s.blobPath(id, key, meta.VersionID), s.StoreOpts, latest)
+	if err != nil && partial {
+		// Refused as a whole, there is nothing to resume.
+		s.removePartials(func(p Partial) bool { return p.ID == id && p.Key == key && p.VersionID == meta.VersionID })
+	}
+	return err
 }
 
+// Opens a temporary file in the directory of the key, it is renamed over the
+// final path by writeBlob.
 func (s *Store) openFileForWriting(id string, key string) (*os.File, error) { // os.File implements io.writer interface.
 	pathKey := s.PathTransformFunc(key)
 	pathNameWithRoot := fmt.Sprintf("%s/%s/%s", s.Root, id, pathKey.PathName)
 	if err := os.MkdirAll(pathNameWithRoot, os.ModePerm); err != nil {
 		return nil, err
 	}
+	return os.CreateTemp(pathNameWithRoot, pathKey.Filename+".tmp*")
+}
 
-	fullPathWithRoot := fmt.Sprintf("%s/%s/%s", s.Root, id, pathKey.FullPath())
+type countWriter struct {
+	w io.Writer
+	n int64
+}
 
-	return os.Create(fullPathWithRoot)
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
 }
 
-// Returns file Size, Reader and an error.
+// Returns file Size, Reader of the latest version and an error.
 func (s *Store) Read(id string, key string) (int64, io.Reader, error) {
-	return s.readStream(id, key)
+	meta, err := s.Stat(id, key)
+	if err != nil {
+		return 0, nil, err
+	}
+	return s.readStream(s.blobPath(id, key, meta.VersionID))
 }
 
-func (s *Store) readStream(id string, key string) (int64, io.ReadCloser, error) {
-	pathKey := s.PathTransformFunc(key)
-	fullPathWithRoot := fmt.Sprintf("%s/%s/%s", s.Root, id, pathKey.FullPath())
-
+func (s *Store) readStream(fullPathWithRoot string) (int64, io.ReadCloser, error) {
 	file, err := os.Open(fullPathWithRoot)
 	if err != nil {
 		return 0, nil, err
@@ -2,9 +2,16 @@ package store
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"slices"
 	"testing"
+	"time"
 )
 
 func TestPathTransformFunc(t *testing.T) {
@@ -73,3 +80,480 @@ func teardown(t *testing.T, s *Store) {
 func generateID() string {
 	return "1234"
 }
+
+func TestStoreMeta(t *testing.T) {
+	s := newStore()
+	id := generateID()
+	defer teardown(t, s)
+
+	data := []byte("some jpg bytes")
+	for _, key := range []string{"pics/a.jpg", "pics/b.jpg", "docs/c.txt"} {
+		if _, err := s.Write(id, key, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	meta, err := s.Stat(id, "pics/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Size != int64(len(data)) {
+		t.Errorf("want size %d have %d", len(data), meta.Size)
+	}
+	sum := sha256.Sum256(data)
+	if meta.Digest != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected digest %s", meta.Digest)
+	}
+
+	if err := s.AddReplicas(id, "pics/a.jpg", ":4000", ":3000", ":4000"); err != nil {
+		t.Fatal(err)
+	}
+	meta, _ = s.Stat(id, "pics/a.jpg")
+	if len(meta.Replicas) != 2 {
+		t.Errorf("want 2 replicas have %v", meta.Replicas)
+	}
+
+	metas, err := s.List(id, "pics/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 2 {
+		t.Errorf("want 2 files under pics/ have %d", len(metas))
+	}
+
+	for _, key := range []string{"pics/a.jpg", "pics/b.jpg"} {
+		if err := s.PutGrant(Grant{ID: id, Key: key, Grantee: "bob"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if g, err := s.Grant(id, "pics/a.jpg", "bob"); err != nil || g == nil {
+		t.Errorf("want the grant to bob have %v %v", g, err)
+	}
+
+	if err := s.Delete(id, "pics/a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat(id, "pics/a.jpg"); err != ErrNoMeta {
+		t.Errorf("want %v have %v", ErrNoMeta, err)
+	}
+	if g, _ := s.Grant(id, "pics/a.jpg", "bob"); g != nil {
+		t.Errorf("the grants of a deleted file must be dropped")
+	}
+	if g, _ := s.Grant(id, "pics/b.jpg", "bob"); g == nil {
+		t.Errorf("deleting a key must not drop the grants of other keys")
+	}
+	if !s.Has(id, "pics/b.jpg") {
+		t.Errorf("deleting a key must not remove other keys")
+	}
+	usage, err := s.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage[id] != 2*int64(len(data)) {
+		t.Errorf("want usage %d have %d", 2*len(data), usage[id])
+	}
+}
+
+func TestStoreTombstone(t *testing.T) {
+	s := newStore()
+	id := generateID()
+	defer teardown(t, s)
+
+	key := "report.txt"
+	if _, err := s.Write(id, key, bytes.NewReader([]byte("v1"))); err != nil {
+		t.Fatal(err)
+	}
+	meta, _ := s.Stat(id, key)
+
+	// A tombstone older than the local copy must not delete it.
+	if err := s.Tombstone(id, key, meta.VersionID, meta.ModifiedAt.Add(-time.Minute), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Has(id, key) {
+		t.Errorf("expected to have key %s", key)
+	}
+
+	deletedAt := meta.ModifiedAt.Add(time.Second)
+	if err := s.Tombstone(id, key, meta.VersionID, deletedAt, nil); err != nil {
+		t.Fatal(err)
+	}
+	if s.Has(id, key) {
+		t.Errorf("expected to NOT have key %s", key)
+	}
+	if !s.Tombstoned(id, key, meta.ModifiedAt) {
+		t.Errorf("expected a replica written before the delete to be tombstoned")
+	}
+
+	n, err := s.PurgeTombstones(deletedAt.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || s.Tombstoned(id, key, meta.ModifiedAt) {
+		t.Errorf("expected the tombstone to be purged")
+	}
+}
+
+func TestStoreVersions(t *testing.T) {
+	s := newStore()
+	id := generateID()
+	defer teardown(t, s)
+
+	key := "notes.txt"
+	for _, data := range []string{"v1", "v2"} {
+		if _, err := s.Write(id, key, bytes.NewReader([]byte(data))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	versions, err := s.Versions(id, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].VersionID >= versions[1].VersionID {
+		t.Fatalf("want 2 versions oldest first have %+v", versions)
+	}
+	readAll := func(r io.Reader, err error) string {
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(r)
+		r.(io.Closer).Close()
+		return string(b)
+	}
+	_, r, err := s.Read(id, key)
+	if have := readAll(r, err); have != "v2" {
+		t.Errorf("want the latest version v2 have %s", have)
+	}
+	_, r, err = s.ReadVersion(id, key, versions[0].VersionID)
+	if have := readAll(r, err); have != "v1" {
+		t.Errorf("want v1 have %s", have)
+	}
+
+	// An older version fetched from a peer does not become the latest.
+	tmpl := FileMeta{ID: id, Key: key, VersionID: NewVersionID(time.Unix(0, 0))}
+	if _, err := s.WriteVersion(tmpl, bytes.NewReader([]byte("v0"))); err != nil {
+		t.Fatal(err)
+	}
+	if meta, _ := s.Stat(id, key); meta.VersionID != versions[1].VersionID {
+		t.Errorf("want latest version %s have %s", versions[1].VersionID, meta.VersionID)
+	}
+
+	if err := s.Delete(id, key); err != nil {
+		t.Fatal(err)
+	}
+	if versions, _ := s.Versions(id, key); len(versions) != 0 {
+		t.Errorf("want the versions deleted with the file have %d", len(versions))
+	}
+}
+
+func TestStoreNamespace(t *testing.T) {
+	s := newStore()
+	id := generateID()
+	defer teardown(t, s)
+
+	for _, key := range []string{"a/report.txt", "b/report.txt"} {
+		for _, data := range []string{key + " v1", key + " v2"} {
+			if _, err := s.Write(id, key, bytes.NewReader([]byte(data))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := s.Mkdir(id, "a/report.txt/x"); !errors.Is(err, ErrNotDir) {
+		t.Errorf("want %v making a directory below a file have %v", ErrNotDir, err)
+	}
+	if err := s.CheckFile(id, "a"); !errors.Is(err, ErrIsDir) {
+		t.Errorf("want %v storing a file over a directory have %v", ErrIsDir, err)
+	}
+	if err := s.Mkdir(id, "c/d"); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsDir(id, "c") || s.IsDir(id, "c/d/e") {
+		t.Error("want c to be a directory and c/d/e not")
+	}
+
+	if err := s.Rename(id, "a/report.txt", "b/report.txt"); !errors.Is(err, ErrExist) {
+		t.Errorf("want %v renaming over a file have %v", ErrExist, err)
+	}
+	if err := s.Rename(id, "a/report.txt", "c/d/report.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Has(id, "a/report.txt") || s.IsDir(id, "a") {
+		t.Error("want a/report.txt gone after the rename")
+	}
+	versions, err := s.Versions(id, "c/d/report.txt")
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("want the 2 versions moved along have %d: %v", len(versions), err)
+	}
+	_, r, err := s.ReadVersion(id, "c/d/report.txt", versions[0].VersionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(r)
+	r.(io.Closer).Close()
+	if string(b) != "a/report.txt v1" {
+		t.Errorf("want the first version of a/report.txt have %s", b)
+	}
+
+	if err := s.RenameDirs(id, "c", "e"); err != nil {
+		t.Fatal(err)
+	}
+	if dirs, _ := s.Dirs(id, ""); len(dirs) != 2 || dirs[0] != "e" || dirs[1] != "e/d" {
+		t.Errorf("want the directories e and e/d have %v", dirs)
+	}
+}
+
+// failingReader fails once n bytes were read, like a connection dropping.
+type failingReader struct {
+	r io.Reader
+	n int
+}
+
+func (f *failingReader) Read(b []byte) (int, error) {
+	if f.n == 0 {
+		return 0, errors.New("connection reset")
+	}
+	if len(b) > f.n {
+		b = b[:f.n]
+	}
+	n, err := f.r.Read(b)
+	f.n -= n
+	return n, err
+}
+
+func TestStorePartial(t *testing.T) {
+	s := newStore()
+	id := generateID()
+	defer teardown(t, s)
+
+	data := bytes.Repeat([]byte("resumable "), 1000)
+	tmpl := FileMeta{ID: id, Key: "log.txt", VersionID: NewVersionID(time.Now())}
+	if _, err := s.WriteSealedAt(tmpl, 0, &failingReader{r: bytes.NewReader(data), n: 4000}); err == nil {
+		t.Fatal("want the broken transfer to fail")
+	}
+	offset := s.PartialSize(id, tmpl.Key, tmpl.VersionID)
+	if offset != 4000 || s.Has(id, tmpl.Key) {
+		t.Fatalf("want 4000 bytes kept aside have %d", offset)
+	}
+	if _, err := s.WriteSealedAt(tmpl, offset+1, bytes.NewReader(data[offset+1:])); !errors.Is(err, ErrNoPartial) {
+		t.Errorf("want %v resuming past the partial blob have %v", ErrNoPartial, err)
+	}
+
+	sum := sha256.Sum256(data)
+	tmpl.Digest = hex.EncodeToString(sum[:])
+	meta, err := s.WriteSealedAt(tmpl, offset, bytes.NewReader(data[offset:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Size != int64(len(data)) || meta.Digest != tmpl.Digest {
+		t.Errorf("want the whole blob have %d bytes with digest %s", meta.Size, meta.Digest)
+	}
+	if partials, _ := s.Partials(id); len(partials) != 0 || s.PartialSize(id, tmpl.Key, tmpl.VersionID) != 0 {
+		t.Errorf("want the partial blob gone have %v", partials)
+	}
+
+	// A resumed blob not matching the digest it was sent with is dropped.
+	forged := tmpl
+	forged.Key = "other.txt"
+	s.WriteSealedAt(forged, 0, &failingReader{r: bytes.NewReader(data), n: 4000})
+	tampered := bytes.Clone(data)
+	tampered[5000] ^= 1
+	if _, err := s.WriteSealedAt(forged, 4000, bytes.NewReader(tampered[4000:])); !errors.Is(err, ErrBlobDigest) {
+		t.Errorf("want %v have %v", ErrBlobDigest, err)
+	}
+	if s.Has(id, forged.Key) || s.PartialSize(id, forged.Key, forged.VersionID) != 0 {
+		t.Error("want the forged blob dropped")
+	}
+}
+
+func TestStoreChunks(t *testing.T) {
+	a := NewStore(StoreOpts{Root: "ggnetwork_a", PathTransformFunc: CASPathTransformFunc})
+	b := NewStore(StoreOpts{Root: "ggnetwork_b", PathTransformFunc: CASPathTransformFunc})
+	defer teardown(t, a)
+	defer teardown(t, b)
+
+	id := generateID()
+	data := make([]byte, 2*ChunkSize+1000)
+	rand.Read(data)
+	src, err := a.WriteSealed(FileMeta{ID: id, Key: "video.mp4"}, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(src.ChunkDigests) != 3 {
+		t.Fatalf("want 3 chunk digests have %d", len(src.ChunkDigests))
+	}
+	chunk := func(i int) ([]byte, int64) {
+		off, n := ChunkRange(src.Size, i)
+		return append([]byte(nil), data[off:off+n]...), off
+	}
+
+	// The second chunk is corrupted, the first one is kept to resume.
+	_, err = b.WriteChunks(*src, true, func(w io.WriterAt, missing []int) error {
+		for _, i := range missing {
+			c, off := chunk(i)
+			if i == 1 {
+				c[0]++
+			}
+			if _, err := w.WriteAt(c, off); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if !errors.Is(err, ErrChunkDigest) {
+		t.Fatalf("want %v have %v", ErrChunkDigest, err)
+	}
+	if size := b.PartialSize(id, src.Key, src.VersionID); size != ChunkSize {
+		t.Errorf("want the first chunk kept have %d bytes", size)
+	}
+
+	meta, err := b.WriteChunks(*src, true, func(w io.WriterAt, missing []int) error {
+		if !slices.Equal(missing, []int{1, 2}) {
+			t.Errorf("want chunks 1 and 2 missing have %v", missing)
+		}
+		for j := len(missing) - 1; j >= 0; j-- {
+			c, off := chunk(missing[j])
+			if _, err := w.WriteAt(c, off); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, r, err := b.Read(id, src.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(r)
+	r.(io.Closer).Close()
+	if !bytes.Equal(got, data) || meta.Digest != src.Digest {
+		t.Error("want the blob written from its chunks")
+	}
+
+	// Chunks matching their digests do not make up for a blob digest which
+	// does not match.
+	forged := *src
+	forged.Key, forged.Digest = "other.mp4", hex.EncodeToString(make([]byte, sha256.Size))
+	_, err = b.WriteChunks(forged, true, func(w io.WriterAt, missing []int) error {
+		for _, i := range missing {
+			c, off := chunk(i)
+			if _, err := w.WriteAt(c, off); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if !errors.Is(err, ErrBlobDigest) {
+		t.Fatalf("want %v have %v", ErrBlobDigest, err)
+	}
+	if b.Has(id, forged.Key) || b.PartialSize(id, forged.Key, forged.VersionID) != 0 {
+		t.Error("want the forged blob dropped")
+	}
+}
+
+func TestStoreConflicts(t *testing.T) {
+	for _, policy := range []ConflictPolicy{ConflictLWW, ConflictKeepBoth} {
+		t.Run(string(policy), func(t *testing.T) {
+			a := NewStore(StoreOpts{Root: "ggnetwork_a", PathTransformFunc: CASPathTransformFunc, ConflictPolicy: policy})
+			b := NewStore(StoreOpts{Root: "ggnetwork_b", PathTransformFunc: CASPathTransformFunc, ConflictPolicy: policy})
+			defer teardown(t, a)
+			defer teardown(t, b)
+
+			id, key := generateID(), "draft.txt"
+			write := func(s *Store, tmpl FileMeta, data string) *FileMeta {
+				meta, err := s.WriteSealed(tmpl, bytes.NewReader([]byte(data)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return meta
+			}
+			base := write(a, FileMeta{ID: id, Key: key}, "base")
+			write(b, *base, "base")
+
+			// Both nodes write the key before hearing of the other write.
+			fromA := write(a, FileMeta{ID: id, Key: key}, "from a")
+			fromB := write(b, FileMeta{ID: id, Key: key}, "from b")
+			if !fromA.Clock.Concurrent(fromB.Clock) {
+				t.Fatalf("want concurrent clocks have %v and %v", fromA.Clock, fromB.Clock)
+			}
+			write(a, *fromB, "from b")
+			write(b, *fromA, "from a")
+
+			latestA, _ := a.Stat(id, key)
+			latestB, _ := b.Stat(id, key)
+			if latestA.VersionID != fromB.VersionID || latestB.VersionID != fromB.VersionID {
+				t.Errorf("want the latest write %s on both nodes have %s and %s", fromB.VersionID, latestA.VersionID, latestB.VersionID)
+			}
+			siblings := 0
+			if policy == ConflictKeepBoth {
+				siblings = 1
+			}
+			if len(latestA.Siblings) != siblings || len(latestB.Siblings) != siblings {
+				t.Errorf("want %d siblings have %v and %v", siblings, latestA.Siblings, latestB.Siblings)
+			}
+
+			// The base version arriving late stays in the history.
+			write(a, *base, "base")
+			if latest, _ := a.Stat(id, key); latest.VersionID != fromB.VersionID {
+				t.Errorf("a superseded version became the latest one")
+			}
+			// A write following all the versions settles the conflict.
+			if next := write(a, FileMeta{ID: id, Key: key}, "merged"); len(next.Siblings) != 0 {
+				t.Errorf("want no siblings have %v", next.Siblings)
+			}
+		})
+	}
+}
+
+func TestStoreQuota(t *testing.T) {
+	s := newStore()
+	s.Quotas = Quotas{Node: 20, Owner: 10, Owners: map[string]int64{"bob": 15}}
+	defer teardown(t, s)
+
+	write := func(id string, key string, size int) error {
+		_, err := s.Write(id, key, bytes.NewReader(make([]byte, size)))
+		return err
+	}
+	if err := write("alice", "a", 6); err != nil {
+		t.Fatal(err)
+	}
+	if err := write("alice", "b", 6); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("want %v have %v", ErrQuotaExceeded, err)
+	}
+	if s.Has("alice", "b") {
+		t.Errorf("a write over the quota must not be kept")
+	}
+	// The previous versions of a key still count.
+	if err := write("alice", "a", 4); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := write("alice", "a", 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("want %v have %v", ErrQuotaExceeded, err)
+	}
+	if err := s.CheckQuota("bob", 12); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("want the node quota to be exceeded have %v", err)
+	}
+	if err := write("bob", "c", 10); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if err := s.Delete("alice", "a"); err != nil {
+		t.Fatal(err)
+	}
+	usage, err := s.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage["alice"] != 0 || usage["bob"] != 10 {
+		t.Errorf("unexpected usage %v", usage)
+	}
+
+	// No disk has that much free space.
+	s.Quotas = Quotas{MinFree: 1 << 62}
+	if _, err := s.DiskSpace(); err != nil {
+		t.Skipf("free space unknown: %v", err)
+	}
+	if err := write("alice", "d", 1); !errors.Is(err, ErrDiskFull) {
+		t.Errorf("want %v have %v", ErrDiskFull, err)
+	}
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ranjankuldeep/distributed_file_system/auth"
+	bolt "go.etcd.io/bbolt"
+)
+
+var tombstonesBucket = []byte("tombstones")
+
+// Tombstone records the deletion of a key. It is kept for a grace period so
+// that a replica which missed the delete does not bring the file back.
+type Tombstone struct {
+	ID        string    `json:"id"`
+	Key       string    `json:"key"`
+	VersionID string    `json:"version_id,omitempty"` // Latest version when deleted.
+	DeletedAt time.Time `json:"deleted_at"`
+	// Token of the owner allowing this very delete, handed on with the
+	// tombstone.
+	Token *auth.Token `json:"token,omitempty"`
+}
+
+func getTombstone(tx *bolt.Tx, id string, key string) (*Tombstone, error) {
+	b := tx.Bucket(tombstonesBucket).Bucket([]byte(id))
+	if b == nil {
+		return nil, nil
+	}
+	v := b.Get([]byte(key))
+	if v == nil {
+		return nil, nil
+	}
+	t := &Tombstone{}
+	if err := json.Unmarshal(v, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func putTombstone(tx *bolt.Tx, t *Tombstone) error {
+	b, err := tx.Bucket(tombstonesBucket).CreateBucketIfNotExists([]byte(t.ID))
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(t.Key), v)
+}
+
+func deleteTombstone(tx *bolt.Tx, id string, key string) error {
+	b := tx.Bucket(tombstonesBucket).Bucket([]byte(id))
+	if b == nil {
+		return nil
+	}
+	return b.Delete([]byte(key))
+}
+
+// Tombstone deletes the key if its local copy is older than deletedAt and
+// records the deletion of its version versionID. Applying the same or an
+// older tombstone twice is a no-op.
+func (s *Store) Tombstone(id string, key string, versionID string, deletedAt time.Time, token *auth.Token) error {
+	idx, err := s.index()
+	if err != nil {
+		return err
+	}
+	deleted := false
+	err = idx.db.Update(func(tx *bolt.Tx) error {
+		old, err := getTombstone(tx, id, key)
+		if err != nil {
+			return err
+		}
+		if old != nil && !old.DeletedAt.Before(deletedAt) {
+			return nil
+		}
+		meta, err := getMeta(tx, id, key)
+		if err != nil && err != ErrNoMeta {
+			return err
+		}
+		if meta != nil && meta.ModifiedAt.After(deletedAt) {
+			// Written again after the delete, the tombstone is obsolete.
+			return nil
+		}
+		deleted = true
+		return putTombstone(tx, &Tombstone{ID: id, Key: key, VersionID: versionID, DeletedAt: deletedAt, Token: token})
+	})
+	if err != nil || !deleted {
+		return err
+	}
+	return s.Delete(id, key)
+}
+
+// Tombstoned reports whether the key was deleted after modifiedAt.
+func (s *Store) Tombstoned(id string, key string, modifiedAt time.Time) bool {
+	idx, err := s.index()
+	if err != nil {
+		return false
+	}
+	var t *Tombstone
+	idx.db.View(func(tx *bolt.Tx) error {
+		t, err = getTombstone(tx, id, key)
+		return err
+	})
+	return t != nil && t.DeletedAt.After(modifiedAt)
+}
+
+// Tombstones returns all the tombstones of every owner.
+func (s *Store) Tombstones() ([]Tombstone, error) {
+	idx, err := s.index()
+	if err != nil {
+		return nil, err
+	}
+	tombstones := []Tombstone{}
+	err = idx.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tombstonesBucket).ForEach(func(id, _ []byte) error {
+			return tx.Bucket(tombstonesBucket).Bucket(id).ForEach(func(_, v []byte) error {
+				t := Tombstone{}
+				if err := json.Unmarshal(v, &t); err != nil {
+					return err
+				}
+				tombstones = append(tombstones, t)
+				return nil
+			})
+		})
+	})
+	return tombstones, err
+}
+
+// PurgeTombstones garbage collects the tombstones recorded before the given
+// time and returns how many were removed.
+func (s *Store) PurgeTombstones(before time.Time) (int, error) {
+	tombstones, err := s.Tombstones()
+	if err != nil {
+		return 0, err
+	}
+	idx, err := s.index()
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	err = idx.db.Update(func(tx *bolt.Tx) error {
+		for _, t := range tombstones {
+			if !t.DeletedAt.Before(before) {
+				continue
+			}
+			if err := deleteTombstone(tx, t.ID, t.Key); err != nil {
+				return fmt.Errorf("purging tombstone of %s: %w", t.Key, err)
+			}
+			purged++
+		}
+		return nil
+	})
+	return purged, err
+}
+
+// A write newer than the tombstone of its key makes the tombstone obsolete.
+func clearTombstone(tx *bolt.Tx, meta *FileMeta) error {
+	t, err := getTombstone(tx, meta.ID, meta.Key)
+	if err != nil || t == nil || t.DeletedAt.After(meta.ModifiedAt) {
+		return err
+	}
+	return deleteTombstone(tx, meta.ID, meta.Key)
+}
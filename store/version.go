@@ -0,0 +1,239 @@
+package store
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Every write of a key is kept as a version of its own, the files bucket
+// only points at the latest one.
+var versionsBucket = []byte("versions")
+
+// NewVersionID returns the ID of a version written at t. The versions of a
+// key sort in the order they were written, on every node as the ID is the
+// one of the owner.
+func NewVersionID(t time.Time) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%016x%s", t.UnixNano(), hex.EncodeToString(b))
+}
+
+// The versions of an owner are keyed by the file key followed by the
+// version ID, so the ones of a key are next to each other, oldest first.
+func versionKey(key string, versionID string) []byte {
+	return []byte(key + "\x00" + versionID)
+}
+
+func getVersion(tx *bolt.Tx, id string, key string, versionID string) (*FileMeta, error) {
+	b := tx.Bucket(versionsBucket).Bucket([]byte(id))
+	if b == nil {
+		return nil, ErrNoMeta
+	}
+	v := b.Get(versionKey(key, versionID))
+	if v == nil {
+		return nil, ErrNoMeta
+	}
+	meta := &FileMeta{}
+	if err := json.Unmarshal(v, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func putVersion(tx *bolt.Tx, meta *FileMeta) error {
+	b, err := tx.Bucket(versionsBucket).CreateBucketIfNotExists([]byte(meta.ID))
+	if err != nil {
+		return err
+	}
+	version := *meta
+	// Only tracked for the latest version.
+	version.Replicas, version.Siblings = nil, nil
+	v, err := json.Marshal(version)
+	if err != nil {
+		return err
+	}
+	return b.Put(versionKey(meta.Key, meta.VersionID), v)
+}
+
+func keyVersions(tx *bolt.Tx, id string, key string) ([]FileMeta, error) {
+	versions := []FileMeta{}
+	b := tx.Bucket(versionsBucket).Bucket([]byte(id))
+	if b == nil {
+		return versions, nil
+	}
+	prefix := versionKey(key, "")
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		meta := FileMeta{}
+		if err := json.Unmarshal(v, &meta); err != nil {
+			return nil, err
+		}
+		versions = append(versions, meta)
+	}
+	return versions, nil
+}
+
+// deleteVersions drops the records of all the versions of the key and
+// returns them, so that their blobs can be removed.
+func deleteVersions(tx *bolt.Tx, id string, key string) ([]FileMeta, error) {
+	versions, err := keyVersions(tx, id, key)
+	if err != nil || len(versions) == 0 {
+		return versions, err
+	}
+	b := tx.Bucket(versionsBucket).Bucket([]byte(id))
+	for _, v := range versions {
+		if err := b.Delete(versionKey(key, v.VersionID)); err != nil {
+			return nil, err
+		}
+		if err := addUsage(tx, id, -v.Size); err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
+// migrateVersions turns the blobs written before the versions into the
+// first version of their key. The ID is derived from the modification time,
+// so an interrupted migration picks up the blobs it already moved.
+func migrateVersions(tx *bolt.Tx, blobPath func(id string, key string, versionID string) string) error {
+	files := tx.Bucket(filesBucket)
+	return files.ForEach(func(id, _ []byte) error {
+		metas := []FileMeta{}
+		err := files.Bucket(id).ForEach(func(_, v []byte) error {
+			meta := FileMeta{}
+			if err := json.Unmarshal(v, &meta); err != nil {
+				return err
+			}
+			metas = append(metas, meta)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, meta := range metas {
+			if len(meta.VersionID) > 0 {
+				continue
+			}
+			meta.VersionID = fmt.Sprintf("%016x%08x", meta.ModifiedAt.UnixNano(), 0)
+			err := os.Rename(blobPath(meta.ID, meta.Key, ""), blobPath(meta.ID, meta.Key, meta.VersionID))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			if err := putMeta(tx, &meta); err != nil {
+				return err
+			}
+			if err := putVersion(tx, &meta); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Versions returns the versions of the file recorded on this node, oldest
+// first.
+func (s *Store) Versions(id string, key string) ([]FileMeta, error) {
+	idx, err := s.index()
+	if err != nil {
+		return nil, err
+	}
+	var versions []FileMeta
+	err = idx.db.View(func(tx *bolt.Tx) error {
+		versions, err = keyVersions(tx, id, key)
+		return err
+	})
+	return versions, err
+}
+
+// StatVersion returns the metadata of a version of the file.
+func (s *Store) StatVersion(id string, key string, versionID string) (*FileMeta, error) {
+	idx, err := s.index()
+	if err != nil {
+		return nil, err
+	}
+	var meta *FileMeta
+	err = idx.db.View(func(tx *bolt.Tx) error {
+		meta, err = getVersion(tx, id, key, versionID)
+		return err
+	})
+	return meta, err
+}
+
+// HasVersion reports whether the blob of a version of the file is on disk.
+func (s *Store) HasVersion(id string, key string, versionID string) bool {
+	if _, err := s.StatVersion(id, key, versionID); err != nil {
+		return false
+	}
+	_, err := os.Stat(s.blobPath(id, key, versionID))
+	return err == nil
+}
+
+// ReadVersion returns the size and a reader of a version of the file, the
+// reader must be closed.
+func (s *Store) ReadVersion(id string, key string, versionID string) (int64, io.Reader, error) {
+	if _, err := s.StatVersion(id, key, versionID); err != nil {
+		return 0, nil, err
+	}
+	return s.readStream(s.blobPath(id, key, versionID))
+}
+
+// ReadRange returns the size of the blob of a version of the file and a
+// reader of length bytes of it from offset on, up to its end when length is
+// zero. The reader must be closed.
+func (s *Store) ReadRange(id string, key string, versionID string, offset int64, length int64) (int64, io.ReadCloser, error) {
+	if offset < 0 || length < 0 {
+		return 0, nil, fmt.Errorf("store: invalid range %d+%d of %s", offset, length, key)
+	}
+	if _, err := s.StatVersion(id, key, versionID); err != nil {
+		return 0, nil, err
+	}
+	file, err := os.Open(s.blobPath(id, key, versionID))
+	if err != nil {
+		return 0, nil, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return 0, nil, err
+	}
+	size := fi.Size()
+	offset = min(offset, size)
+	if length == 0 || length > size-offset {
+		length = size - offset
+	}
+	return size, sectionReader{io.NewSectionReader(file, offset, length), file}, nil
+}
+
+// sectionReader reads a section of a blob and closes it.
+type sectionReader struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// WriteVersion is WriteSealed for a version which does not become the
+// latest one, fetched from a peer to be read.
+func (s *Store) WriteVersion(tmpl FileMeta, r io.Reader) (*FileMeta, error) {
+	return s.WriteVersionAt(tmpl, 0, r)
+}
+
+// WriteVersionAt is WriteVersion resuming a transfer, r holding the bytes of
+// the blob from offset on. See WriteSealedAt.
+func (s *Store) WriteVersionAt(tmpl FileMeta, offset int64, r io.Reader) (*FileMeta, error) {
+	err := s.writeBlob(&tmpl, false, offset, func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tmpl, nil
+}
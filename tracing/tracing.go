@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Exporters the spans can be sent to.
+const (
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+const serviceName = "dfs"
+
+type Options struct {
+	Exporter string
+	// Address of the OTLP/HTTP receiver of the collector, eg- localhost:4318.
+	// The OTEL_EXPORTER_OTLP_* environment variables apply when empty.
+	Endpoint string
+	// ID of the node, attached to all of its spans.
+	NodeID string
+}
+
+// Setup installs the global tracer provider and the W3C trace context
+// propagator. The returned func flushes the pending spans and must be
+// called before exiting.
+func Setup(opts Options) (shutdown func(context.Context) error, err error) {
+	ctx := context.Background()
+	var exporter sdktrace.SpanExporter
+	switch opts.Exporter {
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case ExporterOTLP:
+		clientOpts := []otlptracehttp.Option{otlptracehttp.WithInsecure()} // A local collector.
+		if len(opts.Endpoint) > 0 {
+			clientOpts = append(clientOpts, otlptracehttp.WithEndpoint(opts.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, clientOpts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, want %s or %s", opts.Exporter, ExporterStdout, ExporterOTLP)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx, resource.WithAttributes(
+		semconv.ServiceName(serviceName),
+		attribute.String("dfs.node.id", opts.NodeID),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}